@@ -15,6 +15,15 @@ import (
 	"github.com/joho/godotenv"
 )
 
+// deletedUserID is the tombstone sender that a deleted account's messages are handed over to
+const deletedUserID = "00000000-0000-0000-0000-000000000000"
+
+// UserEvent is what user-service publishes on the user events topic
+type UserEvent struct {
+	Type   string `json:"type"`
+	UserID string `json:"user_id"`
+}
+
 // Message represents the chat message structure from Kafka
 type Message struct {
 	ConversationID int       `json:"conversation_id"`
@@ -66,7 +75,12 @@ func main() {
 	}
 	defer consumer.Close()
 
-	err = consumer.SubscribeTopics([]string{"chat-conversation"}, nil)
+	userEventsTopic := os.Getenv("USER_EVENTS_TOPIC")
+	if userEventsTopic == "" {
+		userEventsTopic = "user-events"
+	}
+
+	err = consumer.SubscribeTopics([]string{"chat-conversation", userEventsTopic}, nil)
 	if err != nil {
 		log.Fatalf("❌ Kafka subscription error: %v", err)
 	}
@@ -84,6 +98,23 @@ func main() {
 				continue
 			}
 
+			if msg.TopicPartition.Topic != nil && *msg.TopicPartition.Topic == userEventsTopic {
+				var event UserEvent
+				if err := json.Unmarshal(msg.Value, &event); err != nil {
+					log.Printf("❌ Failed to unmarshal user event: %v", err)
+					continue
+				}
+
+				if event.Type == "account.deleted" {
+					if err := anonymizeMessages(ctx, pool, event.UserID); err != nil {
+						log.Printf("❌ Failed to anonymize messages: %v", err)
+					} else {
+						log.Printf("✅ Messages anonymized: [user_id=%s]", event.UserID)
+					}
+				}
+				continue
+			}
+
 			var chat Message
 			if err := json.Unmarshal(msg.Value, &chat); err != nil {
 				log.Printf("❌ Failed to unmarshal Kafka message: %v", err)
@@ -108,3 +139,11 @@ func insertMessage(ctx context.Context, pool *pgxpool.Pool, msg *Message) error
 	_, err := pool.Exec(ctx, query, msg.ConversationID, msg.SenderID, msg.Text, msg.CreatedAt)
 	return err
 }
+
+// anonymizeMessages tombstones whatever the deleted user still had in flight when user-service purged the account
+func anonymizeMessages(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	query := `UPDATE messages SET sender_id = $1 WHERE sender_id = $2`
+
+	_, err := pool.Exec(ctx, query, deletedUserID, userID)
+	return err
+}
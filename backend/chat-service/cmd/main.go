@@ -48,7 +48,13 @@ func main() {
 	}
 	defer consumer.Close()
 
-	err = consumer.SubscribeTopics([]string{"chat-conversation"}, nil)
+	// user events carry recipient_ids like chat messages do, so they fan out to the same buckets
+	userEventsTopic := os.Getenv("USER_EVENTS_TOPIC")
+	if userEventsTopic == "" {
+		userEventsTopic = "user-events"
+	}
+
+	err = consumer.SubscribeTopics([]string{"chat-conversation", userEventsTopic}, nil)
 	if err != nil {
 		log.Fatalf("Subscribe failed: %v", err)
 	}
@@ -42,13 +42,28 @@ func main() {
 	koanf := config.NewKoanf(zap)
 	rdb := config.NewRedisCluster(koanf, zap)
 	postgresql := config.NewPostgresqlPool(koanf, zap)
+	keySet := config.NewKeySet(koanf, zap)
+	mailer := config.NewMailer(koanf, zap)
+	oidcProvider := config.NewOidcProvider(koanf, zap)
+	trustedProxies := config.NewTrustedProxies(koanf, zap)
+	kafkaProducer := config.NewKafkaProducer(koanf, zap)
+	defer kafkaProducer.Close()
+
+	// background workers run until a stop signal arrives
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
 
 	config.Server(&config.ServerConfig{
-		Router:  httprouter,
-		DB:      postgresql,
-		DBCache: rdb,
-		Log:     zap,
-		Config:  koanf,
+		Context:      workerCtx,
+		Router:       httprouter,
+		DB:           postgresql,
+		DBCache:      rdb,
+		Log:          zap,
+		Config:       koanf,
+		KeySet:       keySet,
+		Mailer:       mailer,
+		Kafka:        kafkaProducer,
+		OidcProvider: oidcProvider,
 	})
 
 	//httprouter.POST("/api/conversation", handlers.AuthMiddleware(handlers.CreateConversation))
@@ -65,7 +80,7 @@ func main() {
 
 	server := http.Server{
 		Addr:    GO_SERVER_PORT,
-		Handler: CORS(httprouter),
+		Handler: CORS(trustedProxies.RealIP(httprouter)),
 	}
 
 	zap.Info("Server is running on: " + GO_SERVER_PORT)
@@ -81,6 +96,7 @@ func main() {
 
 	<-stop
 	zap.Info("Got one of stop signals")
+	stopWorkers()
 
 	if err := server.Shutdown(ctx); err != nil {
 		zap.Warn("Timeout, forced kill!", zapLog.Error(err))
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS refresh_tokens_family_id_idx;
+DROP INDEX IF EXISTS refresh_tokens_hashed_refresh_token_idx;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,5 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id char(36);
+UPDATE refresh_tokens SET family_id = user_id WHERE family_id IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS refresh_tokens_hashed_refresh_token_idx ON refresh_tokens(hashed_refresh_token);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens(family_id);
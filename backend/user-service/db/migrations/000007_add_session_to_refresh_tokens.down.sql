@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS refresh_tokens_user_id_status_idx;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS device_label;
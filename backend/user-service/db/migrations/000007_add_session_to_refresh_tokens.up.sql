@@ -0,0 +1,7 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device_label varchar(100) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip_address varchar(45) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp;
+UPDATE refresh_tokens SET last_used_at = created_at WHERE last_used_at IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_status_idx ON refresh_tokens(user_id, status);
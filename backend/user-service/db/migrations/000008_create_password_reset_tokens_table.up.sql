@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS password_reset_tokens(
+    id serial PRIMARY KEY,
+    user_id char(36) NOT NULL,
+    hashed_token char(64) UNIQUE NOT NULL,
+    created_at timestamp NOT NULL,
+    expired_at timestamp NOT NULL,
+    used_at timestamp,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
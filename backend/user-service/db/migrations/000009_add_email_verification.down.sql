@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_verification_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
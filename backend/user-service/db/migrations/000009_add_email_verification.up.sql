@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email varchar(254) UNIQUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamp;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens(
+    id serial PRIMARY KEY,
+    user_id char(36) NOT NULL,
+    email varchar(254) NOT NULL,
+    hashed_token char(64) UNIQUE NOT NULL,
+    created_at timestamp NOT NULL,
+    expired_at timestamp NOT NULL,
+    used_at timestamp,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret varchar(128);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes(
+    id serial PRIMARY KEY,
+    user_id char(36) NOT NULL,
+    hashed_code char(64) NOT NULL,
+    created_at timestamp NOT NULL,
+    used_at timestamp,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes(user_id);
@@ -0,0 +1,2 @@
+-- only safe once every hash is back to bcrypt, argon2id hashes don't fit in 60 characters
+ALTER TABLE users ALTER COLUMN password TYPE varchar(60);
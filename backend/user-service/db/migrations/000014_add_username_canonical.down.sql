@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS username_history;
+DROP INDEX IF EXISTS users_username_skeleton_idx;
+DROP INDEX IF EXISTS users_username_canonical_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS username_skeleton;
+ALTER TABLE users DROP COLUMN IF EXISTS username_canonical;
@@ -0,0 +1,37 @@
+-- usernames that only differ by case become the same account name, they have to be renamed by hand first
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users GROUP BY lower(normalize(username, NFKC)) HAVING count(*) > 1) THEN
+        RAISE EXCEPTION 'usernames differing only by case exist, rename them before running this migration';
+    END IF;
+END $$;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_canonical varchar(40);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_skeleton varchar(40);
+
+-- lower() agrees with the service's case folding except for a handful of letters like ß,
+-- and the translate() table mirrors helper.confusables
+UPDATE users SET username_canonical = lower(normalize(username, NFKC));
+UPDATE users SET username_skeleton = replace(replace(translate(username_canonical,
+    '01iıаеорсухіјѕԁɡοανρ',
+    'olllaeopcyxljsdgoavp'), 'rn', 'm'), 'vv', 'w');
+
+ALTER TABLE users ALTER COLUMN username_canonical SET NOT NULL;
+ALTER TABLE users ALTER COLUMN username_skeleton SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_canonical_idx ON users(username_canonical);
+CREATE INDEX IF NOT EXISTS users_username_skeleton_idx ON users(username_skeleton);
+
+CREATE TABLE IF NOT EXISTS username_history(
+    id serial PRIMARY KEY,
+    user_id char(36) NOT NULL,
+    username varchar(40) NOT NULL,
+    username_canonical varchar(40) NOT NULL,
+    username_skeleton varchar(40) NOT NULL,
+    changed_at timestamp NOT NULL,
+    held_until timestamp NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS username_history_skeleton_idx ON username_history(username_skeleton, held_until);
+CREATE INDEX IF NOT EXISTS username_history_user_id_idx ON username_history(user_id, changed_at);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS contact_requests(
+    id char(36) PRIMARY KEY,
+    requester_id char(36) NOT NULL,
+    addressee_id char(36) NOT NULL,
+    status varchar(10) NOT NULL,
+    created_at timestamp NOT NULL,
+    responded_at timestamp,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (addressee_id) REFERENCES users(id) ON DELETE CASCADE,
+    CHECK (requester_id <> addressee_id)
+);
+
+-- at most one open request between two people, whichever direction it goes
+CREATE UNIQUE INDEX IF NOT EXISTS contact_requests_pending_pair_idx
+    ON contact_requests (LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id))
+    WHERE status = 'Pending';
+CREATE INDEX IF NOT EXISTS contact_requests_addressee_idx ON contact_requests(addressee_id, status);
+CREATE INDEX IF NOT EXISTS contact_requests_requester_idx ON contact_requests(requester_id, status);
+
+-- one row per direction so listing someone's contacts is a single index scan
+CREATE TABLE IF NOT EXISTS contacts(
+    user_id char(36) NOT NULL,
+    contact_id char(36) NOT NULL,
+    created_at timestamp NOT NULL,
+    PRIMARY KEY (user_id, contact_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (contact_id) REFERENCES users(id) ON DELETE CASCADE
+);
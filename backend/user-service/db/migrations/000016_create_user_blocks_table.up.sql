@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_blocks(
+    blocker_id char(36) NOT NULL,
+    blocked_id char(36) NOT NULL,
+    created_at timestamp NOT NULL,
+    PRIMARY KEY (blocker_id, blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE,
+    CHECK (blocker_id <> blocked_id)
+);
+
+-- blocks are checked in both directions, this covers the "who blocked me" side
+CREATE INDEX IF NOT EXISTS user_blocks_blocked_idx ON user_blocks(blocked_id, blocker_id);
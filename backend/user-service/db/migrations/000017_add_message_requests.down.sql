@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS conversation_reports;
+DROP INDEX IF EXISTS conversation_participants_user_status_idx;
+ALTER TABLE conversation_participants DROP COLUMN IF EXISTS responded_at;
+ALTER TABLE conversation_participants DROP COLUMN IF EXISTS status;
@@ -0,0 +1,15 @@
+-- a participant only sees a conversation as a chat once their row is Accepted; DMs from non-contacts
+-- start Pending for the recipient and show up as message requests instead
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS status varchar(10) NOT NULL DEFAULT 'Accepted';
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS responded_at timestamp;
+CREATE INDEX IF NOT EXISTS conversation_participants_user_status_idx ON conversation_participants(user_id, status);
+
+CREATE TABLE IF NOT EXISTS conversation_reports(
+    id SERIAL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL,
+    reporter_id char(36) NOT NULL,
+    reported_id char(36) NOT NULL,
+    created_at timestamp NOT NULL,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS conversation_reports_reported_idx ON conversation_reports(reported_id, created_at);
@@ -0,0 +1,5 @@
+-- NOT VALID because tombstoned messages no longer point at a user
+ALTER TABLE messages ADD CONSTRAINT messages_sender_id_fkey FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE NOT VALID;
+DROP INDEX IF EXISTS users_deletion_scheduled_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_requested_at;
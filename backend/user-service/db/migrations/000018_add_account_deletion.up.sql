@@ -0,0 +1,8 @@
+-- a requested deletion waits out a grace period and is purged by the account purge worker once it is due
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at timestamp;
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_at_idx ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+-- messages belong to the conversation as much as to their author, a deleted account hands them over to the
+-- tombstone sender 00000000-0000-0000-0000-000000000000 instead of taking everyone's history down with it
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_sender_id_fkey;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS data_exports(
+    id char(36) PRIMARY KEY,
+    user_id char(36) NOT NULL,
+    status varchar(10) NOT NULL,
+    file_path varchar(255) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    completed_at timestamp,
+    expires_at timestamp,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_idx ON data_exports(user_id, created_at);
+-- one export at a time per user
+CREATE UNIQUE INDEX IF NOT EXISTS data_exports_pending_idx ON data_exports(user_id) WHERE status = 'Pending';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_identities(
+    issuer varchar(255) NOT NULL,
+    subject varchar(255) NOT NULL,
+    user_id char(36) NOT NULL,
+    email varchar(255) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    last_login_at timestamp NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities(user_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS passkeys(
+    id char(36) PRIMARY KEY,
+    user_id char(36) NOT NULL,
+    credential_id bytea NOT NULL,
+    public_key bytea NOT NULL,
+    sign_count bigint NOT NULL DEFAULT 0,
+    transports varchar(255) NOT NULL DEFAULT '',
+    name varchar(100) NOT NULL,
+    created_at timestamp NOT NULL,
+    last_used_at timestamp,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS passkeys_credential_id_idx ON passkeys(credential_id);
+CREATE INDEX IF NOT EXISTS passkeys_user_idx ON passkeys(user_id);
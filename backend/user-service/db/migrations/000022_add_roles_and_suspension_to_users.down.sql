@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_suspended_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_by;
+ALTER TABLE users DROP COLUMN IF EXISTS suspension_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
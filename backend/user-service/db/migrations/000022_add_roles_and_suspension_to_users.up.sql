@@ -0,0 +1,8 @@
+-- the first admin is promoted by hand: UPDATE users SET role = 'admin' WHERE username = '...';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role varchar(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'));
+-- a suspension without suspended_until is a ban
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspension_reason varchar(500) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_by char(36);
+CREATE INDEX IF NOT EXISTS users_suspended_at_idx ON users(suspended_at) WHERE suspended_at IS NOT NULL;
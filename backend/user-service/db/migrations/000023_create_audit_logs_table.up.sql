@@ -0,0 +1,27 @@
+-- no foreign keys: the trail has to outlive the accounts it is about, and failed logins may name no account
+CREATE TABLE IF NOT EXISTS audit_logs(
+    id bigserial PRIMARY KEY,
+    event varchar(50) NOT NULL,
+    user_id char(36),
+    actor_id char(36),
+    ip_address varchar(45) NOT NULL DEFAULT '',
+    user_agent varchar(255) NOT NULL DEFAULT '',
+    metadata jsonb NOT NULL DEFAULT '{}',
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_user_idx ON audit_logs(user_id, id DESC);
+CREATE INDEX IF NOT EXISTS audit_logs_actor_idx ON audit_logs(actor_id, id DESC);
+CREATE INDEX IF NOT EXISTS audit_logs_event_idx ON audit_logs(event, id DESC);
+
+-- append-only, rows can be added but never changed or removed through the application's connection
+CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_logs is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER audit_logs_no_update_or_delete BEFORE UPDATE OR DELETE ON audit_logs
+    FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only();
+CREATE OR REPLACE TRIGGER audit_logs_no_truncate BEFORE TRUNCATE ON audit_logs
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_logs_append_only();
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"context"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/delivery/http"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/delivery/http/middleware"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/delivery/http/route"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/mailer"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/usecase"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -14,25 +18,62 @@ import (
 )
 
 type ServerConfig struct {
-	Router  *httprouter.Router
-	DB      *pgxpool.Pool
-	DBCache *redis.ClusterClient
-	Log     *zapLog.Logger
-	Config  *koanf.Koanf
+	Context      context.Context
+	Router       *httprouter.Router
+	DB           *pgxpool.Pool
+	DBCache      *redis.ClusterClient
+	Log          *zapLog.Logger
+	Config       *koanf.Koanf
+	KeySet       *helper.KeySet
+	Mailer       mailer.Mailer
+	Kafka        *kafka.Producer
+	OidcProvider *helper.OidcProvider
 }
 
 func Server(config *ServerConfig) {
+	eventRepository := repository.NewEventRepository(config.Log, config.Kafka, helper.ConfigString(config.Config, "USER_EVENTS_TOPIC", "user-events"))
+
+	auditRepository := repository.NewAuditRepository(config.Log, config.DB)
+
 	userRepository := repository.NewUserRepository(config.Log, config.DB, config.DBCache)
-	userUsecase := usecase.NewUserUsecase(userRepository, config.DB, config.Log, config.Config)
+	userUsecase := usecase.NewUserUsecase(userRepository, eventRepository, auditRepository, config.DB, config.Log, config.Config, config.KeySet, config.Mailer, config.OidcProvider)
 	userController := http.NewUserController(userUsecase, config.Log, config.Config)
 
+	contactRepository := repository.NewContactRepository(config.Log, config.DB)
+	contactUsecase := usecase.NewContactUsecase(contactRepository, eventRepository, config.DB, config.Log, config.Config)
+	contactController := http.NewContactController(contactUsecase, config.Log, config.Config)
+
+	exportRepository := repository.NewExportRepository(config.Log, config.DB)
+	exportUsecase := usecase.NewExportUsecase(exportRepository, config.DB, config.Log, config.Config)
+	exportController := http.NewExportController(exportUsecase, config.Log, config.Config)
+
+	passkeyRepository := repository.NewPasskeyRepository(config.Log, config.DB, config.DBCache)
+	passkeyUsecase := usecase.NewPasskeyUsecase(passkeyRepository, userUsecase, config.DB, config.Log, config.Config)
+	passkeyController := http.NewPasskeyController(passkeyUsecase, config.Log, config.Config)
+
+	deviceLinkRepository := repository.NewDeviceLinkRepository(config.Log, config.DBCache)
+	deviceLinkUsecase := usecase.NewDeviceLinkUsecase(deviceLinkRepository, userUsecase, config.DB, config.Log, config.Config)
+	deviceLinkController := http.NewDeviceLinkController(deviceLinkUsecase, config.Log, config.Config)
+
+	adminRepository := repository.NewAdminRepository(config.Log, config.DB)
+	adminUsecase := usecase.NewAdminUsecase(adminRepository, userUsecase, config.DB, config.Log, config.Config)
+	adminController := http.NewAdminController(adminUsecase, config.Log, config.Config)
+
 	authMiddleware := middleware.NewAuthMiddleware(config.Router, config.Log, config.Config, userUsecase)
 
 	routeConfig := route.RouteConfig{
-		Router:         config.Router,
-		UserController: userController,
-		AuthMiddleware: authMiddleware,
+		Router:               config.Router,
+		UserController:       userController,
+		ContactController:    contactController,
+		ExportController:     exportController,
+		PasskeyController:    passkeyController,
+		DeviceLinkController: deviceLinkController,
+		AdminController:      adminController,
+		AuthMiddleware:       authMiddleware,
 	}
 
 	routeConfig.SetupRoute()
+
+	go userUsecase.RunAccountPurge(config.Context)
+	go exportUsecase.RunExportCleanup(config.Context)
 }
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+)
+
+func NewKeySet(config *koanf.Koanf, log *zap.Logger) *helper.KeySet {
+	keysDir := config.String("JWT_KEYS_DIR")
+	if keysDir == "" {
+		keysDir = "keys"
+	}
+
+	keySet, err := helper.LoadKeySet(keysDir, config.String("JWT_ACTIVE_KID"))
+	if err != nil {
+		log.Fatal("Failed to load jwt signing keys", zap.Error(err))
+	}
+
+	return keySet
+}
@@ -1 +1,19 @@
 package config
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+)
+
+func NewKafkaProducer(config *koanf.Koanf, log *zap.Logger) *kafka.Producer {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": config.String("KAFKA_URLS"),
+	})
+
+	if err != nil {
+		log.Fatal("Failed to connect kafka", zap.Error(err))
+	}
+
+	return producer
+}
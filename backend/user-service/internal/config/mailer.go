@@ -0,0 +1,34 @@
+package config
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/mailer"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+)
+
+func NewMailer(config *koanf.Koanf, log *zap.Logger) mailer.Mailer {
+	switch config.String("MAILER_DRIVER") {
+	case "smtp":
+		return mailer.NewSMTPMailer(
+			config.String("SMTP_HOST"),
+			config.String("SMTP_PORT"),
+			config.String("SMTP_USERNAME"),
+			config.String("SMTP_PASSWORD"),
+			config.String("SMTP_FROM"),
+		)
+	case "memory":
+		return mailer.NewMemoryOutbox()
+	default:
+		outboxDir := config.String("MAILER_OUTBOX_DIR")
+		if outboxDir == "" {
+			outboxDir = "outbox"
+		}
+
+		outbox, err := mailer.NewFileOutbox(outboxDir)
+		if err != nil {
+			log.Fatal("Failed to create mail outbox", zap.Error(err))
+		}
+
+		return outbox
+	}
+}
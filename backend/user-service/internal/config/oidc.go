@@ -0,0 +1,46 @@
+package config
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// NewOidcProvider returns nil when OIDC_ISSUER is empty, which leaves single sign-on switched off.
+// The provider itself is only contacted on the first sign in, so a provider that is down doesn't block startup.
+func NewOidcProvider(config *koanf.Koanf, log *zap.Logger) *helper.OidcProvider {
+	issuer := config.String("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	issuerURL, err := url.Parse(issuer)
+	if err != nil || issuerURL.Host == "" {
+		log.Fatal("OIDC_ISSUER is not a valid url")
+	}
+
+	// plain http is only for a stand-in provider running on this machine
+	if issuerURL.Scheme != "https" && !(issuerURL.Scheme == "http" && helper.ConfigBool(config, "OIDC_ALLOW_INSECURE_ISSUER", false)) {
+		log.Fatal("OIDC_ISSUER must use https, set OIDC_ALLOW_INSECURE_ISSUER=true for a local provider")
+	}
+
+	if config.String("OIDC_CLIENT_ID") == "" || config.String("OIDC_REDIRECT_URL") == "" {
+		log.Fatal("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+	}
+
+	scopes := strings.Fields(helper.ConfigString(config, "OIDC_SCOPES", "openid email profile"))
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	return helper.NewOidcProvider(
+		issuer,
+		config.String("OIDC_CLIENT_ID"),
+		config.String("OIDC_CLIENT_SECRET"),
+		config.String("OIDC_REDIRECT_URL"),
+		scopes,
+	)
+}
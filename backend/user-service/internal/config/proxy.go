@@ -0,0 +1,19 @@
+package config
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// NewTrustedProxies reads TRUSTED_PROXIES, a comma separated list of addresses or CIDR ranges. Left empty,
+// forwarded headers are ignored and the peer address is used as the client address.
+func NewTrustedProxies(config *koanf.Koanf, log *zap.Logger) *helper.TrustedProxies {
+	proxies, err := helper.NewTrustedProxies(strings.Split(config.String("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		log.Fatal("TRUSTED_PROXIES is invalid", zap.Error(err))
+	}
+
+	return proxies
+}
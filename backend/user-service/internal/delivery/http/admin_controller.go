@@ -0,0 +1,157 @@
+package http
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/usecase"
+	"github.com/julienschmidt/httprouter"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type AdminController struct {
+	AdminUsecase *usecase.AdminUsecase
+	Log          *zap.Logger
+	Config       *koanf.Koanf
+}
+
+func NewAdminController(adminUsecase *usecase.AdminUsecase, zap *zap.Logger, koanf *koanf.Koanf) *AdminController {
+	return &AdminController{
+		AdminUsecase: adminUsecase,
+		Log:          zap,
+		Config:       koanf,
+	}
+}
+
+func writeAdminErrorResponse(writer http.ResponseWriter, errorMap map[string]string) {
+	if errorMap["internal"] != "" {
+		helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+	} else if errorMap["user"] == "user not found" {
+		helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+	} else if errorMap["permission"] != "" {
+		helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+	} else if errorMap["suspension"] == "user is not suspended" {
+		helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+	} else {
+		helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+	}
+}
+
+func (controller AdminController) GetUsers(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	query := request.URL.Query()
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	response, errorMap := controller.AdminUsecase.GetUsers(ctx, query.Get("q"), query.Get("role"), query.Get("suspended"), query.Get("cursor"), limit, errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller AdminController) GetUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	response, errorMap := controller.AdminUsecase.GetUser(ctx, params.ByName("id"), errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller AdminController) SuspendUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	role, _ := ctx.Value("user_role").(string)
+
+	payload := model.AdminSuspendRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.AdminUsecase.SuspendUser(ctx, userUUID, role, params.ByName("id"), payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller AdminController) UnsuspendUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	role, _ := ctx.Value("user_role").(string)
+
+	errorMap = controller.AdminUsecase.UnsuspendUser(ctx, userUUID, role, params.ByName("id"), getClientInfo(request), errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller AdminController) ForceLogout(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	role, _ := ctx.Value("user_role").(string)
+
+	errorMap = controller.AdminUsecase.ForceLogout(ctx, userUUID, role, params.ByName("id"), getClientInfo(request), errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller AdminController) UpdateUserRole(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	role, _ := ctx.Value("user_role").(string)
+
+	payload := model.AdminRoleUpdateRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.AdminUsecase.UpdateUserRole(ctx, userUUID, role, params.ByName("id"), payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller AdminController) GetAuditLogs(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	query := request.URL.Query()
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	response, errorMap := controller.AdminUsecase.GetAuditLogs(ctx, query.Get("user_id"), query.Get("actor_id"), query.Get("event"), query.Get("cursor"), limit, errorMap)
+	if errorMap != nil {
+		writeAdminErrorResponse(writer, errorMap)
+		return
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
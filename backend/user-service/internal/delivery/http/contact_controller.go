@@ -0,0 +1,226 @@
+package http
+
+import (
+	"context"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/usecase"
+	"github.com/julienschmidt/httprouter"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type ContactController struct {
+	ContactUsecase *usecase.ContactUsecase
+	Log            *zap.Logger
+	Config         *koanf.Koanf
+}
+
+func NewContactController(contactUsecase *usecase.ContactUsecase, zap *zap.Logger, koanf *koanf.Koanf) *ContactController {
+	return &ContactController{
+		ContactUsecase: contactUsecase,
+		Log:            zap,
+		Config:         koanf,
+	}
+}
+
+func (controller ContactController) SendContactRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	payload := model.ContactRequestCreateRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, errorMap := controller.ContactUsecase.SendContactRequest(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["user_id"] == "user not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else if errorMap["user_id"] == "contact request is already pending" || errorMap["user_id"] == "user is already a contact" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller ContactController) GetContactRequests(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.ContactUsecase.GetContactRequests(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller ContactController) AcceptContactRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	controller.closeContactRequest(writer, request, params, controller.ContactUsecase.AcceptContactRequest)
+}
+
+func (controller ContactController) DeclineContactRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	controller.closeContactRequest(writer, request, params, controller.ContactUsecase.DeclineContactRequest)
+}
+
+func (controller ContactController) CancelContactRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	controller.closeContactRequest(writer, request, params, controller.ContactUsecase.CancelContactRequest)
+}
+
+func (controller ContactController) closeContactRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params, closeRequest func(ctx context.Context, userUUID string, requestID string, errorMap map[string]string) map[string]string) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	requestID := params.ByName("id")
+
+	errorMap = closeRequest(ctx, userUUID, requestID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["contact_request"] == "contact request not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else if errorMap["contact_request"] == "contact request is no longer pending" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller ContactController) GetContacts(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.ContactUsecase.GetContacts(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller ContactController) RemoveContact(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	contactUUID := params.ByName("user_id")
+
+	errorMap = controller.ContactUsecase.RemoveContact(ctx, userUUID, contactUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["contact"] == "contact not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller ContactController) BlockUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	blockedUUID := params.ByName("user_id")
+
+	errorMap = controller.ContactUsecase.BlockUser(ctx, userUUID, blockedUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["user_id"] == "user not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller ContactController) UnblockUser(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	blockedUUID := params.ByName("user_id")
+
+	errorMap = controller.ContactUsecase.UnblockUser(ctx, userUUID, blockedUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["block"] == "block not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller ContactController) GetBlockedUsers(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.ContactUsecase.GetBlockedUsers(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/usecase"
+	"github.com/julienschmidt/httprouter"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type DeviceLinkController struct {
+	DeviceLinkUsecase *usecase.DeviceLinkUsecase
+	Log               *zap.Logger
+	Config            *koanf.Koanf
+}
+
+func NewDeviceLinkController(deviceLinkUsecase *usecase.DeviceLinkUsecase, zap *zap.Logger, koanf *koanf.Koanf) *DeviceLinkController {
+	return &DeviceLinkController{
+		DeviceLinkUsecase: deviceLinkUsecase,
+		Log:               zap,
+		Config:            koanf,
+	}
+}
+
+func (controller DeviceLinkController) CreateDeviceLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	payload := model.DeviceLinkCreateRequest{}
+	if request.ContentLength != 0 {
+		helper.ReadFromRequestBody(request, &payload)
+	}
+
+	response, errorMap := controller.DeviceLinkUsecase.CreateDeviceLink(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller DeviceLinkController) PollDeviceLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	payload := model.DeviceLinkPollRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, status, errorMap := controller.DeviceLinkUsecase.PollDeviceLink(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["device_link"] == "device link is invalid or expired" {
+			helper.WriteErrorResponse(writer, http.StatusGone, errorMap)
+			return
+		} else if errorMap["device_link"] == "device link was declined" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	if status == "pending" {
+		helper.WriteSuccessResponse(writer, model.DeviceLinkStatusResponse{Status: status})
+		return
+	}
+
+	if payload.ReturnTokens {
+		helper.WriteSuccessResponse(writer, newTokenResponse(response))
+		return
+	}
+
+	setTokenCookies(writer, response)
+
+	helper.WriteSuccessResponse(writer, model.DeviceLinkStatusResponse{Status: status})
+}
+
+func (controller DeviceLinkController) GetDeviceLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	response, errorMap := controller.DeviceLinkUsecase.GetDeviceLink(ctx, params.ByName("code"), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["device_link"] == "device link not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller DeviceLinkController) ApproveDeviceLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	errorMap = controller.DeviceLinkUsecase.ApproveDeviceLink(ctx, userUUID, params.ByName("code"), errorMap)
+	controller.writeAnswerResponse(writer, errorMap)
+}
+
+func (controller DeviceLinkController) DeclineDeviceLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	errorMap = controller.DeviceLinkUsecase.DeclineDeviceLink(ctx, userUUID, params.ByName("code"), errorMap)
+	controller.writeAnswerResponse(writer, errorMap)
+}
+
+func (controller DeviceLinkController) writeAnswerResponse(writer http.ResponseWriter, errorMap map[string]string) {
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["device_link"] == "device link not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else if errorMap["device_link"] == "device link was already answered" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
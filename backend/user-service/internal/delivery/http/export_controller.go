@@ -0,0 +1,103 @@
+package http
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/usecase"
+	"github.com/julienschmidt/httprouter"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type ExportController struct {
+	ExportUsecase *usecase.ExportUsecase
+	Log           *zap.Logger
+	Config        *koanf.Koanf
+}
+
+func NewExportController(exportUsecase *usecase.ExportUsecase, zap *zap.Logger, koanf *koanf.Koanf) *ExportController {
+	return &ExportController{
+		ExportUsecase: exportUsecase,
+		Log:           zap,
+		Config:        koanf,
+	}
+}
+
+func (controller ExportController) RequestDataExport(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.ExportUsecase.RequestDataExport(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["export"] == "an export is already in progress" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller ExportController) GetDataExport(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.ExportUsecase.GetDataExport(ctx, userUUID, params.ByName("id"), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["export"] == "export not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller ExportController) DownloadDataExport(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	filePath, errorMap := controller.ExportUsecase.GetDataExportFile(ctx, userUUID, params.ByName("id"), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["export"] == "export not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else if errorMap["export"] == "export has expired" {
+			helper.WriteErrorResponse(writer, http.StatusGone, errorMap)
+			return
+		} else if errorMap["export"] == "export is not ready" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", `attachment; filename="mychat-export.zip"`)
+	writer.Header().Set("Cache-Control", "no-store")
+
+	http.ServeFile(writer, request, filePath)
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/usecase"
+	"github.com/julienschmidt/httprouter"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type PasskeyController struct {
+	PasskeyUsecase *usecase.PasskeyUsecase
+	Log            *zap.Logger
+	Config         *koanf.Koanf
+}
+
+func NewPasskeyController(passkeyUsecase *usecase.PasskeyUsecase, zap *zap.Logger, koanf *koanf.Koanf) *PasskeyController {
+	return &PasskeyController{
+		PasskeyUsecase: passkeyUsecase,
+		Log:            zap,
+		Config:         koanf,
+	}
+}
+
+func (controller PasskeyController) GetRegistrationOptions(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.PasskeyUsecase.GetRegistrationOptions(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller PasskeyController) RegisterPasskey(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	payload := model.PasskeyRegisterRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, errorMap := controller.PasskeyUsecase.RegisterPasskey(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["credential"] == "passkey is already registered" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller PasskeyController) GetPasskeys(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.PasskeyUsecase.GetPasskeys(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller PasskeyController) DeletePasskey(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	errorMap = controller.PasskeyUsecase.DeletePasskey(ctx, userUUID, params.ByName("id"), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["passkey"] == "passkey not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller PasskeyController) GetLoginOptions(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	payload := model.PasskeyLoginOptionsRequest{}
+	if request.ContentLength != 0 {
+		helper.ReadFromRequestBody(request, &payload)
+	}
+
+	response, errorMap := controller.PasskeyUsecase.GetLoginOptions(ctx, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller PasskeyController) Login(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	payload := model.PasskeyLoginRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, errorMap := controller.PasskeyUsecase.Login(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+			return
+		}
+	}
+
+	if payload.ReturnTokens {
+		helper.WriteSuccessResponse(writer, newTokenResponse(response))
+		return
+	}
+
+	setTokenCookies(writer, response)
+
+	helper.WriteSuccessResponseNoData(writer)
+}
@@ -3,18 +3,78 @@ package route
 import (
 	"github.com/ferdian3456/mychat/backend/user-service/internal/delivery/http"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/delivery/http/middleware"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
 	"github.com/julienschmidt/httprouter"
 )
 
 type RouteConfig struct {
-	Router         *httprouter.Router
-	UserController *http.UserController
-	AuthMiddleware *middleware.AuthMiddleware
+	Router               *httprouter.Router
+	UserController       *http.UserController
+	ContactController    *http.ContactController
+	ExportController     *http.ExportController
+	PasskeyController    *http.PasskeyController
+	DeviceLinkController *http.DeviceLinkController
+	AdminController      *http.AdminController
+	AuthMiddleware       *middleware.AuthMiddleware
 }
 
 func (c *RouteConfig) SetupRoute() {
 	c.Router.POST("/login", c.UserController.Login)
+	c.Router.POST("/login/mfa", c.UserController.LoginMfa)
+	c.Router.POST("/login/magic", c.UserController.RequestMagicLink)
+	c.Router.POST("/login/magic/callback", c.UserController.LoginMagicLink)
+	c.Router.GET("/oidc/login", c.UserController.OidcLogin)
+	c.Router.GET("/oidc/callback", c.UserController.OidcCallback)
+	c.Router.POST("/passkey/login/options", c.PasskeyController.GetLoginOptions)
+	c.Router.POST("/passkey/login", c.PasskeyController.Login)
+	c.Router.POST("/device-link", c.DeviceLinkController.CreateDeviceLink)
+	c.Router.POST("/device-link/poll", c.DeviceLinkController.PollDeviceLink)
 	c.Router.POST("/register", c.UserController.Register)
+	c.Router.POST("/refresh", c.UserController.RefreshToken)
+	c.Router.POST("/logout", c.UserController.Logout)
+	c.Router.POST("/password/forgot", c.UserController.ForgotPassword)
+	c.Router.POST("/password/reset", c.UserController.ResetPassword)
+	c.Router.POST("/email/verify", c.UserController.VerifyEmail)
+	c.Router.GET("/.well-known/jwks.json", c.UserController.GetJWKS)
 	c.Router.GET("/api/userinfo", c.AuthMiddleware.AuthMiddleware(c.UserController.GetUserInfo))
-	c.Router.GET("/api/users", c.AuthMiddleware.AuthMiddleware(c.UserController.GetAllUserData))
+	c.Router.PATCH("/api/userinfo", c.AuthMiddleware.AuthMiddleware(c.UserController.UpdateUserInfo))
+	c.Router.DELETE("/api/account", c.AuthMiddleware.AuthMiddleware(c.UserController.DeleteAccount))
+	c.Router.POST("/api/account/export", c.AuthMiddleware.AuthMiddleware(c.ExportController.RequestDataExport))
+	c.Router.GET("/api/account/export/:id", c.AuthMiddleware.AuthMiddleware(c.ExportController.GetDataExport))
+	c.Router.GET("/api/account/export/:id/download", c.AuthMiddleware.AuthMiddleware(c.ExportController.DownloadDataExport))
+	c.Router.GET("/api/account/activity", c.AuthMiddleware.AuthMiddleware(c.UserController.GetRecentActivity))
+	c.Router.PUT("/api/username", c.AuthMiddleware.AuthMiddleware(c.UserController.UpdateUsername))
+	c.Router.PUT("/api/email", c.AuthMiddleware.AuthMiddleware(c.UserController.UpdateEmail))
+	c.Router.POST("/api/email/verification", c.AuthMiddleware.AuthMiddleware(c.UserController.ResendEmailVerification))
+	c.Router.POST("/api/mfa/totp", c.AuthMiddleware.AuthMiddleware(c.UserController.EnrollTotp))
+	c.Router.POST("/api/mfa/totp/verify", c.AuthMiddleware.AuthMiddleware(c.UserController.ConfirmTotp))
+	c.Router.DELETE("/api/mfa/totp", c.AuthMiddleware.AuthMiddleware(c.UserController.DisableTotp))
+	c.Router.POST("/api/passkeys/options", c.AuthMiddleware.AuthMiddleware(c.PasskeyController.GetRegistrationOptions))
+	c.Router.POST("/api/passkeys", c.AuthMiddleware.AuthMiddleware(c.PasskeyController.RegisterPasskey))
+	c.Router.GET("/api/passkeys", c.AuthMiddleware.AuthMiddleware(c.PasskeyController.GetPasskeys))
+	c.Router.DELETE("/api/passkeys/:id", c.AuthMiddleware.AuthMiddleware(c.PasskeyController.DeletePasskey))
+	c.Router.GET("/api/device-link/:code", c.AuthMiddleware.AuthMiddleware(c.DeviceLinkController.GetDeviceLink))
+	c.Router.POST("/api/device-link/:code/approve", c.AuthMiddleware.AuthMiddleware(c.DeviceLinkController.ApproveDeviceLink))
+	c.Router.POST("/api/device-link/:code/decline", c.AuthMiddleware.AuthMiddleware(c.DeviceLinkController.DeclineDeviceLink))
+	c.Router.GET("/api/users", c.AuthMiddleware.AuthMiddleware(c.UserController.SearchUsers))
+	c.Router.POST("/api/contact-requests", c.AuthMiddleware.AuthMiddleware(c.ContactController.SendContactRequest))
+	c.Router.GET("/api/contact-requests", c.AuthMiddleware.AuthMiddleware(c.ContactController.GetContactRequests))
+	c.Router.POST("/api/contact-requests/:id/accept", c.AuthMiddleware.AuthMiddleware(c.ContactController.AcceptContactRequest))
+	c.Router.POST("/api/contact-requests/:id/decline", c.AuthMiddleware.AuthMiddleware(c.ContactController.DeclineContactRequest))
+	c.Router.DELETE("/api/contact-requests/:id", c.AuthMiddleware.AuthMiddleware(c.ContactController.CancelContactRequest))
+	c.Router.GET("/api/contacts", c.AuthMiddleware.AuthMiddleware(c.ContactController.GetContacts))
+	c.Router.DELETE("/api/contacts/:user_id", c.AuthMiddleware.AuthMiddleware(c.ContactController.RemoveContact))
+	c.Router.GET("/api/blocks", c.AuthMiddleware.AuthMiddleware(c.ContactController.GetBlockedUsers))
+	c.Router.POST("/api/blocks/:user_id", c.AuthMiddleware.AuthMiddleware(c.ContactController.BlockUser))
+	c.Router.DELETE("/api/blocks/:user_id", c.AuthMiddleware.AuthMiddleware(c.ContactController.UnblockUser))
+	c.Router.GET("/api/admin/users", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionUsersRead, c.AdminController.GetUsers)))
+	c.Router.GET("/api/admin/users/:id", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionUsersRead, c.AdminController.GetUser)))
+	c.Router.POST("/api/admin/users/:id/suspend", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionUsersSuspend, c.AdminController.SuspendUser)))
+	c.Router.POST("/api/admin/users/:id/unsuspend", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionUsersSuspend, c.AdminController.UnsuspendUser)))
+	c.Router.POST("/api/admin/users/:id/logout", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionUsersLogout, c.AdminController.ForceLogout)))
+	c.Router.PUT("/api/admin/users/:id/role", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionRolesManage, c.AdminController.UpdateUserRole)))
+	c.Router.GET("/api/admin/audit-logs", c.AuthMiddleware.AuthMiddleware(c.AuthMiddleware.RequirePermission(helper.PermissionAuditRead, c.AdminController.GetAuditLogs)))
+	c.Router.GET("/api/sessions", c.AuthMiddleware.AuthMiddleware(c.UserController.GetSessions))
+	c.Router.DELETE("/api/sessions", c.AuthMiddleware.AuthMiddleware(c.UserController.RevokeAllSessions))
+	c.Router.DELETE("/api/sessions/:id", c.AuthMiddleware.AuthMiddleware(c.UserController.RevokeSession))
 }
@@ -8,6 +8,9 @@ import (
 	"github.com/knadh/koanf/v2"
 	"go.uber.org/zap"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 type UserController struct {
@@ -24,6 +27,79 @@ func NewUserController(userUsecase *usecase.UserUsecase, zap *zap.Logger, koanf
 	}
 }
 
+func setTokenCookies(writer http.ResponseWriter, token model.Token) {
+	accessCookie := &http.Cookie{
+		Name:     "access_token",
+		Value:    token.Access_token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  token.Access_token_expires_in,
+	}
+
+	refreshCookie := &http.Cookie{
+		Name:     "refresh_token",
+		Value:    token.Refresh_token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  token.Refresh_token_expires_in,
+	}
+
+	http.SetCookie(writer, accessCookie)
+	http.SetCookie(writer, refreshCookie)
+}
+
+func clearTokenCookies(writer http.ResponseWriter) {
+	for _, name := range []string{"access_token", "refresh_token"} {
+		http.SetCookie(writer, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   -1,
+		})
+	}
+}
+
+func newTokenResponse(token model.Token) model.TokenResponse {
+	return model.TokenResponse{
+		AccessToken:           token.Access_token,
+		TokenType:             "Bearer",
+		AccessTokenExpiresIn:  int(time.Until(token.Access_token_expires_in).Seconds()),
+		RefreshToken:          token.Refresh_token,
+		RefreshTokenExpiresIn: int(time.Until(token.Refresh_token_expires_in).Seconds()),
+	}
+}
+
+// readRefreshToken takes the refresh token from its cookie, or from the JSON body for clients that don't keep cookies.
+func readRefreshToken(request *http.Request) (string, bool) {
+	cookie, err := request.Cookie("refresh_token")
+	if err == nil {
+		return cookie.Value, false
+	}
+
+	if request.ContentLength == 0 {
+		return "", false
+	}
+
+	payload := model.RefreshTokenRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	return payload.RefreshToken, payload.RefreshToken != ""
+}
+
+func getClientInfo(request *http.Request) model.ClientInfo {
+	return model.ClientInfo{
+		User_agent: request.UserAgent(),
+		Ip_address: helper.GetClientIP(request),
+	}
+}
+
 func (controller UserController) Register(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	ctx := request.Context()
 
@@ -32,9 +108,9 @@ func (controller UserController) Register(writer http.ResponseWriter, request *h
 	payload := model.UserRegisterRequest{}
 	helper.ReadFromRequestBody(request, &payload)
 
-	response, err := controller.UserUsecase.Register(ctx, payload, errorMap)
-	if err != nil {
-		if err["internal"] != "" {
+	response, errorMap := controller.UserUsecase.Register(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
 			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 			return
 		} else {
@@ -43,43 +119,103 @@ func (controller UserController) Register(writer http.ResponseWriter, request *h
 		}
 	}
 
-	accessCookie := &http.Cookie{
-		Name:     "access_token",
-		Value:    response.Access_token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  response.Access_token_expires_in,
+	// no tokens are issued while unverified accounts are not allowed to sign in
+	if response.Access_token != "" {
+		setTokenCookies(writer, response)
 	}
 
-	refreshCookie := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    response.Refresh_token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  response.Refresh_token_expires_in,
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) Login(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	payload := model.UserLoginRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, challenge, errorMap := controller.UserUsecase.Login(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["retry_after"] != "" {
+			writer.Header().Set("Retry-After", errorMap["retry_after"])
+			helper.WriteErrorResponse(writer, http.StatusTooManyRequests, errorMap)
+			return
+		} else if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
 	}
 
-	http.SetCookie(writer, accessCookie)
-	http.SetCookie(writer, refreshCookie)
+	// no cookies until the second factor is in, see LoginMfa
+	if challenge != nil {
+		helper.WriteSuccessResponse(writer, challenge)
+		return
+	}
+
+	if payload.ReturnTokens {
+		helper.WriteSuccessResponse(writer, newTokenResponse(response))
+		return
+	}
+
+	setTokenCookies(writer, response)
 
 	helper.WriteSuccessResponseNoData(writer)
 }
 
-func (controller UserController) Login(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+func (controller UserController) LoginMfa(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	ctx := request.Context()
 
 	errorMap := map[string]string{}
 
-	payload := model.UserRegisterRequest{}
+	payload := model.MfaLoginRequest{}
 	helper.ReadFromRequestBody(request, &payload)
 
-	response, err := controller.UserUsecase.Login(ctx, payload, errorMap)
-	if err != nil {
-		if err["internal"] != "" {
+	response, errorMap := controller.UserUsecase.CompleteMfaLogin(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["retry_after"] != "" {
+			writer.Header().Set("Retry-After", errorMap["retry_after"])
+			helper.WriteErrorResponse(writer, http.StatusTooManyRequests, errorMap)
+			return
+		} else if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+			return
+		}
+	}
+
+	if payload.ReturnTokens {
+		helper.WriteSuccessResponse(writer, newTokenResponse(response))
+		return
+	}
+
+	setTokenCookies(writer, response)
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) RequestMagicLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	payload := model.MagicLinkRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.RequestMagicLink(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
 			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 			return
 		} else {
@@ -88,32 +224,381 @@ func (controller UserController) Login(writer http.ResponseWriter, request *http
 		}
 	}
 
-	accessCookie := &http.Cookie{
-		Name:     "access_token",
-		Value:    response.Access_token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  response.Access_token_expires_in,
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+// LoginMagicLink is posted by the page the emailed link opens rather than being the link itself, so a mail
+// scanner following links can't spend the token before the user does.
+func (controller UserController) LoginMagicLink(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	payload := model.MagicLinkLoginRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, challenge, errorMap := controller.UserUsecase.CompleteMagicLinkLogin(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+			return
+		}
 	}
 
-	refreshCookie := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    response.Refresh_token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  response.Refresh_token_expires_in,
+	// no cookies until the second factor is in, see LoginMfa
+	if challenge != nil {
+		helper.WriteSuccessResponse(writer, challenge)
+		return
 	}
 
-	http.SetCookie(writer, accessCookie)
-	http.SetCookie(writer, refreshCookie)
+	if payload.ReturnTokens {
+		helper.WriteSuccessResponse(writer, newTokenResponse(response))
+		return
+	}
+
+	setTokenCookies(writer, response)
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+// OidcLogin sends the browser to the identity provider, the provider brings it back to OidcCallback.
+func (controller UserController) OidcLogin(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	authorizationURL, errorMap := controller.UserUsecase.StartOidcLogin(ctx, request.URL.Query().Get("device_label"), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["oidc"] == "single sign-on is not configured" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	http.Redirect(writer, request, authorizationURL, http.StatusFound)
+}
+
+// OidcCallback sets the same cookies as Login and then sends the browser on to the app. An account with
+// two-factor authentication is sent on with the mfa token in the fragment instead, for the app to finish
+// through /login/mfa; a fragment never reaches a server log.
+func (controller UserController) OidcCallback(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	query := request.URL.Query()
+	payload := model.OidcCallbackRequest{
+		Code:  query.Get("code"),
+		State: query.Get("state"),
+		Error: query.Get("error"),
+	}
+
+	response, challenge, errorMap := controller.UserUsecase.CompleteOidcLogin(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["oidc"] == "single sign-on is not configured" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+			return
+		}
+	}
+
+	redirectURL := helper.ConfigString(controller.Config, "OIDC_POST_LOGIN_REDIRECT", "http://localhost:4200/")
+
+	if challenge != nil {
+		http.Redirect(writer, request, redirectURL+"#mfa_token="+url.QueryEscape(challenge.MfaToken), http.StatusFound)
+		return
+	}
+
+	setTokenCookies(writer, response)
+
+	http.Redirect(writer, request, redirectURL, http.StatusFound)
+}
+
+func (controller UserController) RefreshToken(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	refreshTokenString, fromBody := readRefreshToken(request)
+
+	response, errorMap := controller.UserUsecase.RefreshToken(ctx, refreshTokenString, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["account"] == "account is suspended" {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+			return
+		}
+	}
+
+	// a token that came in the body goes back in the body
+	if fromBody {
+		helper.WriteSuccessResponse(writer, newTokenResponse(response))
+		return
+	}
+
+	setTokenCookies(writer, response)
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) Logout(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	accessTokenString := helper.GetAccessToken(request)
+	refreshTokenString, _ := readRefreshToken(request)
+
+	// cookies are cleared even if revocation fails so the browser is logged out either way
+	clearTokenCookies(writer)
+
+	errorMap = controller.UserUsecase.Logout(ctx, accessTokenString, refreshTokenString, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) ForgotPassword(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	payload := model.PasswordForgotRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.ForgotPassword(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) ResetPassword(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	payload := model.PasswordResetRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.ResetPassword(ctx, payload, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	clearTokenCookies(writer)
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) VerifyEmail(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+
+	payload := model.EmailVerifyRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.VerifyEmail(ctx, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) ResendEmailVerification(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	errorMap = controller.UserUsecase.ResendEmailVerification(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) UpdateUsername(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	payload := model.UsernameUpdateRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.UpdateUsername(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
 
 	helper.WriteSuccessResponseNoData(writer)
 }
 
+func (controller UserController) UpdateEmail(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	payload := model.EmailUpdateRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.UpdateEmail(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) EnrollTotp(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	response, errorMap := controller.UserUsecase.EnrollTotp(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	writer.Header().Set("Cache-Control", "no-store")
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller UserController) ConfirmTotp(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	payload := model.TotpCodeRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.ConfirmTotp(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) DisableTotp(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	payload := model.TotpCodeRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	errorMap = controller.UserUsecase.DisableTotp(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) GetJWKS(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Header().Set("Cache-Control", "public, max-age=300")
+
+	helper.WriteJSONResponse(writer, controller.UserUsecase.GetJWKS())
+}
+
 func (controller UserController) GetUserInfo(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	ctx := request.Context()
 	errorMap := map[string]string{}
@@ -134,15 +619,164 @@ func (controller UserController) GetUserInfo(writer http.ResponseWriter, request
 	helper.WriteSuccessResponse(writer, response)
 }
 
-func (controller UserController) GetAllUserData(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+func (controller UserController) UpdateUserInfo(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	ctx := request.Context()
 	errorMap := map[string]string{}
 
 	userUUID, _ := ctx.Value("user_uuid").(string)
 
-	response, err := controller.UserUsecase.GetAllUserData(ctx, userUUID, errorMap)
-	if err != nil {
-		if err["internal"] != "" {
+	payload := model.UserProfileUpdateRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, errorMap := controller.UserUsecase.UpdateUserInfo(ctx, userUUID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller UserController) SearchUsers(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	query := request.URL.Query()
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	response, errorMap := controller.UserUsecase.SearchUsers(ctx, userUUID, query.Get("q"), query.Get("cursor"), limit, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller UserController) GetSessions(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	sessionID, _ := ctx.Value("session_id").(string)
+
+	response, errorMap := controller.UserUsecase.GetSessions(ctx, userUUID, sessionID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller UserController) RevokeSession(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	currentSessionID, _ := ctx.Value("session_id").(string)
+	sessionID := params.ByName("id")
+
+	errorMap = controller.UserUsecase.RevokeSession(ctx, userUUID, sessionID, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["session"] == "session not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	if sessionID == currentSessionID {
+		clearTokenCookies(writer)
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) RevokeAllSessions(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	errorMap = controller.UserUsecase.RevokeAllSessions(ctx, userUUID, getClientInfo(request), errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	clearTokenCookies(writer)
+
+	helper.WriteSuccessResponseNoData(writer)
+}
+
+func (controller UserController) DeleteAccount(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	sessionID, _ := ctx.Value("session_id").(string)
+
+	payload := model.AccountDeleteRequest{}
+	helper.ReadFromRequestBody(request, &payload)
+
+	response, errorMap := controller.UserUsecase.DeleteAccount(ctx, userUUID, sessionID, payload, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	clearTokenCookies(writer)
+
+	helper.WriteSuccessResponse(writer, response)
+}
+
+func (controller UserController) GetRecentActivity(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ctx := request.Context()
+	errorMap := map[string]string{}
+
+	userUUID, _ := ctx.Value("user_uuid").(string)
+
+	query := request.URL.Query()
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	response, errorMap := controller.UserUsecase.GetRecentActivity(ctx, userUUID, query.Get("cursor"), limit, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
 			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 			return
 		} else {
@@ -0,0 +1,131 @@
+package helper
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const cborMaxDepth = 16
+
+// DecodeCBOR reads one data item from the front of data and returns it with the bytes that follow. It covers the
+// part of RFC 8949 that WebAuthn attestation objects and COSE keys use: integers come back as int64, byte strings
+// as []byte, text as string, arrays as []interface{} and maps as map[interface{}]interface{}. Tags, floats and
+// indefinite lengths are rejected.
+func DecodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("cbor: nested too deep")
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errors.New("cbor: unexpected end of data")
+	}
+
+	majorType := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if majorType == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22, 23:
+			return nil, data[1:], nil
+		default:
+			return nil, nil, errors.New("cbor: unsupported simple value")
+		}
+	}
+
+	argument, rest, err := readCBORArgument(data, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch majorType {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return int64(argument), rest, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(argument), rest, nil
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+		if majorType == 2 {
+			return rest[:argument], rest[argument:], nil
+		}
+		return string(rest[:argument]), rest[argument:], nil
+	case 4:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errors.New("cbor: unexpected end of data")
+		}
+
+		items := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key type")
+			}
+
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+	default:
+		return nil, nil, errors.New("cbor: tags are not supported")
+	}
+}
+
+func readCBORArgument(data []byte, info byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data[1:], nil
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), data[2:], nil
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:3])), data[3:], nil
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:9]), data[9:], nil
+	case info > 27:
+		return 0, nil, errors.New("cbor: indefinite lengths are not supported")
+	default:
+		return 0, nil, errors.New("cbor: unexpected end of data")
+	}
+}
@@ -0,0 +1,98 @@
+package helper
+
+import (
+	"bytes"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/webauthntest"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"small int", 10, int64(10)},
+		{"one byte int", 200, int64(200)},
+		{"two byte int", 1000, int64(1000)},
+		{"negative int", -257, int64(-257)},
+		{"byte string", []byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"text", "none", "none"},
+		{"bool", true, true},
+		{"array", []interface{}{1, "a"}, []interface{}{int64(1), "a"}},
+		{"map", webauthntest.Map{{Key: 1, Value: 2}, {Key: "k", Value: []byte{9}}}, map[interface{}]interface{}{int64(1): int64(2), "k": []byte{9}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := append(webauthntest.EncodeCBOR(test.value), 0xff)
+
+			value, rest, err := DecodeCBOR(data)
+			if err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(value, test.expected) {
+				t.Fatalf("decoded %#v, expected %#v", value, test.expected)
+			}
+			if !bytes.Equal(rest, []byte{0xff}) {
+				t.Fatalf("rest = %x, expected ff", rest)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"truncated byte string", []byte{0x45, 0x01, 0x02}},
+		{"array longer than the data", []byte{0x9a, 0xff, 0xff, 0xff, 0xff}},
+		{"map longer than the data", []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"integer overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"indefinite length", []byte{0x5f}},
+		{"tag", []byte{0xc0, 0x00}},
+		{"float", []byte{0xf9, 0x00, 0x00}},
+		{"array map key", []byte{0xa1, 0x80, 0x00}},
+		{"nested too deep", bytes.Repeat([]byte{0x81}, cborMaxDepth+2)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := DecodeCBOR(test.data)
+			if err == nil {
+				t.Fatal("malformed cbor was accepted")
+			}
+		})
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	authenticator, err := webauthntest.New(webauthntest.AlgES256, testRPID, testOrigin)
+	if err != nil {
+		f.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	f.Add(authenticator.AttestationObject(authenticator.AuthenticatorData(true)))
+	f.Add(authenticator.COSEKey())
+	f.Add([]byte{0x9f, 0xff})
+	f.Add([]byte{0xbf})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := DecodeCBOR(data)
+		if err != nil {
+			return
+		}
+
+		if len(rest) >= len(data) || !bytes.Equal(data[len(data)-len(rest):], rest) {
+			t.Fatalf("rest %x is not a proper suffix of %x", rest, data)
+		}
+
+		// the parsers built on the decoder must not panic on whatever it accepts
+		_, _ = ParseWebAuthnAttestationObject(data)
+		_, _, _ = ParseCOSEKey(data)
+	})
+}
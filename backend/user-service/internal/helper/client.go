@@ -0,0 +1,127 @@
+package helper
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// GetClientIP reads the peer address. Forwarded headers are only honoured through TrustedProxies.RealIP, which
+// rewrites RemoteAddr before the router sees the request.
+func GetClientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// TrustedProxies are the reverse proxies allowed to tell us the client address, anyone else can put
+// whatever they like in X-Forwarded-For.
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// NewTrustedProxies accepts single addresses and CIDR ranges.
+func NewTrustedProxies(entries []string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			proxies.networks = append(proxies.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+
+		proxies.networks = append(proxies.networks, network)
+	}
+
+	return proxies, nil
+}
+
+func (proxies *TrustedProxies) trusts(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range proxies.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RealIP replaces RemoteAddr with the forwarded client address when the request came from a trusted proxy.
+// X-Forwarded-For is walked from the right so a client can't skip the proxies by prepending its own entries.
+func (proxies *TrustedProxies) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if len(proxies.networks) == 0 || !proxies.trusts(GetClientIP(request)) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		clientIP := ""
+		if forwardedFor := request.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			hops := strings.Split(forwardedFor, ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if net.ParseIP(hop) == nil {
+					break
+				}
+
+				clientIP = hop
+				if !proxies.trusts(hop) {
+					break
+				}
+			}
+		} else if realIP := strings.TrimSpace(request.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			clientIP = realIP
+		}
+
+		if clientIP != "" {
+			request.RemoteAddr = net.JoinHostPort(clientIP, "0")
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// GetAccessToken prefers an Authorization: Bearer header (CLI tools, bots, mobile) and falls back to the browser cookie.
+func GetAccessToken(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+
+	cookie, err := request.Cookie("access_token")
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
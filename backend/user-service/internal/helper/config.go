@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"github.com/knadh/koanf/v2"
+	"time"
+)
+
+func ConfigBool(config *koanf.Koanf, key string, fallback bool) bool {
+	if !config.Exists(key) {
+		return fallback
+	}
+
+	return config.Bool(key)
+}
+
+func ConfigDuration(config *koanf.Koanf, key string, fallback time.Duration) time.Duration {
+	if !config.Exists(key) {
+		return fallback
+	}
+
+	return config.Duration(key)
+}
+
+func ConfigString(config *koanf.Koanf, key string, fallback string) string {
+	if config.String(key) == "" {
+		return fallback
+	}
+
+	return config.String(key)
+}
+
+func ConfigInt(config *koanf.Koanf, key string, fallback int) int {
+	if !config.Exists(key) {
+		return fallback
+	}
+
+	return config.Int(key)
+}
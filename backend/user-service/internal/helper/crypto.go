@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// EncryptString seals the value with AES-256-GCM under a key derived from secretKey.
+func EncryptString(secretKey string, value string) (string, error) {
+	key := sha256.Sum256([]byte(secretKey))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptString(secretKey string, value string) (string, error) {
+	key := sha256.Sum256([]byte(secretKey))
+
+	sealed, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
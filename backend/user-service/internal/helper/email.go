@@ -0,0 +1,22 @@
+package helper
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// NormalizeEmail accepts a bare address only (no display name) and lowercases it so lookups and the
+// unique constraint don't depend on how the user typed it.
+func NormalizeEmail(email string) (string, bool) {
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > 254 {
+		return "", false
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", false
+	}
+
+	return strings.ToLower(email), true
+}
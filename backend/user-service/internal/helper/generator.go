@@ -1,7 +1,9 @@
 package helper
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 )
 
@@ -14,3 +16,14 @@ func GenerateSHA256Hash(value string) string {
 
 	return hashedValueHex
 }
+
+// GenerateRandomToken returns n random bytes encoded as url-safe base64, for tokens that end up in links.
+func GenerateRandomToken(n int) (string, error) {
+	data := make([]byte, n)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -42,6 +42,17 @@ func WriteSuccessResponseNoData(writer http.ResponseWriter) {
 	PanicIfError(err)
 }
 
+// WriteJSONResponse writes the response as is, for documents with a fixed shape such as the JWKS
+func WriteJSONResponse(writer http.ResponseWriter, response interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	jsonData, err := sonic.Marshal(response)
+	PanicIfError(err)
+
+	_, err = writer.Write(jsonData)
+	PanicIfError(err)
+}
+
 func WriteErrorResponse(writer http.ResponseWriter, statusCode int, errorMap map[string]string) {
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(statusCode)
@@ -0,0 +1,190 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type SigningKey struct {
+	Kid        string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// KeySet holds every key that may still verify a live access token. Only the active key signs,
+// the rest stay published in the JWKS until the tokens they signed have expired.
+type KeySet struct {
+	ActiveKid string
+	Keys      map[string]SigningKey
+}
+
+// LoadKeySet reads every <kid>.pem file in dir. Private keys (PKCS#8 or PKCS#1) can sign and verify,
+// public keys (PKIX) only verify and are meant for retired keys kept around during rotation.
+func LoadKeySet(dir string, activeKid string) (*KeySet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{
+		ActiveKid: activeKid,
+		Keys:      map[string]SigningKey{},
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+
+		kid := strings.TrimSuffix(entry.Name(), ".pem")
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parseSigningKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+
+		keySet.Keys[kid] = key
+	}
+
+	activeKey, ok := keySet.Keys[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found in %s", activeKid, dir)
+	}
+
+	if activeKey.PrivateKey == nil {
+		return nil, fmt.Errorf("active key %q has no private key", activeKid)
+	}
+
+	return keySet, nil
+}
+
+func parseSigningKey(kid string, data []byte) (SigningKey, error) {
+	key := SigningKey{Kid: kid}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return key, errors.New("no pem block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return key, err
+		}
+
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return key, errors.New("unsupported private key type")
+		}
+
+		key.PrivateKey = signer
+		key.PublicKey = signer.Public()
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return key, err
+		}
+
+		key.PrivateKey = parsed
+		key.PublicKey = parsed.Public()
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return key, err
+		}
+
+		key.PublicKey = parsed
+	default:
+		return key, fmt.Errorf("unsupported pem block %q", block.Type)
+	}
+
+	switch key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return key, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	return key, nil
+}
+
+func (keySet *KeySet) Sign(claims jwt.Claims) (string, error) {
+	activeKey := keySet.Keys[keySet.ActiveKid]
+
+	token := jwt.NewWithClaims(activeKey.Method, claims)
+	token.Header["kid"] = activeKey.Kid
+
+	return token.SignedString(activeKey.PrivateKey)
+}
+
+// Keyfunc resolves the verification key from the token's kid header, for use with jwt.Parse.
+func (keySet *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := keySet.Keys[kid]
+	if !ok {
+		return nil, errors.New("unknown key id")
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.PublicKey, nil
+}
+
+func (keySet *KeySet) JWKS() model.JSONWebKeySet {
+	kids := make([]string, 0, len(keySet.Keys))
+	for kid := range keySet.Keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := model.JSONWebKeySet{Keys: []model.JSONWebKey{}}
+	for _, kid := range kids {
+		key := keySet.Keys[kid]
+
+		jwk := model.JSONWebKey{
+			Kid: kid,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
@@ -0,0 +1,393 @@
+package helper
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcKeyRefreshInterval keeps a token with an unknown kid from making us refetch the JWKS on every request.
+const oidcKeyRefreshInterval = time.Minute
+
+// OidcClaims are the ID token claims the login flow looks at.
+type OidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string      `json:"nonce"`
+	Azp               string      `json:"azp"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+// IsEmailVerified accepts both true and "true", some providers send the claim as a string.
+func (claims *OidcClaims) IsEmailVerified() bool {
+	switch verified := claims.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	default:
+		return false
+	}
+}
+
+// OidcProvider is the relying party side of the authorization code flow against a single provider.
+// The discovery document and the provider's signing keys are fetched on first use and cached.
+type OidcProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	mutex         sync.Mutex
+	metadata      *model.OidcProviderMetadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func NewOidcProvider(issuer string, clientID string, clientSecret string, redirectURL string, scopes []string) *OidcProvider {
+	return &OidcProvider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewPKCEVerifier returns a code verifier and its S256 challenge.
+func NewPKCEVerifier() (string, string, error) {
+	verifier, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (provider *OidcProvider) getJSON(ctx context.Context, endpoint string, result interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := provider.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", endpoint, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	return sonic.Unmarshal(body, result)
+}
+
+// Metadata returns the provider's discovery document. The issuer it names has to be exactly the configured
+// one, otherwise a document served from somewhere else could redirect the whole flow.
+func (provider *OidcProvider) Metadata(ctx context.Context) (model.OidcProviderMetadata, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.metadata != nil {
+		return *provider.metadata, nil
+	}
+
+	metadata := model.OidcProviderMetadata{}
+	err := provider.getJSON(ctx, strings.TrimSuffix(provider.Issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return metadata, err
+	}
+
+	if metadata.Issuer != provider.Issuer {
+		return metadata, fmt.Errorf("discovery document is for issuer %q, expected %q", metadata.Issuer, provider.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JwksURI == "" {
+		return metadata, errors.New("discovery document is missing an endpoint")
+	}
+
+	provider.metadata = &metadata
+
+	return metadata, nil
+}
+
+func (provider *OidcProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	metadata, err := provider.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authorizationURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authorizationURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", provider.RedirectURL)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authorizationURL.RawQuery = query.Encode()
+
+	return authorizationURL.String(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the raw ID token, which still has to go
+// through VerifyIDToken.
+func (provider *OidcProvider) Exchange(ctx context.Context, code string, codeVerifier string) (string, error) {
+	metadata, err := provider.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	// public clients only have the verifier, confidential ones authenticate with client_secret_basic
+	if provider.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+	}
+
+	response, err := provider.HTTPClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	tokenResponse := model.OidcTokenResponse{}
+	err = sonic.Unmarshal(body, &tokenResponse)
+	if err != nil {
+		return "", fmt.Errorf("token endpoint returned status %d", response.StatusCode)
+	}
+
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s %s", response.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	if tokenResponse.IdToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+
+	return tokenResponse.IdToken, nil
+}
+
+// VerifyIDToken checks the signature against the provider's keys, then issuer, audience, expiry and that the
+// nonce is the one sent with this login.
+func (provider *OidcProvider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*OidcClaims, error) {
+	claims := &OidcClaims{}
+
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return provider.verificationKey(ctx, token)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	if (len(claims.Audience) > 1 || claims.Azp != "") && claims.Azp != provider.ClientID {
+		return nil, errors.New("id token was issued to another client")
+	}
+
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("id token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// verificationKey looks the kid up in the cached JWKS and refetches it once when the provider has rotated keys.
+func (provider *OidcProvider) verificationKey(ctx context.Context, token *jwt.Token) (crypto.PublicKey, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	provider.mutex.Lock()
+	key, ok := provider.lookupKey(kid)
+	stale := time.Since(provider.keysFetchedAt) > oidcKeyRefreshInterval
+	provider.mutex.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if !stale {
+		return nil, errors.New("unknown key id")
+	}
+
+	err := provider.refreshKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	key, ok = provider.lookupKey(kid)
+	if !ok {
+		return nil, errors.New("unknown key id")
+	}
+
+	return key, nil
+}
+
+// lookupKey expects the mutex to be held. A token without a kid is only accepted while the provider publishes a single key.
+func (provider *OidcProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(provider.keys) == 1 {
+		for _, key := range provider.keys {
+			return key, true
+		}
+	}
+
+	key, ok := provider.keys[kid]
+	return key, ok
+}
+
+func (provider *OidcProvider) refreshKeys(ctx context.Context) error {
+	metadata, err := provider.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	jwks := model.JSONWebKeySet{}
+	err = provider.getJSON(ctx, metadata.JwksURI, &jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJSONWebKey(jwk)
+		if err != nil {
+			// one key in a format we don't know shouldn't lock everyone out
+			continue
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	provider.mutex.Lock()
+	provider.keys = keys
+	provider.keysFetchedAt = time.Now()
+	provider.mutex.Unlock()
+
+	return nil
+}
+
+func parseJSONWebKey(jwk model.JSONWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
@@ -0,0 +1,185 @@
+package helper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/oidctest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "mychat"
+	testRedirectURL = "http://localhost:4200/oidc/callback"
+)
+
+func newTestOidcProvider(t *testing.T) (*OidcProvider, *oidctest.Provider) {
+	t.Helper()
+
+	standIn, err := oidctest.New(testClientID)
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	t.Cleanup(standIn.Close)
+
+	return NewOidcProvider(standIn.Issuer, testClientID, "", testRedirectURL, []string{"openid", "email"}), standIn
+}
+
+func newTestIDToken(t *testing.T, standIn *oidctest.Provider, claims map[string]interface{}) string {
+	t.Helper()
+
+	now := time.Now()
+	idTokenClaims := map[string]interface{}{
+		"iss":   standIn.Issuer,
+		"aud":   testClientID,
+		"sub":   "subject",
+		"nonce": "nonce",
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(idTokenClaims, name)
+			continue
+		}
+		idTokenClaims[name] = value
+	}
+
+	idToken, err := standIn.IDToken(idTokenClaims)
+	if err != nil {
+		t.Fatalf("failed to sign id token: %v", err)
+	}
+
+	return idToken
+}
+
+func TestOidcProviderVerifyIDToken(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		claims map[string]interface{}
+		valid  bool
+	}{
+		"valid":                              {valid: true},
+		"expired within leeway":              {claims: map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}, valid: true},
+		"audience list with matching azp":    {claims: map[string]interface{}{"aud": []string{testClientID, "other"}, "azp": testClientID}, valid: true},
+		"nonce mismatch":                     {claims: map[string]interface{}{"nonce": "other"}},
+		"no nonce":                           {claims: map[string]interface{}{"nonce": nil}},
+		"wrong issuer":                       {claims: map[string]interface{}{"iss": "https://attacker.example"}},
+		"wrong audience":                     {claims: map[string]interface{}{"aud": "other"}},
+		"expired":                            {claims: map[string]interface{}{"exp": now.Add(-5 * time.Minute).Unix()}},
+		"no expiry":                          {claims: map[string]interface{}{"exp": nil}},
+		"issued in the future":               {claims: map[string]interface{}{"iat": now.Add(5 * time.Minute).Unix()}},
+		"no subject":                         {claims: map[string]interface{}{"sub": nil}},
+		"audience list without azp":          {claims: map[string]interface{}{"aud": []string{testClientID, "other"}}},
+		"authorized party is another client": {claims: map[string]interface{}{"azp": "other"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider, standIn := newTestOidcProvider(t)
+
+			claims, err := provider.VerifyIDToken(context.Background(), newTestIDToken(t, standIn, test.claims), "nonce")
+			if test.valid && err != nil {
+				t.Fatalf("id token rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("id token accepted with subject %q", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestOidcProviderVerifyIDTokenRejectsOtherKeys(t *testing.T) {
+	provider, _ := newTestOidcProvider(t)
+
+	other, err := oidctest.New(testClientID)
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	defer other.Close()
+	other.Issuer = provider.Issuer
+
+	_, err = provider.VerifyIDToken(context.Background(), newTestIDToken(t, other, nil), "nonce")
+	if err == nil {
+		t.Fatal("id token signed by another provider's key accepted")
+	}
+}
+
+func TestOidcProviderMetadataRequiresExactIssuer(t *testing.T) {
+	provider, standIn := newTestOidcProvider(t)
+	standIn.Issuer = standIn.Server.URL + "/other"
+
+	_, err := provider.Metadata(context.Background())
+	if err == nil {
+		t.Fatal("discovery document for another issuer accepted")
+	}
+}
+
+func TestOidcProviderExchangeForwardsCodeVerifier(t *testing.T) {
+	provider, standIn := newTestOidcProvider(t)
+	ctx := context.Background()
+
+	verifier, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatalf("failed to generate verifier: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatal("challenge is not the S256 hash of the verifier")
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(ctx, "state", "nonce", challenge)
+	if err != nil {
+		t.Fatalf("failed to build authorization url: %v", err)
+	}
+
+	parsedURL, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("failed to parse authorization url: %v", err)
+	}
+	if parsedURL.Query().Get("redirect_uri") != testRedirectURL || parsedURL.Query().Get("scope") != "openid email" {
+		t.Fatalf("unexpected authorization url %s", authorizationURL)
+	}
+
+	code, state, err := standIn.Authorize(authorizationURL, nil)
+	if err != nil {
+		t.Fatalf("authorization rejected: %v", err)
+	}
+	if state != "state" {
+		t.Fatalf("state = %q, expected %q", state, "state")
+	}
+
+	_, err = provider.Exchange(ctx, code, "wrong-verifier")
+	if err == nil {
+		t.Fatal("code redeemed with the wrong verifier")
+	}
+
+	code, _, err = standIn.Authorize(authorizationURL, nil)
+	if err != nil {
+		t.Fatalf("authorization rejected: %v", err)
+	}
+
+	idToken, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("code exchange failed: %v", err)
+	}
+
+	verifiers := standIn.CodeVerifiers()
+	if len(verifiers) != 2 || verifiers[1] != verifier {
+		t.Fatalf("token endpoint got verifiers %v, expected the last to be %q", verifiers, verifier)
+	}
+
+	_, err = provider.VerifyIDToken(ctx, idToken, "nonce")
+	if err != nil {
+		t.Fatalf("id token rejected: %v", err)
+	}
+
+	_, err = provider.Exchange(ctx, code, verifier)
+	if err == nil {
+		t.Fatal("code redeemed twice")
+	}
+}
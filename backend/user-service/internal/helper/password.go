@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+type PasswordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// HashPassword encodes an argon2id hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, so the parameters travel with the hash.
+func HashPassword(password string, params PasswordParams) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword checks the password against an argon2id or legacy bcrypt hash. needsRehash is set when
+// the password matched but the hash isn't argon2id with the current parameters.
+func VerifyPassword(password string, encodedHash string, params PasswordParams) (match bool, needsRehash bool, err error) {
+	if strings.HasPrefix(encodedHash, "$2a$") || strings.HasPrefix(encodedHash, "$2b$") || strings.HasPrefix(encodedHash, "$2y$") {
+		err = bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+
+		return true, true, nil
+	}
+
+	if !strings.HasPrefix(encodedHash, "$argon2id$") {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	stored := PasswordParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.Memory, &stored.Iterations, &stored.Parallelism)
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	stored.SaltLength = uint32(len(salt))
+	stored.KeyLength = uint32(len(key))
+
+	otherKey := argon2.IDKey([]byte(password), salt, stored.Iterations, stored.Memory, stored.Parallelism, stored.KeyLength)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, stored != params, nil
+}
@@ -0,0 +1,54 @@
+package helper
+
+import (
+	"golang.org/x/text/language"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode"
+)
+
+// ContainsControl reports control characters; newlines only pass when allowNewline is set (bios may span lines).
+func ContainsControl(value string, allowNewline bool) bool {
+	for _, r := range value {
+		if allowNewline && r == '\n' {
+			continue
+		}
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsValidAvatarURL accepts absolute https URLs only, so clients never load avatars over plain http.
+func IsValidAvatarURL(avatarURL string) bool {
+	parsed, err := url.Parse(avatarURL)
+	if err != nil {
+		return false
+	}
+
+	return parsed.Scheme == "https" && parsed.Host != "" && parsed.User == nil
+}
+
+// NormalizeLocale returns the canonical BCP 47 form of the tag, e.g. "en-us" becomes "en-US".
+func NormalizeLocale(locale string) (string, bool) {
+	tag, err := language.Parse(strings.TrimSpace(locale))
+	if err != nil {
+		return "", false
+	}
+
+	return tag.String(), true
+}
+
+// IsValidTimezone accepts IANA names such as "Asia/Jakarta"; the tz database is embedded so this works in slim images.
+func IsValidTimezone(timezone string) bool {
+	if timezone == "" || timezone == "Local" {
+		return false
+	}
+
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
@@ -0,0 +1,52 @@
+package helper
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermissionUsersRead    = "users:read"
+	PermissionUsersSuspend = "users:suspend"
+	PermissionUsersLogout  = "users:logout"
+	PermissionRolesManage  = "roles:manage"
+	PermissionAuditRead    = "audit:read"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionUsersRead, PermissionUsersSuspend, PermissionUsersLogout},
+	RoleAdmin:     {PermissionUsersRead, PermissionUsersSuspend, PermissionUsersLogout, PermissionRolesManage, PermissionAuditRead},
+}
+
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OutranksRole reports whether role can act on an account holding target, a moderator can't suspend another
+// moderator or an admin. Admins act on each other, otherwise nobody could ever demote one.
+func OutranksRole(role string, target string) bool {
+	if role == RoleAdmin {
+		return true
+	}
+
+	return roleRanks[role] > roleRanks[target]
+}
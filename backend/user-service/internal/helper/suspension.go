@@ -0,0 +1,22 @@
+package helper
+
+import "time"
+
+// IsSuspended reports whether a suspension is in force at now, a suspension without an end is a ban.
+func IsSuspended(suspendedAt *time.Time, suspendedUntil *time.Time, now time.Time) bool {
+	if suspendedAt == nil {
+		return false
+	}
+
+	return suspendedUntil == nil || suspendedUntil.After(now)
+}
+
+// NewAccountSuspendedError tells the client until when the account is suspended, a ban carries no suspended_until.
+func NewAccountSuspendedError(suspendedUntil *time.Time) map[string]string {
+	errorMap := map[string]string{"account": "account is suspended"}
+	if suspendedUntil != nil {
+		errorMap["suspended_until"] = suspendedUntil.UTC().Format(time.RFC3339)
+	}
+
+	return errorMap
+}
@@ -0,0 +1,104 @@
+package helper
+
+import (
+	"errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// confusables maps characters that render like a Latin letter or digit onto it. Migration 000014 mirrors this
+// table with translate() to backfill existing rows, keep the two in sync.
+var confusables = map[rune]rune{
+	'0': 'o',
+	'1': 'l',
+	'i': 'l', // an upper case I folds to i but reads as l
+	'ı': 'l',
+	'а': 'a', // cyrillic
+	'е': 'e',
+	'о': 'o',
+	'р': 'p',
+	'с': 'c',
+	'у': 'y',
+	'х': 'x',
+	'і': 'l',
+	'ј': 'j',
+	'ѕ': 's',
+	'ԁ': 'd',
+	'ɡ': 'g', // latin script g
+	'ο': 'o', // greek
+	'α': 'a',
+	'ν': 'v',
+	'ρ': 'p',
+}
+
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w")
+
+var reservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "help", "mychat", "moderator", "mod", "staff",
+	"security", "official", "api", "www", "settings", "login", "register", "null", "undefined", "anonymous",
+}
+
+// NormalizeUsername validates a requested username and returns the form to display and the canonical form used
+// for lookups and uniqueness. Case and compatibility variants ("Alice", "ALICE", "Ａｌｉｃｅ") share a canonical form.
+func NormalizeUsername(username string) (string, string, error) {
+	display := norm.NFKC.String(strings.TrimSpace(username))
+
+	length := utf8.RuneCountInString(display)
+	if length == 0 {
+		return "", "", errors.New("username is required to not be empty")
+	} else if length < 4 {
+		return "", "", errors.New("username must be at least 4 characters")
+	} else if length > 22 {
+		return "", "", errors.New("username must be at most 22 characters")
+	}
+
+	for _, r := range display {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' {
+			return "", "", errors.New("username may only contain letters, digits, underscores and dots")
+		}
+	}
+
+	if strings.HasPrefix(display, ".") || strings.HasSuffix(display, ".") || strings.Contains(display, "..") {
+		return "", "", errors.New("username can't start or end with a dot or contain two dots in a row")
+	}
+
+	return display, CanonicalUsername(display), nil
+}
+
+// CanonicalUsername is what a username is looked up by, it accepts any input so login stays lenient.
+func CanonicalUsername(username string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(username))))
+}
+
+// UsernameSkeleton collapses lookalike characters of a canonical username, two names with the same skeleton
+// are too easy to mistake for each other to both exist.
+func UsernameSkeleton(canonical string) string {
+	skeleton := strings.Map(func(r rune) rune {
+		if mapped, ok := confusables[r]; ok {
+			return mapped
+		}
+		return r
+	}, canonical)
+
+	return confusableSequences.Replace(skeleton)
+}
+
+// IsReservedUsername compares skeletons so "adm1n" is as reserved as "admin".
+func IsReservedUsername(skeleton string, extra []string) bool {
+	for _, reserved := range reservedUsernames {
+		if skeleton == UsernameSkeleton(CanonicalUsername(reserved)) {
+			return true
+		}
+	}
+
+	for _, reserved := range extra {
+		if reserved != "" && skeleton == UsernameSkeleton(CanonicalUsername(reserved)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,249 @@
+package helper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// COSE algorithm identifiers offered to authenticators, in order of preference.
+const (
+	COSEAlgES256 int64 = -7
+	COSEAlgEdDSA int64 = -8
+	COSEAlgRS256 int64 = -257
+)
+
+const (
+	webAuthnFlagUserPresent  byte = 0x01
+	webAuthnFlagUserVerified byte = 0x04
+	webAuthnFlagAttestedData byte = 0x40
+)
+
+type WebAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// WebAuthnAuthenticatorData is the parsed authenticatorData. CredentialID and PublicKey are only set when the
+// authenticator attested a new credential, PublicKey is the COSE encoded key as stored.
+type WebAuthnAuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func (authData WebAuthnAuthenticatorData) UserPresent() bool {
+	return authData.Flags&webAuthnFlagUserPresent != 0
+}
+
+func (authData WebAuthnAuthenticatorData) UserVerified() bool {
+	return authData.Flags&webAuthnFlagUserVerified != 0
+}
+
+// CheckRPID makes sure the authenticator scoped the credential to our relying party id.
+func (authData WebAuthnAuthenticatorData) CheckRPID(rpID string) error {
+	sum := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(authData.RPIDHash, sum[:]) {
+		return errors.New("relying party id does not match")
+	}
+
+	return nil
+}
+
+// DecodeBase64URL accepts base64url with or without padding, browsers and libraries disagree on which to send.
+func DecodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// ParseWebAuthnClientData checks the ceremony type and origin of clientDataJSON. The challenge is returned
+// as is for the caller to look up.
+func ParseWebAuthnClientData(clientDataJSON []byte, ceremonyType string, origins []string) (WebAuthnClientData, error) {
+	clientData := WebAuthnClientData{}
+
+	err := sonic.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return clientData, errors.New("client data is not valid json")
+	}
+
+	if clientData.Type != ceremonyType {
+		return clientData, fmt.Errorf("client data type is %q, expected %q", clientData.Type, ceremonyType)
+	}
+
+	if !slices.Contains(origins, clientData.Origin) {
+		return clientData, fmt.Errorf("origin %q is not allowed", clientData.Origin)
+	}
+
+	if clientData.Challenge == "" {
+		return clientData, errors.New("client data has no challenge")
+	}
+
+	return clientData, nil
+}
+
+func ParseWebAuthnAuthenticatorData(data []byte) (WebAuthnAuthenticatorData, error) {
+	authData := WebAuthnAuthenticatorData{}
+
+	if len(data) < 37 {
+		return authData, errors.New("authenticator data is too short")
+	}
+
+	authData.RPIDHash = data[:32]
+	authData.Flags = data[32]
+	authData.SignCount = binary.BigEndian.Uint32(data[33:37])
+
+	if authData.Flags&webAuthnFlagAttestedData == 0 {
+		return authData, nil
+	}
+
+	// aaguid (16 bytes), credential id length (2 bytes), credential id, COSE key, then optional extensions
+	rest := data[37:]
+	if len(rest) < 18 {
+		return authData, errors.New("attested credential data is too short")
+	}
+
+	credentialIDLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if credentialIDLength > 1023 || len(rest) < credentialIDLength {
+		return authData, errors.New("credential id is too long")
+	}
+
+	authData.CredentialID = rest[:credentialIDLength]
+	rest = rest[credentialIDLength:]
+
+	_, extensions, err := DecodeCBOR(rest)
+	if err != nil {
+		return authData, fmt.Errorf("credential public key: %w", err)
+	}
+
+	authData.PublicKey = rest[:len(rest)-len(extensions)]
+
+	return authData, nil
+}
+
+// ParseWebAuthnAttestationObject returns the authenticator data of a registration. Only the "none" attestation
+// we ask for is trusted, an attestation statement from any other format is not verified so it is ignored.
+func ParseWebAuthnAttestationObject(attestationObject []byte) (WebAuthnAuthenticatorData, error) {
+	decoded, _, err := DecodeCBOR(attestationObject)
+	if err != nil {
+		return WebAuthnAuthenticatorData{}, err
+	}
+
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return WebAuthnAuthenticatorData{}, errors.New("attestation object is not a map")
+	}
+
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return WebAuthnAuthenticatorData{}, errors.New("attestation object has no authData")
+	}
+
+	authData, err := ParseWebAuthnAuthenticatorData(rawAuthData)
+	if err != nil {
+		return authData, err
+	}
+
+	if authData.CredentialID == nil || authData.PublicKey == nil {
+		return authData, errors.New("attestation object has no attested credential")
+	}
+
+	return authData, nil
+}
+
+// ParseCOSEKey decodes a credential public key, only the algorithms we offer at registration are accepted.
+func ParseCOSEKey(coseKey []byte) (crypto.PublicKey, int64, error) {
+	decoded, _, err := DecodeCBOR(coseKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("cose key is not a map")
+	}
+
+	keyType, _ := key[int64(1)].(int64)
+	algorithm, _ := key[int64(3)].(int64)
+
+	switch {
+	case keyType == 2 && algorithm == COSEAlgES256:
+		curve, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if curve != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+
+		return publicKey, algorithm, nil
+	case keyType == 1 && algorithm == COSEAlgEdDSA:
+		curve, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if curve != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), algorithm, nil
+	case keyType == 3 && algorithm == COSEAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RSA key")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, algorithm, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported key type %d with algorithm %d", keyType, algorithm)
+	}
+}
+
+// VerifyWebAuthnAssertion checks an assertion signature, which covers the authenticator data followed by the
+// SHA-256 of clientDataJSON.
+func VerifyWebAuthnAssertion(coseKey []byte, authenticatorData []byte, clientDataJSON []byte, signature []byte) error {
+	publicKey, algorithm, err := ParseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+
+	switch algorithm {
+	case COSEAlgES256:
+		digest := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+	case COSEAlgEdDSA:
+		if !ed25519.Verify(publicKey.(ed25519.PublicKey), signed, signature) {
+			return errors.New("invalid signature")
+		}
+	case COSEAlgRS256:
+		digest := sha256.Sum256(signed)
+		err = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+		if err != nil {
+			return errors.New("invalid signature")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package helper
+
+import (
+	"bytes"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/webauthntest"
+	"testing"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:4200"
+)
+
+var testAlgorithms = map[string]int64{
+	"ES256": webauthntest.AlgES256,
+	"EdDSA": webauthntest.AlgEdDSA,
+}
+
+func newTestAuthenticator(t *testing.T, algorithm int64) *webauthntest.Authenticator {
+	t.Helper()
+
+	authenticator, err := webauthntest.New(algorithm, testRPID, testOrigin)
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	return authenticator
+}
+
+func TestWebAuthnRegistrationAndAssertion(t *testing.T) {
+	for name, algorithm := range testAlgorithms {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newTestAuthenticator(t, algorithm)
+
+			clientData, err := ParseWebAuthnClientData(authenticator.ClientDataJSON("webauthn.create", "challenge"), "webauthn.create", []string{testOrigin})
+			if err != nil {
+				t.Fatalf("client data rejected: %v", err)
+			}
+			if clientData.Challenge != "challenge" {
+				t.Fatalf("challenge = %q, expected %q", clientData.Challenge, "challenge")
+			}
+
+			authData, err := ParseWebAuthnAttestationObject(authenticator.AttestationObject(authenticator.AuthenticatorData(true)))
+			if err != nil {
+				t.Fatalf("attestation object rejected: %v", err)
+			}
+
+			if err := authData.CheckRPID(testRPID); err != nil {
+				t.Fatalf("rp id rejected: %v", err)
+			}
+			if !authData.UserPresent() || !authData.UserVerified() {
+				t.Fatalf("flags %#x should report a present and verified user", authData.Flags)
+			}
+			if !bytes.Equal(authData.CredentialID, authenticator.CredentialID) {
+				t.Fatalf("credential id = %x, expected %x", authData.CredentialID, authenticator.CredentialID)
+			}
+
+			_, parsedAlgorithm, err := ParseCOSEKey(authData.PublicKey)
+			if err != nil {
+				t.Fatalf("public key rejected: %v", err)
+			}
+			if parsedAlgorithm != algorithm {
+				t.Fatalf("algorithm = %d, expected %d", parsedAlgorithm, algorithm)
+			}
+
+			authenticator.SignCount++
+			clientDataJSON := authenticator.ClientDataJSON("webauthn.get", "challenge")
+			authenticatorData := authenticator.AuthenticatorData(false)
+
+			err = VerifyWebAuthnAssertion(authData.PublicKey, authenticatorData, clientDataJSON, authenticator.Sign(authenticatorData, clientDataJSON))
+			if err != nil {
+				t.Fatalf("assertion rejected: %v", err)
+			}
+
+			assertionData, err := ParseWebAuthnAuthenticatorData(authenticatorData)
+			if err != nil {
+				t.Fatalf("authenticator data rejected: %v", err)
+			}
+			if assertionData.SignCount != 1 {
+				t.Fatalf("sign count = %d, expected 1", assertionData.SignCount)
+			}
+		})
+	}
+}
+
+func TestParseWebAuthnClientDataRejects(t *testing.T) {
+	authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+
+	tests := []struct {
+		name           string
+		origin         string
+		ceremonyType   string
+		challenge      string
+		expectedType   string
+		clientDataJSON []byte
+	}{
+		{name: "wrong origin", origin: "https://evil.example", ceremonyType: "webauthn.get", challenge: "challenge", expectedType: "webauthn.get"},
+		{name: "lookalike origin", origin: testOrigin + ".evil.example", ceremonyType: "webauthn.get", challenge: "challenge", expectedType: "webauthn.get"},
+		{name: "wrong ceremony", origin: testOrigin, ceremonyType: "webauthn.create", challenge: "challenge", expectedType: "webauthn.get"},
+		{name: "no challenge", origin: testOrigin, ceremonyType: "webauthn.get", challenge: "", expectedType: "webauthn.get"},
+		{name: "not json", clientDataJSON: []byte("{"), expectedType: "webauthn.get"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientDataJSON := test.clientDataJSON
+			if clientDataJSON == nil {
+				authenticator.Origin = test.origin
+				clientDataJSON = authenticator.ClientDataJSON(test.ceremonyType, test.challenge)
+			}
+
+			_, err := ParseWebAuthnClientData(clientDataJSON, test.expectedType, []string{testOrigin})
+			if err == nil {
+				t.Fatal("client data was accepted")
+			}
+		})
+	}
+}
+
+func TestWebAuthnAuthenticatorDataRejectsOtherRelyingParty(t *testing.T) {
+	authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+	authenticator.RPID = "evil.example"
+
+	authData, err := ParseWebAuthnAttestationObject(authenticator.AttestationObject(authenticator.AuthenticatorData(true)))
+	if err != nil {
+		t.Fatalf("attestation object rejected: %v", err)
+	}
+
+	if err := authData.CheckRPID(testRPID); err == nil {
+		t.Fatal("credential scoped to another relying party was accepted")
+	}
+}
+
+func TestWebAuthnAuthenticatorDataFlags(t *testing.T) {
+	authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+	authenticator.Flags = webauthntest.FlagUserPresent
+
+	authData, err := ParseWebAuthnAuthenticatorData(authenticator.AuthenticatorData(false))
+	if err != nil {
+		t.Fatalf("authenticator data rejected: %v", err)
+	}
+
+	if !authData.UserPresent() {
+		t.Fatal("user presence was not reported")
+	}
+	if authData.UserVerified() {
+		t.Fatal("user verification was reported without the UV flag")
+	}
+}
+
+func TestVerifyWebAuthnAssertionRejectsTampering(t *testing.T) {
+	for name, algorithm := range testAlgorithms {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newTestAuthenticator(t, algorithm)
+			publicKey := authenticator.COSEKey()
+
+			authenticator.SignCount++
+			clientDataJSON := authenticator.ClientDataJSON("webauthn.get", "challenge")
+			authenticatorData := authenticator.AuthenticatorData(false)
+			signature := authenticator.Sign(authenticatorData, clientDataJSON)
+
+			flip := func(data []byte, index int) []byte {
+				tampered := append([]byte{}, data...)
+				tampered[index] ^= 0x01
+				return tampered
+			}
+
+			other := newTestAuthenticator(t, algorithm)
+
+			tests := []struct {
+				name              string
+				publicKey         []byte
+				authenticatorData []byte
+				clientDataJSON    []byte
+				signature         []byte
+			}{
+				{"signature", publicKey, authenticatorData, clientDataJSON, flip(signature, len(signature)-1)},
+				{"sign count", publicKey, flip(authenticatorData, 36), clientDataJSON, signature},
+				{"flags", publicKey, flip(authenticatorData, 32), clientDataJSON, signature},
+				{"client data", publicKey, authenticatorData, flip(clientDataJSON, len(clientDataJSON)-3), signature},
+				{"other key", other.COSEKey(), authenticatorData, clientDataJSON, signature},
+				{"empty signature", publicKey, authenticatorData, clientDataJSON, []byte{}},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					err := VerifyWebAuthnAssertion(test.publicKey, test.authenticatorData, test.clientDataJSON, test.signature)
+					if err == nil {
+						t.Fatal("tampered assertion was accepted")
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyRejectsUnofferedAlgorithms(t *testing.T) {
+	tests := []struct {
+		name string
+		key  webauthntest.Map
+	}{
+		{"ES384", webauthntest.Map{{Key: 1, Value: 2}, {Key: 3, Value: -35}, {Key: -1, Value: 2}, {Key: -2, Value: make([]byte, 48)}, {Key: -3, Value: make([]byte, 48)}}},
+		{"point off the curve", webauthntest.Map{{Key: 1, Value: 2}, {Key: 3, Value: -7}, {Key: -1, Value: 1}, {Key: -2, Value: make([]byte, 32)}, {Key: -3, Value: make([]byte, 32)}}},
+		{"short Ed25519 key", webauthntest.Map{{Key: 1, Value: 1}, {Key: 3, Value: -8}, {Key: -1, Value: 6}, {Key: -2, Value: make([]byte, 31)}}},
+		{"short RSA modulus", webauthntest.Map{{Key: 1, Value: 3}, {Key: 3, Value: -257}, {Key: -1, Value: make([]byte, 128)}, {Key: -2, Value: []byte{1, 0, 1}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := ParseCOSEKey(webauthntest.EncodeCBOR(test.key))
+			if err == nil {
+				t.Fatal("key was accepted")
+			}
+		})
+	}
+}
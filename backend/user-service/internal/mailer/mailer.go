@@ -0,0 +1,15 @@
+package mailer
+
+import "context"
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional mail (password resets, verification links). SMTPMailer is used in production,
+// FileOutbox and MemoryOutbox keep messages local for development and tests.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
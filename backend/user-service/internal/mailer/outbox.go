@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileOutbox writes every message to its own file so local development needs no mail server.
+type FileOutbox struct {
+	Dir string
+}
+
+func NewFileOutbox(dir string) (*FileOutbox, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileOutbox{Dir: dir}, nil
+}
+
+func (outbox *FileOutbox) Send(ctx context.Context, message Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), uuid.New().String())
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", message.To, message.Subject, message.Body)
+
+	return os.WriteFile(filepath.Join(outbox.Dir, name), []byte(content), 0o644)
+}
+
+// MemoryOutbox keeps sent messages in memory, meant for tests.
+type MemoryOutbox struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{}
+}
+
+func (outbox *MemoryOutbox) Send(ctx context.Context, message Message) error {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	outbox.messages = append(outbox.messages, message)
+
+	return nil
+}
+
+func (outbox *MemoryOutbox) Messages() []Message {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	messages := make([]Message, len(outbox.messages))
+	copy(messages, outbox.messages)
+
+	return messages
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+	}
+}
+
+func (mailer *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+
+	body := strings.Join([]string{
+		"From: " + mailer.From,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		message.Body,
+	}, "\r\n")
+
+	err := smtp.SendMail(net.JoinHostPort(mailer.Host, mailer.Port), auth, mailer.From, []string{message.To}, []byte(body))
+	if err != nil {
+		return fmt.Errorf("send mail to %s: %w", message.To, err)
+	}
+
+	return nil
+}
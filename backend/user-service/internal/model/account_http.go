@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type AccountDeleteRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type AccountDeletionResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
@@ -0,0 +1,9 @@
+package model
+
+// AdminUserFilter narrows the admin user list, zero values don't filter.
+type AdminUserFilter struct {
+	Search        string
+	Role          string
+	Suspended     *bool
+	AfterUsername string
+}
@@ -0,0 +1,51 @@
+package model
+
+import "time"
+
+type AdminUserResponse struct {
+	Id                  string     `json:"id"`
+	Username            string     `json:"username"`
+	DisplayName         string     `json:"display_name"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	Suspended           bool       `json:"suspended"`
+	SuspendedUntil      *time.Time `json:"suspended_until"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+type AdminUserListResponse struct {
+	Users      []AdminUserResponse `json:"users"`
+	NextCursor *string             `json:"next_cursor"`
+	HasMore    bool                `json:"has_more"`
+}
+
+// AdminUserDetailResponse is what a moderator sees when opening one account. Suspended_until is nil for a ban.
+type AdminUserDetailResponse struct {
+	Id                  string            `json:"id"`
+	Username            string            `json:"username"`
+	DisplayName         string            `json:"display_name"`
+	Email               string            `json:"email"`
+	EmailVerified       bool              `json:"email_verified"`
+	MfaEnabled          bool              `json:"mfa_enabled"`
+	Role                string            `json:"role"`
+	Suspended           bool              `json:"suspended"`
+	SuspendedAt         *time.Time        `json:"suspended_at"`
+	SuspendedUntil      *time.Time        `json:"suspended_until"`
+	SuspensionReason    string            `json:"suspension_reason"`
+	SuspendedBy         string            `json:"suspended_by"`
+	DeletionScheduledAt *time.Time        `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+	Sessions            []SessionResponse `json:"sessions"`
+}
+
+// AdminSuspendRequest suspends for Duration (e.g. "72h"), an empty duration bans the account until lifted.
+type AdminSuspendRequest struct {
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+type AdminRoleUpdateRequest struct {
+	Role string `json:"role"`
+}
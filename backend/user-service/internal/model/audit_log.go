@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// AuditLog is one entry of the security audit trail. User_id is the account the event is about and Actor_id who
+// caused it, they differ for admin actions. User_id is empty for a failed login on an unknown username.
+type AuditLog struct {
+	Id         int64
+	Event      string
+	User_id    string
+	Actor_id   string
+	Ip_address string
+	User_agent string
+	Metadata   map[string]string
+	Created_at time.Time
+}
+
+// AuditLogFilter narrows the audit log, zero values don't filter.
+type AuditLogFilter struct {
+	UserID   string
+	ActorID  string
+	Event    string
+	BeforeID int64
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+type AuditLogResponse struct {
+	Id        int64             `json:"id"`
+	Event     string            `json:"event"`
+	UserId    string            `json:"user_id"`
+	ActorId   string            `json:"actor_id"`
+	IpAddress string            `json:"ip_address"`
+	UserAgent string            `json:"user_agent"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type AuditLogListResponse struct {
+	Events     []AuditLogResponse `json:"events"`
+	NextCursor *string            `json:"next_cursor"`
+	HasMore    bool               `json:"has_more"`
+}
+
+// ActivityResponse is an audit log entry as the account owner sees it. Actions taken by an admin don't show the
+// admin's id, address or browser, only that they came from an admin.
+type ActivityResponse struct {
+	Id        int64             `json:"id"`
+	Event     string            `json:"event"`
+	ByAdmin   bool              `json:"by_admin"`
+	IpAddress string            `json:"ip_address"`
+	UserAgent string            `json:"user_agent"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type ActivityListResponse struct {
+	Events     []ActivityResponse `json:"events"`
+	NextCursor *string            `json:"next_cursor"`
+	HasMore    bool               `json:"has_more"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+type ContactRequest struct {
+	Id           string
+	Requester_id string
+	Addressee_id string
+	Status       string
+	Created_at   time.Time
+	Responded_at *time.Time
+}
@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+type ContactRequestCreateRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// ContactRequestResponse describes a pending request from the caller's side, User is the other party.
+type ContactRequestResponse struct {
+	Id        string              `json:"id"`
+	User      AllUserInfoResponse `json:"user"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+type ContactRequestListResponse struct {
+	Incoming []ContactRequestResponse `json:"incoming"`
+	Outgoing []ContactRequestResponse `json:"outgoing"`
+}
+
+type ContactResponse struct {
+	Id          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Since       time.Time `json:"since"`
+}
+
+type ContactRequestSendResponse struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type BlockedUserResponse struct {
+	Id          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	BlockedAt   time.Time `json:"blocked_at"`
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type DataExport struct {
+	Id           string
+	User_id      string
+	Status       string
+	File_path    string
+	Created_at   time.Time
+	Completed_at *time.Time
+	Expires_at   *time.Time
+}
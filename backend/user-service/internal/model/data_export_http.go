@@ -0,0 +1,61 @@
+package model
+
+import "time"
+
+type DataExportResponse struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// The types below are the files inside the export archive.
+
+type ExportProfile struct {
+	Id                string     `json:"id"`
+	Username          string     `json:"username"`
+	Email             string     `json:"email"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at"`
+	MfaEnabledAt      *time.Time `json:"mfa_enabled_at"`
+	DisplayName       string     `json:"display_name"`
+	AvatarURL         string     `json:"avatar_url"`
+	Bio               string     `json:"bio"`
+	Locale            string     `json:"locale"`
+	Timezone          string     `json:"timezone"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	PreviousUsernames []string   `json:"previous_usernames"`
+}
+
+type ExportSession struct {
+	Id          string    `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IpAddress   string    `json:"ip_address"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+}
+
+type ExportParticipant struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type ExportConversation struct {
+	Id           int                 `json:"id"`
+	Status       string              `json:"status"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Participants []ExportParticipant `json:"participants"`
+}
+
+type ExportMessage struct {
+	Id             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Sent           bool      `json:"sent"`
+	Text           string    `json:"text"`
+	CreatedAt      time.Time `json:"created_at"`
+}
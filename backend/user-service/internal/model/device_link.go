@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+type DeviceLink struct {
+	Code         string
+	Status       string
+	User_id      string
+	Device_label string
+	User_agent   string
+	Ip_address   string
+	Created_at   time.Time
+	Expires_at   time.Time
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+type DeviceLinkCreateRequest struct {
+	DeviceLabel string `json:"device_label"`
+}
+
+type DeviceLinkCreateResponse struct {
+	PollToken string `json:"poll_token"`
+	Code      string `json:"code"`
+	QrPayload string `json:"qr_payload"`
+	ExpiresIn int    `json:"expires_in"`
+	Interval  int    `json:"interval"`
+}
+
+type DeviceLinkPollRequest struct {
+	PollToken    string `validate:"required" json:"poll_token"`
+	Wait         bool   `json:"wait"`
+	ReturnTokens bool   `json:"return_tokens"`
+}
+
+type DeviceLinkStatusResponse struct {
+	Status string `json:"status"`
+}
+
+type DeviceLinkResponse struct {
+	Code        string    `json:"code"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IpAddress   string    `json:"ip_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
@@ -0,0 +1,9 @@
+package model
+
+type EmailVerifyRequest struct {
+	Token string `validate:"required" json:"token"`
+}
+
+type EmailUpdateRequest struct {
+	Email string `validate:"required|email|maxLen:254" json:"email"`
+}
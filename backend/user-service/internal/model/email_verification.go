@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type EmailVerificationToken struct {
+	Id           int
+	User_id      string
+	Email        string
+	Hashed_token string
+	Created_at   time.Time
+	Expired_at   time.Time
+	Used_at      *time.Time
+}
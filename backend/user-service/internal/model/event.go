@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// UserEvent is published on the user events topic for the other services to react to.
+type UserEvent struct {
+	Type         string            `json:"type"`
+	UserID       string            `json:"user_id"`
+	RecipientIDs []string          `json:"recipient_ids,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	OccurredAt   time.Time         `json:"occurred_at"`
+}
@@ -0,0 +1,6 @@
+package model
+
+type MagicLink struct {
+	User_id string
+	Email   string
+}
@@ -0,0 +1,12 @@
+package model
+
+type MagicLinkRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type MagicLinkLoginRequest struct {
+	Token        string `validate:"required" json:"token"`
+	DeviceLabel  string `json:"device_label"`
+	ReturnTokens bool   `json:"return_tokens"`
+}
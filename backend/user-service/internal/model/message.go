@@ -2,6 +2,12 @@ package model
 
 import "time"
 
+// DeletedUserID is the tombstone that a deleted account's messages and conversation seats are handed over to.
+const DeletedUserID = "00000000-0000-0000-0000-000000000000"
+
+// DeletedUsername stands in for a participant whose account is gone.
+const DeletedUsername = "Deleted user"
+
 type Message struct {
 	ID             int       `json:"id"`
 	ConversationID int       `json:"conversation_id"`
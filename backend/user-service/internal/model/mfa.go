@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+type TotpState struct {
+	User_id    string
+	Username   string
+	Secret     string
+	Enabled_at *time.Time
+	Last_step  int64
+}
+
+type MfaChallenge struct {
+	User_id      string
+	Device_label string
+	Attempts     int
+}
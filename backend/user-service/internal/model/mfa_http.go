@@ -0,0 +1,25 @@
+package model
+
+type TotpEnrollResponse struct {
+	Secret        string   `json:"secret"`
+	OtpauthURI    string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TotpCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MfaChallengeResponse struct {
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type MfaLoginRequest struct {
+	MfaToken     string `validate:"required" json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	ReturnTokens bool   `json:"return_tokens"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+type OidcState struct {
+	Nonce         string
+	Code_verifier string
+	Device_label  string
+}
+
+type UserIdentity struct {
+	Issuer        string
+	Subject       string
+	User_id       string
+	Email         string
+	Created_at    time.Time
+	Last_login_at time.Time
+}
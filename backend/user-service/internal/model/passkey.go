@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+type Passkey struct {
+	Id            string
+	User_id       string
+	Credential_id []byte
+	Public_key    []byte
+	Sign_count    int64
+	Transports    string
+	Name          string
+	Created_at    time.Time
+	Last_used_at  *time.Time
+}
+
+type WebAuthnChallenge struct {
+	User_id  string
+	Ceremony string
+}
@@ -0,0 +1,88 @@
+package model
+
+import "time"
+
+// PublicKeyCredential is the JSON form of a browser credential, binary fields are base64url encoded.
+type PublicKeyCredential struct {
+	Id       string                      `json:"id"`
+	RawId    string                      `json:"rawId"`
+	Type     string                      `json:"type"`
+	Response PublicKeyCredentialResponse `json:"response"`
+}
+
+type PublicKeyCredentialResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+	AuthenticatorData string   `json:"authenticatorData"`
+	Signature         string   `json:"signature"`
+	UserHandle        string   `json:"userHandle"`
+}
+
+type PasskeyRegisterRequest struct {
+	Name       string              `json:"name"`
+	Credential PublicKeyCredential `json:"credential"`
+}
+
+type PasskeyLoginOptionsRequest struct {
+	Username string `json:"username"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential   PublicKeyCredential `json:"credential"`
+	DeviceLabel  string              `json:"device_label"`
+	ReturnTokens bool                `json:"return_tokens"`
+}
+
+type PublicKeyCredentialRpEntity struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PublicKeyCredentialUserEntity struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type PublicKeyCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type PublicKeyCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	Id         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelectionCriteria struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type PasskeyCreationOptionsResponse struct {
+	Challenge              string                          `json:"challenge"`
+	Rp                     PublicKeyCredentialRpEntity     `json:"rp"`
+	User                   PublicKeyCredentialUserEntity   `json:"user"`
+	PubKeyCredParams       []PublicKeyCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int                             `json:"timeout"`
+	ExcludeCredentials     []PublicKeyCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelectionCriteria  `json:"authenticatorSelection"`
+	Attestation            string                          `json:"attestation"`
+}
+
+type PasskeyRequestOptionsResponse struct {
+	Challenge        string                          `json:"challenge"`
+	RpId             string                          `json:"rpId"`
+	Timeout          int                             `json:"timeout"`
+	AllowCredentials []PublicKeyCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                          `json:"userVerification"`
+}
+
+type PasskeyResponse struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
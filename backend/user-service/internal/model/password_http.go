@@ -0,0 +1,11 @@
+package model
+
+type PasswordForgotRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type PasswordResetRequest struct {
+	Token       string `validate:"required" json:"token"`
+	NewPassword string `validate:"required|minLen:5|maxLen:128" json:"new_password"`
+}
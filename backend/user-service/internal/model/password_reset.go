@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+type PasswordResetToken struct {
+	Id           int
+	User_id      string
+	Hashed_token string
+	Created_at   time.Time
+	Expired_at   time.Time
+	Used_at      *time.Time
+}
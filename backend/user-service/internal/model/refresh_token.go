@@ -3,8 +3,15 @@ package model
 import "time"
 
 type RefreshToken struct {
+	Id                   int
 	User_id              string
+	Family_id            string
 	Hashed_refresh_token string
+	Status               string
+	Device_label         string
+	User_agent           string
+	Ip_address           string
 	Created_at           time.Time
+	Last_used_at         time.Time
 	Expired_at           time.Time
 }
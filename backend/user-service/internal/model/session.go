@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+type Session struct {
+	Id           string
+	User_id      string
+	Device_label string
+	User_agent   string
+	Ip_address   string
+	Created_at   time.Time
+	Last_used_at time.Time
+}
+
+type ClientInfo struct {
+	User_agent string
+	Ip_address string
+}
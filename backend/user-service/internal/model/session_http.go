@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type SessionResponse struct {
+	Id          string    `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IpAddress   string    `json:"ip_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	Current     bool      `json:"current"`
+}
@@ -3,9 +3,29 @@ package model
 import "time"
 
 type User struct {
-	Id         string
-	Username   string
-	Password   string
-	Created_at time.Time
-	Updated_at time.Time
+	Id                 string
+	Username           string
+	Username_canonical string
+	Username_skeleton  string
+	Password           string
+	Email              string
+	Email_verified_at  *time.Time
+	Totp_enabled_at    *time.Time
+	Role               string
+	Suspended_at       *time.Time
+	Suspended_until    *time.Time
+	Suspension_reason  string
+	Suspended_by       string
+	Created_at         time.Time
+	Updated_at         time.Time
+}
+
+type UsernameHistory struct {
+	Id                 int
+	User_id            string
+	Username           string
+	Username_canonical string
+	Username_skeleton  string
+	Changed_at         time.Time
+	Held_until         time.Time
 }
@@ -1,21 +1,54 @@
 package model
 
 type UserRegisterRequest struct {
-	Username string `validate:"required|minLen:4|maxLen:22" json:"username"`
-	Password string `validate:"required|minLen:5|maxLen:20" json:"password"`
+	Username    string `validate:"required|minLen:4|maxLen:22" json:"username"`
+	Password    string `validate:"required|minLen:5|maxLen:128" json:"password"`
+	Email       string `validate:"email|maxLen:254" json:"email"`
+	DeviceLabel string `validate:"maxLen:100" json:"device_label"`
 }
 
 type UserLoginRequest struct {
-	Username string `validate:"required|minLen:4|maxLen:22" json:"username"`
-	Password string `validate:"required|minLen:5|maxLen:20" json:"password"`
+	Username     string `validate:"required|minLen:4|maxLen:22" json:"username"`
+	Password     string `validate:"required|minLen:5|maxLen:128" json:"password"`
+	DeviceLabel  string `validate:"maxLen:100" json:"device_label"`
+	ReturnTokens bool   `json:"return_tokens"`
 }
 
 type UserInfoResponse struct {
-	Id       string `json:"id"`
-	Username string `json:"username"`
+	Id            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	MfaEnabled    bool   `json:"mfa_enabled"`
+	DisplayName   string `json:"display_name"`
+	AvatarURL     string `json:"avatar_url"`
+	Bio           string `json:"bio"`
+	Locale        string `json:"locale"`
+	Timezone      string `json:"timezone"`
+}
+
+// UserProfileUpdateRequest is a partial update: omitted fields are left alone, an empty string clears the field.
+type UserProfileUpdateRequest struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Bio         *string `json:"bio"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
 }
 
 type AllUserInfoResponse struct {
-	Id       string `json:"id"`
+	Id          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+type UserSearchResponse struct {
+	Users      []AllUserInfoResponse `json:"users"`
+	NextCursor *string               `json:"next_cursor"`
+	HasMore    bool                  `json:"has_more"`
+}
+
+type UsernameUpdateRequest struct {
 	Username string `json:"username"`
 }
@@ -0,0 +1,252 @@
+// Package oidctest is a stand-in OpenID Connect provider for exercising the single sign-on flow in tests. It
+// serves discovery, JWKS and token endpoints over httptest and signs whatever ID token claims a test asks for.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Provider answers for a single client. Issuer is what the discovery document and ID tokens claim, tests change
+// it to get a provider that isn't the one the relying party was configured with.
+type Provider struct {
+	Server   *httptest.Server
+	Issuer   string
+	ClientID string
+
+	mutex     sync.Mutex
+	key       *ecdsa.PrivateKey
+	kid       string
+	grants    map[string]grant
+	verifiers []string
+}
+
+// grant is an authorization code waiting to be redeemed at the token endpoint.
+type grant struct {
+	redirectURI   string
+	codeChallenge string
+	claims        map[string]interface{}
+}
+
+func New(clientID string) (*Provider, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &Provider{
+		ClientID: clientID,
+		key:      key,
+		kid:      kid,
+		grants:   map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", provider.handleDiscovery)
+	mux.HandleFunc("GET /jwks", provider.handleJWKS)
+	mux.HandleFunc("POST /token", provider.handleToken)
+
+	provider.Server = httptest.NewServer(mux)
+	provider.Issuer = provider.Server.URL
+
+	return provider, nil
+}
+
+func (provider *Provider) Close() {
+	provider.Server.Close()
+}
+
+// Authorize stands in for the user signing in at the authorization url the relying party built. It checks the
+// request the way a provider would and returns the code and state the browser would be redirected back with.
+// claims are laid over the defaults of the ID token the code redeems for, a nil value drops a claim.
+func (provider *Provider) Authorize(authorizationURL string, claims map[string]interface{}) (string, string, error) {
+	parsedURL, err := url.Parse(authorizationURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	if parsedURL.Scheme+"://"+parsedURL.Host+parsedURL.Path != provider.Server.URL+"/authorize" {
+		return "", "", fmt.Errorf("authorization request went to %s", parsedURL.Path)
+	}
+
+	query := parsedURL.Query()
+	if query.Get("response_type") != "code" {
+		return "", "", errors.New("response_type is not code")
+	}
+
+	if query.Get("client_id") != provider.ClientID {
+		return "", "", errors.New("unknown client_id")
+	}
+
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		return "", "", errors.New("authorization request has no S256 code challenge")
+	}
+
+	now := time.Now()
+	idTokenClaims := map[string]interface{}{
+		"iss":   provider.Issuer,
+		"aud":   provider.ClientID,
+		"sub":   "oidctest-subject",
+		"nonce": query.Get("nonce"),
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(idTokenClaims, name)
+			continue
+		}
+		idTokenClaims[name] = value
+	}
+
+	code, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	provider.mutex.Lock()
+	provider.grants[code] = grant{
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		claims:        idTokenClaims,
+	}
+	provider.mutex.Unlock()
+
+	return code, query.Get("state"), nil
+}
+
+// IDToken signs claims as they are with the provider's key.
+func (provider *Provider) IDToken(claims map[string]interface{}) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims(claims))
+	token.Header["kid"] = provider.kid
+
+	return token.SignedString(provider.key)
+}
+
+// CodeVerifiers returns every PKCE verifier the token endpoint was sent, in order.
+func (provider *Provider) CodeVerifiers() []string {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return append([]string(nil), provider.verifiers...)
+}
+
+func (provider *Provider) handleDiscovery(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, model.OidcProviderMetadata{
+		Issuer:                        provider.Issuer,
+		AuthorizationEndpoint:         provider.Server.URL + "/authorize",
+		TokenEndpoint:                 provider.Server.URL + "/token",
+		JwksURI:                       provider.Server.URL + "/jwks",
+		CodeChallengeMethodsSupported: []string{"S256"},
+	})
+}
+
+func (provider *Provider) handleJWKS(writer http.ResponseWriter, request *http.Request) {
+	publicKey := provider.key.PublicKey
+
+	writeJSON(writer, http.StatusOK, model.JSONWebKeySet{Keys: []model.JSONWebKey{{
+		Kty: "EC",
+		Kid: provider.kid,
+		Use: "sig",
+		Alg: "ES256",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+// handleToken redeems a code once, and only for the client and redirect uri it was issued to and with the
+// verifier matching its challenge.
+func (provider *Provider) handleToken(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		writeTokenError(writer, "invalid_request")
+		return
+	}
+
+	if request.PostForm.Get("grant_type") != "authorization_code" {
+		writeTokenError(writer, "unsupported_grant_type")
+		return
+	}
+
+	if request.PostForm.Get("client_id") != provider.ClientID {
+		writeTokenError(writer, "invalid_client")
+		return
+	}
+
+	verifier := request.PostForm.Get("code_verifier")
+
+	provider.mutex.Lock()
+	provider.verifiers = append(provider.verifiers, verifier)
+	codeGrant, ok := provider.grants[request.PostForm.Get("code")]
+	delete(provider.grants, request.PostForm.Get("code"))
+	provider.mutex.Unlock()
+
+	if !ok || codeGrant.redirectURI != request.PostForm.Get("redirect_uri") {
+		writeTokenError(writer, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != codeGrant.codeChallenge {
+		writeTokenError(writer, "invalid_grant")
+		return
+	}
+
+	idToken, err := provider.IDToken(codeGrant.claims)
+	if err != nil {
+		writeTokenError(writer, "server_error")
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, model.OidcTokenResponse{
+		AccessToken: "oidctest-access-token",
+		TokenType:   "Bearer",
+		IdToken:     idToken,
+	})
+}
+
+func writeTokenError(writer http.ResponseWriter, code string) {
+	writeJSON(writer, http.StatusBadRequest, model.OidcTokenResponse{Error: code})
+}
+
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	data, err := sonic.Marshal(body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(data)
+}
+
+func randomString() (string, error) {
+	data := make([]byte, 16)
+
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// activeSuspension is true while a suspension is in force, an expired one needs no cleanup to stop counting.
+const activeSuspension = "(suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > NOW()))"
+
+type AdminRepository struct {
+	Log *zap.Logger
+	DB  *pgxpool.Pool
+}
+
+func NewAdminRepository(zap *zap.Logger, db *pgxpool.Pool) *AdminRepository {
+	return &AdminRepository{
+		Log: zap,
+		DB:  db,
+	}
+}
+
+// GetUsers pages through every account ordered by username, including ones scheduled for deletion.
+func (repository *AdminRepository) GetUsers(ctx context.Context, filter model.AdminUserFilter, limit int, errorMap map[string]string) ([]model.AdminUserResponse, map[string]string) {
+	query := "SELECT id,username,display_name,COALESCE(email,''),role," + activeSuspension + ",suspended_until,deletion_scheduled_at,created_at FROM users WHERE true"
+	args := []any{}
+
+	if filter.Search != "" {
+		pattern := escapeLike(strings.ToLower(filter.Search)) + "%"
+		if utf8.RuneCountInString(filter.Search) >= 3 {
+			pattern = "%" + pattern
+		}
+
+		args = append(args, pattern)
+		query += fmt.Sprintf(" AND (lower(username) LIKE $%d OR lower(display_name) LIKE $%d OR lower(email) LIKE $%d)", len(args), len(args), len(args))
+	}
+
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		query += fmt.Sprintf(" AND role = $%d", len(args))
+	}
+
+	if filter.Suspended != nil {
+		if *filter.Suspended {
+			query += " AND " + activeSuspension
+		} else {
+			query += " AND NOT " + activeSuspension
+		}
+	}
+
+	if filter.AfterUsername != "" {
+		args = append(args, filter.AfterUsername)
+		query += fmt.Sprintf(" AND username > $%d", len(args))
+	}
+
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY username LIMIT $%d", len(args))
+
+	users := []model.AdminUserResponse{}
+
+	rows, err := repository.DB.Query(ctx, query, args...)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return users, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user model.AdminUserResponse
+		err = rows.Scan(&user.Id, &user.Username, &user.DisplayName, &user.Email, &user.Role, &user.Suspended, &user.SuspendedUntil, &user.DeletionScheduledAt, &user.CreatedAt)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return users, errorMap
+		}
+
+		users = append(users, user)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return users, errorMap
+	}
+
+	return users, nil
+}
+
+func (repository *AdminRepository) GetUser(ctx context.Context, userUUID string, errorMap map[string]string) (model.AdminUserDetailResponse, map[string]string) {
+	query := `SELECT id,username,display_name,COALESCE(email,''),email_verified_at IS NOT NULL,totp_enabled_at IS NOT NULL,role,
+	` + activeSuspension + `,suspended_at,suspended_until,suspension_reason,COALESCE(suspended_by,''),deletion_scheduled_at,created_at,updated_at
+	FROM users WHERE id=$1`
+
+	user := model.AdminUserDetailResponse{}
+	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&user.Id, &user.Username, &user.DisplayName, &user.Email, &user.EmailVerified, &user.MfaEnabled, &user.Role,
+		&user.Suspended, &user.SuspendedAt, &user.SuspendedUntil, &user.SuspensionReason, &user.SuspendedBy, &user.DeletionScheduledAt, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return user, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return user, errorMap
+	}
+
+	return user, nil
+}
+
+// GetUserRoleWithTx locks the account row, so a suspension and a role change can't race each other.
+func (repository *AdminRepository) GetUserRoleWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (string, map[string]string) {
+	query := "SELECT role FROM users WHERE id=$1 FOR UPDATE"
+
+	var role string
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return role, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return role, errorMap
+	}
+
+	return role, nil
+}
+
+func (repository *AdminRepository) SuspendUserWithTx(ctx context.Context, tx pgx.Tx, user model.User, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET suspended_at=$1,suspended_until=$2,suspension_reason=$3,suspended_by=$4,updated_at=$1 WHERE id=$5"
+	_, err := tx.Exec(ctx, query, user.Suspended_at, user.Suspended_until, user.Suspension_reason, user.Suspended_by, user.Id)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// UnsuspendUserWithTx returns false when there was no suspension in force to lift.
+func (repository *AdminRepository) UnsuspendUserWithTx(ctx context.Context, tx pgx.Tx, userUUID string, updatedAt time.Time, errorMap map[string]string) (bool, map[string]string) {
+	query := "UPDATE users SET suspended_at=NULL,suspended_until=NULL,suspension_reason='',suspended_by=NULL,updated_at=$1 WHERE id=$2 AND " + activeSuspension
+	result, err := tx.Exec(ctx, query, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (repository *AdminRepository) UpdateUserRoleWithTx(ctx context.Context, tx pgx.Tx, userUUID string, role string, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET role=$1,updated_at=$2 WHERE id=$3"
+	_, err := tx.Exec(ctx, query, role, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
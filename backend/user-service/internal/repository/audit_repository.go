@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type AuditRepository struct {
+	Log *zap.Logger
+	DB  *pgxpool.Pool
+}
+
+func NewAuditRepository(zap *zap.Logger, db *pgxpool.Pool) *AuditRepository {
+	return &AuditRepository{
+		Log: zap,
+		DB:  db,
+	}
+}
+
+func (repository *AuditRepository) AddAuditLog(ctx context.Context, auditLog model.AuditLog, errorMap map[string]string) map[string]string {
+	if auditLog.Metadata == nil {
+		auditLog.Metadata = map[string]string{}
+	}
+
+	query := "INSERT INTO audit_logs (event,user_id,actor_id,ip_address,user_agent,metadata,created_at) VALUES ($1,NULLIF($2,''),NULLIF($3,''),$4,$5,$6,$7)"
+	_, err := repository.DB.Exec(ctx, query, auditLog.Event, auditLog.User_id, auditLog.Actor_id, auditLog.Ip_address, auditLog.User_agent, auditLog.Metadata, auditLog.Created_at)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// GetAuditLogs pages through the audit log newest first, filter.BeforeID is the last id of the previous page.
+func (repository *AuditRepository) GetAuditLogs(ctx context.Context, filter model.AuditLogFilter, limit int, errorMap map[string]string) ([]model.AuditLog, map[string]string) {
+	query := "SELECT id,event,COALESCE(user_id,''),COALESCE(actor_id,''),ip_address,user_agent,metadata,created_at FROM audit_logs WHERE true"
+	args := []any{}
+
+	if filter.UserID != "" {
+		args = append(args, filter.UserID)
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+
+	if filter.ActorID != "" {
+		args = append(args, filter.ActorID)
+		query += fmt.Sprintf(" AND actor_id = $%d", len(args))
+	}
+
+	if filter.Event != "" {
+		args = append(args, filter.Event)
+		query += fmt.Sprintf(" AND event = $%d", len(args))
+	}
+
+	if filter.BeforeID > 0 {
+		args = append(args, filter.BeforeID)
+		query += fmt.Sprintf(" AND id < $%d", len(args))
+	}
+
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	auditLogs := []model.AuditLog{}
+
+	rows, err := repository.DB.Query(ctx, query, args...)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return auditLogs, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var auditLog model.AuditLog
+		err = rows.Scan(&auditLog.Id, &auditLog.Event, &auditLog.User_id, &auditLog.Actor_id, &auditLog.Ip_address, &auditLog.User_agent, &auditLog.Metadata, &auditLog.Created_at)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return auditLogs, errorMap
+		}
+
+		auditLogs = append(auditLogs, auditLog)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return auditLogs, errorMap
+	}
+
+	return auditLogs, nil
+}
@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ContactRepository struct {
+	Log *zap.Logger
+	DB  *pgxpool.Pool
+}
+
+func NewContactRepository(zap *zap.Logger, db *pgxpool.Pool) *ContactRepository {
+	return &ContactRepository{
+		Log: zap,
+		DB:  db,
+	}
+}
+
+func (repository *ContactRepository) CheckUserExistsWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) map[string]string {
+	query := "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)"
+
+	var exists bool
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&exists)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if !exists {
+		errorMap["user_id"] = "user not found"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) IsContactWithTx(ctx context.Context, tx pgx.Tx, userUUID string, contactUUID string, errorMap map[string]string) (bool, map[string]string) {
+	query := "SELECT EXISTS(SELECT 1 FROM contacts WHERE user_id=$1 AND contact_id=$2)"
+
+	var exists bool
+	err := tx.QueryRow(ctx, query, userUUID, contactUUID).Scan(&exists)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return exists, nil
+}
+
+// GetPendingRequestBetweenWithTx finds the open request between two users in either direction, found is false when there is none.
+func (repository *ContactRepository) GetPendingRequestBetweenWithTx(ctx context.Context, tx pgx.Tx, userUUID string, otherUUID string, errorMap map[string]string) (model.ContactRequest, bool, map[string]string) {
+	query := `
+	SELECT id,requester_id,addressee_id,status,created_at,responded_at FROM contact_requests
+	WHERE status='Pending' AND ((requester_id=$1 AND addressee_id=$2) OR (requester_id=$2 AND addressee_id=$1))
+	FOR UPDATE
+	`
+
+	var request model.ContactRequest
+	err := tx.QueryRow(ctx, query, userUUID, otherUUID).Scan(&request.Id, &request.Requester_id, &request.Addressee_id, &request.Status, &request.Created_at, &request.Responded_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return request, false, nil
+		}
+		errorMap["internal"] = "failed to query into database"
+		return request, false, errorMap
+	}
+
+	return request, true, nil
+}
+
+func (repository *ContactRepository) AddContactRequestWithTx(ctx context.Context, tx pgx.Tx, request model.ContactRequest, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO contact_requests (id,requester_id,addressee_id,status,created_at) VALUES ($1,$2,$3,$4,$5)"
+	_, err := tx.Exec(ctx, query, request.Id, request.Requester_id, request.Addressee_id, request.Status, request.Created_at)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			errorMap["user_id"] = "contact request is already pending"
+			return errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) GetContactRequestWithTx(ctx context.Context, tx pgx.Tx, requestID string, errorMap map[string]string) (model.ContactRequest, map[string]string) {
+	query := "SELECT id,requester_id,addressee_id,status,created_at,responded_at FROM contact_requests WHERE id=$1 FOR UPDATE"
+
+	var request model.ContactRequest
+	err := tx.QueryRow(ctx, query, requestID).Scan(&request.Id, &request.Requester_id, &request.Addressee_id, &request.Status, &request.Created_at, &request.Responded_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["contact_request"] = "contact request not found"
+			return request, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return request, errorMap
+	}
+
+	return request, nil
+}
+
+func (repository *ContactRepository) UpdateContactRequestStatusWithTx(ctx context.Context, tx pgx.Tx, requestID string, status string, respondedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE contact_requests SET status = $1, responded_at = $2 WHERE id = $3"
+	_, err := tx.Exec(ctx, query, status, respondedAt, requestID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// AddContactWithTx stores the contact in both directions.
+func (repository *ContactRepository) AddContactWithTx(ctx context.Context, tx pgx.Tx, userUUID string, contactUUID string, createdAt time.Time, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO contacts (user_id,contact_id,created_at) VALUES ($1,$2,$3),($2,$1,$3) ON CONFLICT DO NOTHING"
+	_, err := tx.Exec(ctx, query, userUUID, contactUUID, createdAt)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) DeleteContact(ctx context.Context, userUUID string, contactUUID string, errorMap map[string]string) map[string]string {
+	query := "DELETE FROM contacts WHERE (user_id=$1 AND contact_id=$2) OR (user_id=$2 AND contact_id=$1)"
+	result, err := repository.DB.Exec(ctx, query, userUUID, contactUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["contact"] = "contact not found"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) GetContacts(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.ContactResponse, map[string]string) {
+	query := `
+	SELECT u.id,u.username,u.display_name,u.avatar_url,c.created_at
+	FROM contacts c
+	JOIN users u ON u.id = c.contact_id
+	WHERE c.user_id = $1
+	ORDER BY u.username
+	`
+
+	contacts := []model.ContactResponse{}
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return contacts, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contact model.ContactResponse
+		err = rows.Scan(&contact.Id, &contact.Username, &contact.DisplayName, &contact.AvatarURL, &contact.Since)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return contacts, errorMap
+		}
+
+		contacts = append(contacts, contact)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return contacts, errorMap
+	}
+
+	return contacts, nil
+}
+
+// GetPendingContactRequests splits the user's open requests into the ones they received and the ones they sent.
+func (repository *ContactRepository) GetPendingContactRequests(ctx context.Context, userUUID string, errorMap map[string]string) (model.ContactRequestListResponse, map[string]string) {
+	query := `
+	SELECT cr.id,cr.created_at,cr.requester_id=$1,u.id,u.username,u.display_name,u.avatar_url
+	FROM contact_requests cr
+	JOIN users u ON u.id = CASE WHEN cr.requester_id=$1 THEN cr.addressee_id ELSE cr.requester_id END
+	WHERE (cr.requester_id=$1 OR cr.addressee_id=$1) AND cr.status='Pending'
+	ORDER BY cr.created_at DESC
+	`
+
+	response := model.ContactRequestListResponse{
+		Incoming: []model.ContactRequestResponse{},
+		Outgoing: []model.ContactRequestResponse{},
+	}
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return response, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var request model.ContactRequestResponse
+		var outgoing bool
+		err = rows.Scan(&request.Id, &request.CreatedAt, &outgoing, &request.User.Id, &request.User.Username, &request.User.DisplayName, &request.User.AvatarURL)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return response, errorMap
+		}
+
+		if outgoing {
+			response.Outgoing = append(response.Outgoing, request)
+		} else {
+			response.Incoming = append(response.Incoming, request)
+		}
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return response, errorMap
+	}
+
+	return response, nil
+}
+
+// IsBlockedWithTx reports whether either user has blocked the other.
+func (repository *ContactRepository) IsBlockedWithTx(ctx context.Context, tx pgx.Tx, userUUID string, otherUUID string, errorMap map[string]string) (bool, map[string]string) {
+	query := "SELECT EXISTS(SELECT 1 FROM user_blocks WHERE (blocker_id=$1 AND blocked_id=$2) OR (blocker_id=$2 AND blocked_id=$1))"
+
+	var blocked bool
+	err := tx.QueryRow(ctx, query, userUUID, otherUUID).Scan(&blocked)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return blocked, nil
+}
+
+func (repository *ContactRepository) AddBlockWithTx(ctx context.Context, tx pgx.Tx, blockerUUID string, blockedUUID string, createdAt time.Time, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO user_blocks (blocker_id,blocked_id,created_at) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING"
+	_, err := tx.Exec(ctx, query, blockerUUID, blockedUUID, createdAt)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) DeleteContactWithTx(ctx context.Context, tx pgx.Tx, userUUID string, contactUUID string, errorMap map[string]string) map[string]string {
+	query := "DELETE FROM contacts WHERE (user_id=$1 AND contact_id=$2) OR (user_id=$2 AND contact_id=$1)"
+	_, err := tx.Exec(ctx, query, userUUID, contactUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// CancelPendingRequestBetweenWithTx closes the open request between two users, if there is one, in either direction.
+func (repository *ContactRepository) CancelPendingRequestBetweenWithTx(ctx context.Context, tx pgx.Tx, userUUID string, otherUUID string, respondedAt time.Time, errorMap map[string]string) map[string]string {
+	query := `
+	UPDATE contact_requests SET status='Canceled', responded_at=$3
+	WHERE status='Pending' AND ((requester_id=$1 AND addressee_id=$2) OR (requester_id=$2 AND addressee_id=$1))
+	`
+	_, err := tx.Exec(ctx, query, userUUID, otherUUID, respondedAt)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) DeleteBlock(ctx context.Context, blockerUUID string, blockedUUID string, errorMap map[string]string) map[string]string {
+	query := "DELETE FROM user_blocks WHERE blocker_id=$1 AND blocked_id=$2"
+	result, err := repository.DB.Exec(ctx, query, blockerUUID, blockedUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["block"] = "block not found"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ContactRepository) GetBlockedUsers(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.BlockedUserResponse, map[string]string) {
+	query := `
+	SELECT u.id,u.username,u.display_name,u.avatar_url,b.created_at
+	FROM user_blocks b
+	JOIN users u ON u.id = b.blocked_id
+	WHERE b.blocker_id = $1
+	ORDER BY b.created_at DESC
+	`
+
+	users := []model.BlockedUserResponse{}
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return users, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user model.BlockedUserResponse
+		err = rows.Scan(&user.Id, &user.Username, &user.DisplayName, &user.AvatarURL, &user.BlockedAt)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return users, errorMap
+		}
+
+		users = append(users, user)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return users, errorMap
+	}
+
+	return users, nil
+}
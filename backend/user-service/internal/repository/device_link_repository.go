@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// a link is answered once, whichever of approve and decline gets there first wins
+var answerDeviceLinkScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') ~= 'Pending' then return 0 end
+redis.call('HSET', KEYS[1], 'status', ARGV[1], 'user_id', ARGV[2])
+return 1
+`)
+
+// an approved link hands out exactly one session, however many polls are in flight
+var claimDeviceLinkScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') ~= 'Approved' then return 0 end
+redis.call('HSET', KEYS[1], 'status', 'Claimed')
+return 1
+`)
+
+type DeviceLinkRepository struct {
+	Log     *zap.Logger
+	DBCache *redis.ClusterClient
+}
+
+func NewDeviceLinkRepository(zap *zap.Logger, dbCache *redis.ClusterClient) *DeviceLinkRepository {
+	return &DeviceLinkRepository{
+		Log:     zap,
+		DBCache: dbCache,
+	}
+}
+
+func deviceLinkKey(hashedPollToken string) string {
+	return "device_link:" + hashedPollToken
+}
+
+func deviceLinkCodeKey(code string) string {
+	return "device_link_code:" + code
+}
+
+// AddDeviceLink returns false when the code is already in use, the caller picks another one.
+func (repository *DeviceLinkRepository) AddDeviceLink(ctx context.Context, hashedPollToken string, link model.DeviceLink, ttl time.Duration, errorMap map[string]string) (bool, map[string]string) {
+	created, err := repository.DBCache.SetNX(ctx, deviceLinkCodeKey(link.Code), hashedPollToken, ttl).Result()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return false, errorMap
+	}
+
+	if !created {
+		return false, nil
+	}
+
+	key := deviceLinkKey(hashedPollToken)
+
+	_, err = repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"code", link.Code,
+			"status", link.Status,
+			"user_id", link.User_id,
+			"device_label", link.Device_label,
+			"user_agent", link.User_agent,
+			"ip_address", link.Ip_address,
+			"created_at", link.Created_at.Unix(),
+			"expires_at", link.Expires_at.Unix(),
+		)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return false, errorMap
+	}
+
+	return true, nil
+}
+
+func (repository *DeviceLinkRepository) GetDeviceLink(ctx context.Context, hashedPollToken string, errorMap map[string]string) (model.DeviceLink, map[string]string) {
+	link := model.DeviceLink{}
+
+	values, err := repository.DBCache.HGetAll(ctx, deviceLinkKey(hashedPollToken)).Result()
+	if err != nil {
+		errorMap["internal"] = "failed to get into redis"
+		return link, errorMap
+	}
+
+	if values["status"] == "" {
+		errorMap["device_link"] = "device link is invalid or expired"
+		return link, errorMap
+	}
+
+	createdAt, _ := strconv.ParseInt(values["created_at"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(values["expires_at"], 10, 64)
+
+	link = model.DeviceLink{
+		Code:         values["code"],
+		Status:       values["status"],
+		User_id:      values["user_id"],
+		Device_label: values["device_label"],
+		User_agent:   values["user_agent"],
+		Ip_address:   values["ip_address"],
+		Created_at:   time.Unix(createdAt, 0),
+		Expires_at:   time.Unix(expiresAt, 0),
+	}
+
+	return link, nil
+}
+
+func (repository *DeviceLinkRepository) GetDeviceLinkByCode(ctx context.Context, code string, errorMap map[string]string) (string, model.DeviceLink, map[string]string) {
+	hashedPollToken, err := repository.DBCache.Get(ctx, deviceLinkCodeKey(code)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			errorMap["device_link"] = "device link not found"
+			return "", model.DeviceLink{}, errorMap
+		}
+		errorMap["internal"] = "failed to get into redis"
+		return "", model.DeviceLink{}, errorMap
+	}
+
+	link, errorMap := repository.GetDeviceLink(ctx, hashedPollToken, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			return "", link, errorMap
+		}
+		return "", link, map[string]string{"device_link": "device link not found"}
+	}
+
+	return hashedPollToken, link, nil
+}
+
+// AnswerDeviceLink moves a pending link to Approved or Declined and returns false if it was already answered.
+func (repository *DeviceLinkRepository) AnswerDeviceLink(ctx context.Context, hashedPollToken string, status string, userUUID string, errorMap map[string]string) (bool, map[string]string) {
+	answered, err := answerDeviceLinkScript.Run(ctx, repository.DBCache, []string{deviceLinkKey(hashedPollToken)}, status, userUUID).Int()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return false, errorMap
+	}
+
+	return answered == 1, nil
+}
+
+func (repository *DeviceLinkRepository) ClaimDeviceLink(ctx context.Context, hashedPollToken string, errorMap map[string]string) (bool, map[string]string) {
+	claimed, err := claimDeviceLinkScript.Run(ctx, repository.DBCache, []string{deviceLinkKey(hashedPollToken)}).Int()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return false, errorMap
+	}
+
+	return claimed == 1, nil
+}
+
+func (repository *DeviceLinkRepository) DeleteDeviceLink(ctx context.Context, hashedPollToken string, code string, errorMap map[string]string) map[string]string {
+	// the two keys can live on different cluster slots, so they are deleted one by one
+	err := repository.DBCache.Del(ctx, deviceLinkKey(hashedPollToken)).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to delete key in redis db"
+		return errorMap
+	}
+
+	err = repository.DBCache.Del(ctx, deviceLinkCodeKey(code)).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to delete key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
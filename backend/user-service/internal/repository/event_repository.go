@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"go.uber.org/zap"
+)
+
+type EventRepository struct {
+	Log      *zap.Logger
+	Producer *kafka.Producer
+	Topic    string
+}
+
+func NewEventRepository(zap *zap.Logger, producer *kafka.Producer, topic string) *EventRepository {
+	return &EventRepository{
+		Log:      zap,
+		Producer: producer,
+		Topic:    topic,
+	}
+}
+
+// Publish waits for the broker to acknowledge the event. Events are keyed by user so one user's events stay ordered.
+func (repository *EventRepository) Publish(ctx context.Context, event model.UserEvent) error {
+	message, err := sonic.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// buffered and never closed, a late delivery report after ctx is done must not block or panic
+	deliveryChan := make(chan kafka.Event, 1)
+
+	err = repository.Producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &repository.Topic, Partition: kafka.PartitionAny},
+		Key:            []byte(event.UserID),
+		Value:          message,
+	}, deliveryChan)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case e := <-deliveryChan:
+		m := e.(*kafka.Message)
+		return m.TopicPartition.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
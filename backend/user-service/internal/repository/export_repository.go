@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ExportRepository struct {
+	Log *zap.Logger
+	DB  *pgxpool.Pool
+}
+
+func NewExportRepository(zap *zap.Logger, db *pgxpool.Pool) *ExportRepository {
+	return &ExportRepository{
+		Log: zap,
+		DB:  db,
+	}
+}
+
+func (repository *ExportRepository) AddDataExport(ctx context.Context, export model.DataExport, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO data_exports (id,user_id,status,created_at) VALUES ($1,$2,$3,$4)"
+	_, err := repository.DB.Exec(ctx, query, export.Id, export.User_id, export.Status, export.Created_at)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			errorMap["export"] = "an export is already in progress"
+			return errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ExportRepository) GetDataExport(ctx context.Context, exportID string, userUUID string, errorMap map[string]string) (model.DataExport, map[string]string) {
+	query := "SELECT id,user_id,status,file_path,created_at,completed_at,expires_at FROM data_exports WHERE id=$1 AND user_id=$2"
+
+	var export model.DataExport
+	err := repository.DB.QueryRow(ctx, query, exportID, userUUID).Scan(&export.Id, &export.User_id, &export.Status, &export.File_path,
+		&export.Created_at, &export.Completed_at, &export.Expires_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["export"] = "export not found"
+			return export, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return export, errorMap
+	}
+
+	return export, nil
+}
+
+// CompleteDataExport reports export not found when the row went away during the build, the account was purged.
+func (repository *ExportRepository) CompleteDataExport(ctx context.Context, exportID string, filePath string, completedAt time.Time, expiresAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE data_exports SET status='Ready', file_path=$1, completed_at=$2, expires_at=$3 WHERE id=$4"
+	result, err := repository.DB.Exec(ctx, query, filePath, completedAt, expiresAt, exportID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["export"] = "export not found"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ExportRepository) FailDataExport(ctx context.Context, exportID string, completedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE data_exports SET status='Failed', completed_at=$1 WHERE id=$2"
+	_, err := repository.DB.Exec(ctx, query, completedAt, exportID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// FailStaleDataExports gives up on exports that were still being built when the process that owned them went away.
+func (repository *ExportRepository) FailStaleDataExports(ctx context.Context, startedBefore time.Time, now time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE data_exports SET status='Failed', completed_at=$1 WHERE status='Pending' AND created_at < $2"
+	_, err := repository.DB.Exec(ctx, query, now, startedBefore)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// ExpireDataExports marks ready exports past their expiry as Expired and returns their files for removal.
+func (repository *ExportRepository) ExpireDataExports(ctx context.Context, now time.Time, errorMap map[string]string) ([]string, map[string]string) {
+	query := `
+	WITH expired AS (
+		SELECT id, file_path FROM data_exports WHERE status='Ready' AND expires_at <= $1 FOR UPDATE
+	)
+	UPDATE data_exports d SET status='Expired', file_path=''
+	FROM expired WHERE d.id = expired.id
+	RETURNING expired.file_path
+	`
+
+	filePaths := []string{}
+
+	rows, err := repository.DB.Query(ctx, query, now)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return filePaths, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var filePath string
+		err = rows.Scan(&filePath)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return filePaths, errorMap
+		}
+
+		filePaths = append(filePaths, filePath)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return filePaths, errorMap
+	}
+
+	return filePaths, nil
+}
+
+func (repository *ExportRepository) GetProfileExport(ctx context.Context, userUUID string, errorMap map[string]string) (model.ExportProfile, map[string]string) {
+	query := `
+	SELECT id,username,COALESCE(email,''),email_verified_at,totp_enabled_at,display_name,avatar_url,bio,locale,timezone,created_at,updated_at,
+	       ARRAY(SELECT username FROM username_history WHERE user_id = users.id ORDER BY changed_at)
+	FROM users WHERE id=$1
+	`
+
+	var profile model.ExportProfile
+	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&profile.Id, &profile.Username, &profile.Email, &profile.EmailVerifiedAt,
+		&profile.MfaEnabledAt, &profile.DisplayName, &profile.AvatarURL, &profile.Bio, &profile.Locale, &profile.Timezone,
+		&profile.CreatedAt, &profile.UpdatedAt, &profile.PreviousUsernames)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return profile, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return profile, errorMap
+	}
+
+	return profile, nil
+}
+
+// GetSessionsExport lists every session the user ever had, not just the active ones, as of its latest refresh token.
+func (repository *ExportRepository) GetSessionsExport(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.ExportSession, map[string]string) {
+	query := `
+	SELECT DISTINCT ON (rt.family_id) rt.family_id, rt.device_label, rt.user_agent, rt.ip_address, rt.status,
+	       (SELECT MIN(created_at) FROM refresh_tokens WHERE family_id = rt.family_id),
+	       rt.last_used_at
+	FROM refresh_tokens rt
+	WHERE rt.user_id = $1
+	ORDER BY rt.family_id, rt.created_at DESC
+	`
+
+	sessions := []model.ExportSession{}
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return sessions, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session model.ExportSession
+		err = rows.Scan(&session.Id, &session.DeviceLabel, &session.UserAgent, &session.IpAddress, &session.Status, &session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return sessions, errorMap
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return sessions, errorMap
+	}
+
+	return sessions, nil
+}
+
+func (repository *ExportRepository) GetConversationsExport(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.ExportConversation, map[string]string) {
+	query := `
+	SELECT c.id, cp.status, c.created_at, cp2.user_id, COALESCE(u.username, $2)
+	FROM conversation_participants cp
+	JOIN conversations c ON c.id = cp.conversation_id
+	JOIN conversation_participants cp2 ON cp2.conversation_id = c.id
+	LEFT JOIN users u ON u.id = cp2.user_id
+	WHERE cp.user_id = $1
+	ORDER BY c.id, u.username
+	`
+
+	conversations := []model.ExportConversation{}
+
+	rows, err := repository.DB.Query(ctx, query, userUUID, model.DeletedUsername)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return conversations, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversation model.ExportConversation
+		var participant model.ExportParticipant
+		err = rows.Scan(&conversation.Id, &conversation.Status, &conversation.CreatedAt, &participant.Id, &participant.Username)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return conversations, errorMap
+		}
+
+		last := len(conversations) - 1
+		if last >= 0 && conversations[last].Id == conversation.Id {
+			conversations[last].Participants = append(conversations[last].Participants, participant)
+			continue
+		}
+
+		conversation.Participants = []model.ExportParticipant{participant}
+		conversations = append(conversations, conversation)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return conversations, errorMap
+	}
+
+	return conversations, nil
+}
+
+// ForEachMessageExport streams every message in the user's conversations, sent or received, so a long history
+// never has to fit in memory.
+func (repository *ExportRepository) ForEachMessageExport(ctx context.Context, userUUID string, fn func(model.ExportMessage) error) error {
+	query := `
+	SELECT m.id, m.conversation_id, m.sender_id, m.text, m.created_at
+	FROM messages m
+	JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id
+	WHERE cp.user_id = $1
+	ORDER BY m.conversation_id, m.id
+	`
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message model.ExportMessage
+		err = rows.Scan(&message.Id, &message.ConversationID, &message.SenderID, &message.Text, &message.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		message.Sent = message.SenderID == userUUID
+
+		err = fn(message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"time"
+)
+
+type PasskeyRepository struct {
+	Log     *zap.Logger
+	DB      *pgxpool.Pool
+	DBCache *redis.ClusterClient
+}
+
+func NewPasskeyRepository(zap *zap.Logger, db *pgxpool.Pool, dbCache *redis.ClusterClient) *PasskeyRepository {
+	return &PasskeyRepository{
+		Log:     zap,
+		DB:      db,
+		DBCache: dbCache,
+	}
+}
+
+func (repository *PasskeyRepository) AddWebAuthnChallenge(ctx context.Context, hashedChallenge string, challenge model.WebAuthnChallenge, ttl time.Duration, errorMap map[string]string) map[string]string {
+	key := "webauthn_challenge:" + hashedChallenge
+
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", challenge.User_id, "ceremony", challenge.Ceremony)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+// TakeWebAuthnChallenge reads and deletes the challenge in one go, so every challenge is answered at most once.
+func (repository *PasskeyRepository) TakeWebAuthnChallenge(ctx context.Context, hashedChallenge string, errorMap map[string]string) (model.WebAuthnChallenge, map[string]string) {
+	key := "webauthn_challenge:" + hashedChallenge
+	challenge := model.WebAuthnChallenge{}
+
+	var values *redis.MapStringStringCmd
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		values = pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to get into redis"
+		return challenge, errorMap
+	}
+
+	if values.Val()["ceremony"] == "" {
+		errorMap["challenge"] = "challenge is invalid or expired"
+		return challenge, errorMap
+	}
+
+	challenge.User_id = values.Val()["user_id"]
+	challenge.Ceremony = values.Val()["ceremony"]
+
+	return challenge, nil
+}
+
+func (repository *PasskeyRepository) AddPasskey(ctx context.Context, passkey model.Passkey, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO passkeys (id,user_id,credential_id,public_key,sign_count,transports,name,created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)"
+	_, err := repository.DB.Exec(ctx, query, passkey.Id, passkey.User_id, passkey.Credential_id, passkey.Public_key, passkey.Sign_count,
+		passkey.Transports, passkey.Name, passkey.Created_at)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			errorMap["credential"] = "passkey is already registered"
+			return errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// GetPasskeyByCredentialIDWithTx locks the row so two assertions with the same sign count can't both pass.
+func (repository *PasskeyRepository) GetPasskeyByCredentialIDWithTx(ctx context.Context, tx pgx.Tx, credentialID []byte, errorMap map[string]string) (model.Passkey, map[string]string) {
+	query := "SELECT id,user_id,credential_id,public_key,sign_count,transports,name,created_at,last_used_at FROM passkeys WHERE credential_id=$1 FOR UPDATE"
+
+	var passkey model.Passkey
+	err := tx.QueryRow(ctx, query, credentialID).Scan(&passkey.Id, &passkey.User_id, &passkey.Credential_id, &passkey.Public_key,
+		&passkey.Sign_count, &passkey.Transports, &passkey.Name, &passkey.Created_at, &passkey.Last_used_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["credential"] = "passkey is not recognized"
+			return passkey, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return passkey, errorMap
+	}
+
+	return passkey, nil
+}
+
+func (repository *PasskeyRepository) UpdatePasskeyUsageWithTx(ctx context.Context, tx pgx.Tx, passkeyID string, signCount int64, usedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE passkeys SET sign_count = $1, last_used_at = $2 WHERE id = $3"
+	_, err := tx.Exec(ctx, query, signCount, usedAt, passkeyID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *PasskeyRepository) GetPasskeys(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.Passkey, map[string]string) {
+	query := "SELECT id,user_id,credential_id,public_key,sign_count,transports,name,created_at,last_used_at FROM passkeys WHERE user_id=$1 ORDER BY created_at"
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return nil, errorMap
+	}
+	defer rows.Close()
+
+	passkeys := []model.Passkey{}
+	for rows.Next() {
+		var passkey model.Passkey
+		err = rows.Scan(&passkey.Id, &passkey.User_id, &passkey.Credential_id, &passkey.Public_key, &passkey.Sign_count,
+			&passkey.Transports, &passkey.Name, &passkey.Created_at, &passkey.Last_used_at)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return nil, errorMap
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return nil, errorMap
+	}
+
+	return passkeys, nil
+}
+
+func (repository *PasskeyRepository) DeletePasskey(ctx context.Context, userUUID string, passkeyID string, errorMap map[string]string) map[string]string {
+	query := "DELETE FROM passkeys WHERE id=$1 AND user_id=$2"
+	result, err := repository.DB.Exec(ctx, query, passkeyID, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["passkey"] = "passkey not found"
+		return errorMap
+	}
+
+	return nil
+}
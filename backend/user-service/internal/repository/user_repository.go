@@ -4,13 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// counts an attempt only while the challenge is alive, a bare HINCRBY would recreate an expired key without a TTL
+var incrMfaChallengeAttemptsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+return redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+`)
+
 type UserRepository struct {
 	Log     *zap.Logger
 	DB      *pgxpool.Pool
@@ -26,9 +38,15 @@ func NewUserRepository(zap *zap.Logger, db *pgxpool.Pool, dbCache *redis.Cluster
 }
 
 func (repository *UserRepository) RegisterWithTx(ctx context.Context, tx pgx.Tx, user model.User, errorMap map[string]string) map[string]string {
-	query := "INSERT INTO users (id,username,password,created_at,updated_at) VALUES ($1,$2,$3,$4,$5)"
-	_, err := tx.Exec(ctx, query, user.Id, user.Username, user.Password, user.Created_at, user.Updated_at)
+	query := "INSERT INTO users (id,username,username_canonical,username_skeleton,password,email,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,NULLIF($6,''),$7,$8)"
+	_, err := tx.Exec(ctx, query, user.Id, user.Username, user.Username_canonical, user.Username_skeleton, user.Password, user.Email, user.Created_at, user.Updated_at)
 	if err != nil {
+		// a concurrent registration can still win the race past CheckUsernameUniqueWithTx
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_username_canonical_idx" {
+			errorMap["username"] = "username is already taken"
+			return errorMap
+		}
 		errorMap["internal"] = "failed to query into database"
 		return errorMap
 		//repository.Log.Panic("failed to query into database", zap.Error(err))
@@ -37,13 +55,110 @@ func (repository *UserRepository) RegisterWithTx(ctx context.Context, tx pgx.Tx,
 	return nil
 }
 
-func (repository *UserRepository) CheckUsernameUniqueWithTx(ctx context.Context, tx pgx.Tx, username string, errorMap map[string]string) error {
+// CheckUsernameUniqueWithTx rejects names that collide with another account by canonical form or by skeleton,
+// or with a handle someone else gave up too recently. userUUID excludes the caller's own names when renaming.
+func (repository *UserRepository) CheckUsernameUniqueWithTx(ctx context.Context, tx pgx.Tx, canonical string, skeleton string, userUUID string, now time.Time, errorMap map[string]string) map[string]string {
+	query := `
+	SELECT EXISTS(
+		SELECT 1 FROM users WHERE (username_canonical=$1 OR username_skeleton=$2) AND id!=$3
+	) OR EXISTS(
+		SELECT 1 FROM username_history WHERE username_skeleton=$2 AND held_until>$4 AND user_id!=$3
+	)
+	`
+
+	var taken bool
+	err := tx.QueryRow(ctx, query, canonical, skeleton, userUUID, now).Scan(&taken)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if taken {
+		errorMap["username"] = "username is already taken"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) GetUsernameWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,username,username_canonical,username_skeleton FROM users WHERE id=$1 FOR UPDATE"
+
+	var user model.User
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&user.Id, &user.Username, &user.Username_canonical, &user.Username_skeleton)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return user, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return user, errorMap
+	}
+
+	return user, nil
+}
+
+func (repository *UserRepository) GetLastUsernameChangeWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (*time.Time, map[string]string) {
+	query := "SELECT max(changed_at) FROM username_history WHERE user_id=$1"
+
+	var changedAt *time.Time
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&changedAt)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return nil, errorMap
+	}
+
+	return changedAt, nil
+}
+
+func (repository *UserRepository) AddUsernameHistoryWithTx(ctx context.Context, tx pgx.Tx, history model.UsernameHistory, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO username_history (user_id,username,username_canonical,username_skeleton,changed_at,held_until) VALUES ($1,$2,$3,$4,$5,$6)"
+	_, err := tx.Exec(ctx, query, history.User_id, history.Username, history.Username_canonical, history.Username_skeleton, history.Changed_at, history.Held_until)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) UpdateUsernameWithTx(ctx context.Context, tx pgx.Tx, user model.User, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET username = $1, username_canonical = $2, username_skeleton = $3, updated_at = $4 WHERE id = $5"
+	_, err := tx.Exec(ctx, query, user.Username, user.Username_canonical, user.Username_skeleton, updatedAt, user.Id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_username_canonical_idx" {
+			errorMap["username"] = "username is already taken"
+			return errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) CheckEmailUniqueWithTx(ctx context.Context, tx pgx.Tx, email string, errorMap map[string]string) map[string]string {
+	query := "SELECT EXISTS(SELECT 1 FROM users WHERE email=$1)"
+
+	var exists bool
+	err := tx.QueryRow(ctx, query, email).Scan(&exists)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if exists {
+		errorMap["email"] = "email is already registered"
+		return errorMap
+	}
+
 	return nil
 }
 
 func (repository *UserRepository) AddRefreshTokenWithTx(ctx context.Context, tx pgx.Tx, refreshtoken model.RefreshToken, errorMap map[string]string) map[string]string {
-	query := "INSERT INTO refresh_tokens (user_id,hashed_refresh_token,created_at,expired_at) VALUES ($1,$2,$3,$4)"
-	_, err := tx.Exec(ctx, query, refreshtoken.User_id, refreshtoken.Hashed_refresh_token, refreshtoken.Created_at, refreshtoken.Expired_at)
+	query := "INSERT INTO refresh_tokens (user_id,family_id,hashed_refresh_token,device_label,user_agent,ip_address,created_at,last_used_at,expired_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)"
+	_, err := tx.Exec(ctx, query, refreshtoken.User_id, refreshtoken.Family_id, refreshtoken.Hashed_refresh_token, refreshtoken.Device_label, refreshtoken.User_agent, refreshtoken.Ip_address, refreshtoken.Created_at, refreshtoken.Last_used_at, refreshtoken.Expired_at)
 	if err != nil {
 		errorMap["internal"] = "failed to query into database"
 		return errorMap
@@ -53,23 +168,281 @@ func (repository *UserRepository) AddRefreshTokenWithTx(ctx context.Context, tx
 	return nil
 }
 
-func (repository *UserRepository) UpdateRefreshTokenWithTx(ctx context.Context, tx pgx.Tx, tokenStatus string, userUUID string, errorMap map[string]string) map[string]string {
-	query := "UPDATE refresh_tokens SET status = $1 WHERE user_id = $2 AND created_at = (SELECT MAX(created_at) FROM refresh_tokens WHERE user_id = $2)"
-	_, err := tx.Exec(ctx, query, tokenStatus, userUUID)
+func (repository *UserRepository) GetRefreshTokenWithTx(ctx context.Context, tx pgx.Tx, hashedRefreshToken string, errorMap map[string]string) (model.RefreshToken, map[string]string) {
+	query := "SELECT id,user_id,family_id,status,device_label,user_agent,ip_address,expired_at FROM refresh_tokens WHERE hashed_refresh_token=$1 FOR UPDATE"
+
+	var refreshToken model.RefreshToken
+	err := tx.QueryRow(ctx, query, hashedRefreshToken).Scan(&refreshToken.Id, &refreshToken.User_id, &refreshToken.Family_id, &refreshToken.Status, &refreshToken.Device_label, &refreshToken.User_agent, &refreshToken.Ip_address, &refreshToken.Expired_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["auth"] = "refresh token is invalid"
+			return refreshToken, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return refreshToken, errorMap
+	}
+
+	return refreshToken, nil
+}
+
+func (repository *UserRepository) UpdateRefreshTokenStatusWithTx(ctx context.Context, tx pgx.Tx, tokenStatus string, refreshTokenID int, errorMap map[string]string) map[string]string {
+	query := "UPDATE refresh_tokens SET status = $1 WHERE id = $2"
+	_, err := tx.Exec(ctx, query, tokenStatus, refreshTokenID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) RevokeRefreshTokenFamilyWithTx(ctx context.Context, tx pgx.Tx, familyID string, errorMap map[string]string) map[string]string {
+	query := "UPDATE refresh_tokens SET status = 'Revoke' WHERE family_id = $1 AND status != 'Revoke'"
+	_, err := tx.Exec(ctx, query, familyID)
 	if err != nil {
 		errorMap["internal"] = "failed to query into database"
 		return errorMap
-		//repository.Log.Panic("failed to query into database", zap.Error(err))
 	}
 
 	return nil
 }
 
-func (repository *UserRepository) LoginWithTx(ctx context.Context, tx pgx.Tx, username string, errorMap map[string]string) (model.User, map[string]string) {
-	query := "SELECT id,password FROM users WHERE username=$1"
+func (repository *UserRepository) GetSessions(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.Session, map[string]string) {
+	query := `
+	SELECT rt.family_id, rt.device_label, rt.user_agent, rt.ip_address,
+	       (SELECT MIN(created_at) FROM refresh_tokens WHERE family_id = rt.family_id),
+	       rt.last_used_at
+	FROM refresh_tokens rt
+	WHERE rt.user_id = $1
+	  AND rt.status = 'Valid'
+	  AND rt.expired_at > NOW()
+	ORDER BY rt.last_used_at DESC
+	`
+
+	sessions := []model.Session{}
+
+	rows, err := repository.DB.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return sessions, errorMap
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		session := model.Session{User_id: userUUID}
+		err = rows.Scan(&session.Id, &session.Device_label, &session.User_agent, &session.Ip_address, &session.Created_at, &session.Last_used_at)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return sessions, errorMap
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// GetSessionStartedAtWithTx returns when the user signed into the session, rotating its refresh token doesn't
+// move this forward.
+func (repository *UserRepository) GetSessionStartedAtWithTx(ctx context.Context, tx pgx.Tx, userUUID string, sessionID string, errorMap map[string]string) (time.Time, map[string]string) {
+	query := "SELECT MIN(created_at) FROM refresh_tokens WHERE user_id = $1 AND family_id = $2"
+
+	var startedAt *time.Time
+	err := tx.QueryRow(ctx, query, userUUID, sessionID).Scan(&startedAt)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return time.Time{}, errorMap
+	}
+
+	if startedAt == nil {
+		errorMap["session"] = "session not found"
+		return time.Time{}, errorMap
+	}
+
+	return *startedAt, nil
+}
+
+func (repository *UserRepository) RevokeSession(ctx context.Context, userUUID string, sessionID string, errorMap map[string]string) map[string]string {
+	query := "UPDATE refresh_tokens SET status = 'Revoke' WHERE user_id = $1 AND family_id = $2 AND status = 'Valid'"
+	result, err := repository.DB.Exec(ctx, query, userUUID, sessionID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["session"] = "session not found"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) RevokeAllSessions(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
+	query := "UPDATE refresh_tokens SET status = 'Revoke' WHERE user_id = $1 AND status != 'Revoke'"
+	_, err := repository.DB.Exec(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) GetRefreshTokenFamily(ctx context.Context, hashedRefreshToken string, errorMap map[string]string) (string, map[string]string) {
+	query := "SELECT family_id FROM refresh_tokens WHERE hashed_refresh_token=$1"
+
+	var familyID string
+	err := repository.DB.QueryRow(ctx, query, hashedRefreshToken).Scan(&familyID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["auth"] = "refresh token is invalid"
+			return familyID, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return familyID, errorMap
+	}
+
+	return familyID, nil
+}
+
+func (repository *UserRepository) RevokeRefreshTokenFamilyByHash(ctx context.Context, hashedRefreshToken string, errorMap map[string]string) map[string]string {
+	query := "UPDATE refresh_tokens SET status = 'Revoke' WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE hashed_refresh_token = $1 LIMIT 1) AND status != 'Revoke'"
+	_, err := repository.DB.Exec(ctx, query, hashedRefreshToken)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) RevokeAccessToken(ctx context.Context, jti string, ttl time.Duration, errorMap map[string]string) map[string]string {
+	err := repository.DBCache.Set(ctx, "access_token:"+jti+":revoked", "1", ttl).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) RevokeSessionAccessTokens(ctx context.Context, sessionID string, ttl time.Duration, errorMap map[string]string) map[string]string {
+	err := repository.DBCache.Set(ctx, "session:"+sessionID+":revoked", "1", ttl).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) RevokeUserAccessTokens(ctx context.Context, userUUID string, revokedAt time.Time, ttl time.Duration, errorMap map[string]string) map[string]string {
+	err := repository.DBCache.Set(ctx, "user:"+userUUID+":tokens_revoked_at", revokedAt.UnixMilli(), ttl).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+// IsAccessTokenRevoked checks the token, its session and its user against the deny list in one round trip.
+// issuedAt and the stored revocation time are both in milliseconds.
+func (repository *UserRepository) IsAccessTokenRevoked(ctx context.Context, userUUID string, sessionID string, jti string, issuedAt int64, errorMap map[string]string) (bool, map[string]string) {
+	pipe := repository.DBCache.Pipeline()
+	jtiCmd := pipe.Exists(ctx, "access_token:"+jti+":revoked")
+	sessionCmd := pipe.Exists(ctx, "session:"+sessionID+":revoked")
+	revokedAtCmd := pipe.Get(ctx, "user:"+userUUID+":tokens_revoked_at")
+
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		errorMap["internal"] = "failed to get into redis"
+		return false, errorMap
+	}
+
+	if jti != "" && jtiCmd.Val() > 0 {
+		return true, nil
+	}
+
+	if sessionID != "" && sessionCmd.Val() > 0 {
+		return true, nil
+	}
+
+	if revokedAtCmd.Err() == nil {
+		revokedAt, err := strconv.ParseInt(revokedAtCmd.Val(), 10, 64)
+		if err == nil && issuedAt < revokedAt {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// loginThrottleKey gives the counter and the lock of one subject the same hash tag so they share a cluster slot.
+func loginThrottleKey(scope string, subject string, suffix string) string {
+	return "login:{" + scope + ":" + subject + "}:" + suffix
+}
+
+// GetLoginLockout returns how long the username or the ip is still locked out for, whichever is longer.
+func (repository *UserRepository) GetLoginLockout(ctx context.Context, username string, ipAddress string, errorMap map[string]string) (time.Duration, map[string]string) {
+	pipe := repository.DBCache.Pipeline()
+	userCmd := pipe.PTTL(ctx, loginThrottleKey("user", username, "lock"))
+	ipCmd := pipe.PTTL(ctx, loginThrottleKey("ip", ipAddress, "lock"))
+
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		errorMap["internal"] = "failed to get into redis"
+		return 0, errorMap
+	}
+
+	return max(userCmd.Val(), ipCmd.Val(), 0), nil
+}
+
+// AddLoginFailure bumps the failure counter of the subject and returns the new count.
+func (repository *UserRepository) AddLoginFailure(ctx context.Context, scope string, subject string, window time.Duration, errorMap map[string]string) (int64, map[string]string) {
+	key := loginThrottleKey(scope, subject, "failures")
+
+	pipe := repository.DBCache.TxPipeline()
+	countCmd := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return 0, errorMap
+	}
+
+	return countCmd.Val(), nil
+}
+
+func (repository *UserRepository) LockLogin(ctx context.Context, scope string, subject string, duration time.Duration, errorMap map[string]string) map[string]string {
+	err := repository.DBCache.Set(ctx, loginThrottleKey(scope, subject, "lock"), "1", duration).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) ResetLoginFailures(ctx context.Context, scope string, subject string, errorMap map[string]string) map[string]string {
+	err := repository.DBCache.Del(ctx, loginThrottleKey(scope, subject, "failures"), loginThrottleKey(scope, subject, "lock")).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to delete key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) LoginWithTx(ctx context.Context, tx pgx.Tx, usernameCanonical string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,password,COALESCE(email,''),email_verified_at,totp_enabled_at,suspended_at,suspended_until FROM users WHERE username_canonical=$1"
 
 	var user model.User
-	err := tx.QueryRow(ctx, query, username).Scan(&user.Id, &user.Password)
+	err := tx.QueryRow(ctx, query, usernameCanonical).Scan(&user.Id, &user.Password, &user.Email, &user.Email_verified_at, &user.Totp_enabled_at,
+		&user.Suspended_at, &user.Suspended_until)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -84,73 +457,831 @@ func (repository *UserRepository) LoginWithTx(ctx context.Context, tx pgx.Tx, us
 	return user, nil
 }
 
-func (repository *UserRepository) CheckUserExistence(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
-	query := "SELECT username FROM users WHERE id=$1"
-
-	var username string
-	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&username)
+func (repository *UserRepository) GetUserByUsername(ctx context.Context, usernameCanonical string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,username,COALESCE(email,''),email_verified_at FROM users WHERE username_canonical=$1"
 
+	var user model.User
+	err := repository.DB.QueryRow(ctx, query, usernameCanonical).Scan(&user.Id, &user.Username, &user.Email, &user.Email_verified_at)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, pgx.ErrNoRows) {
 			errorMap["user"] = "user not found"
-			return errorMap
+			return user, errorMap
 		}
-		errorMap["internal"] = "failed to query database"
-		return errorMap
-		//repository.Log.Panic("failed to query database", zap.Error(err))
+		errorMap["internal"] = "failed to query into database"
+		return user, errorMap
 	}
 
-	return nil
+	return user, nil
 }
 
-func (repository *UserRepository) GetUserInfo(ctx context.Context, userUUID string, errorMap map[string]string) (model.UserInfoResponse, map[string]string) {
-	query := "SELECT id,username FROM users WHERE id=$1"
+func (repository *UserRepository) GetUserByEmail(ctx context.Context, email string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,username,COALESCE(email,''),email_verified_at FROM users WHERE email=$1"
 
-	user := model.UserInfoResponse{}
-	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&user.Id, &user.Username)
+	var user model.User
+	err := repository.DB.QueryRow(ctx, query, email).Scan(&user.Id, &user.Username, &user.Email, &user.Email_verified_at)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			errorMap["user"] = "user not found"
 			return user, errorMap
-		} else {
-			fmt.Println(err.Error())
-			errorMap["internal"] = "failed to query into database"
-			return user, errorMap
 		}
+		errorMap["internal"] = "failed to query into database"
+		return user, errorMap
 	}
 
 	return user, nil
 }
 
-func (repository *UserRepository) GetAllUserData(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.AllUserInfoResponse, map[string]string) {
-	query := "SELECT id,username FROM users WHERE id!=$1"
-
-	var users []model.AllUserInfoResponse
+func (repository *UserRepository) GetUserByID(ctx context.Context, userUUID string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,username,COALESCE(email,''),email_verified_at FROM users WHERE id=$1"
 
-	rows, err := repository.DB.Query(ctx, query, userUUID)
+	var user model.User
+	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&user.Id, &user.Username, &user.Email, &user.Email_verified_at)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return user, errorMap
+		}
 		errorMap["internal"] = "failed to query into database"
-		return users, errorMap
+		return user, errorMap
 	}
 
-	hasData := false
+	return user, nil
+}
 
-	for rows.Next() {
-		var user model.AllUserInfoResponse
-		err = rows.Scan(&user.Id, &user.Username)
-		if err != nil {
-			errorMap["internal"] = "failed to scan query result"
-			return users, errorMap
-		}
+// GetUserAccessWithTx reads what goes into an access token, and whether one may be issued at all.
+func (repository *UserRepository) GetUserAccessWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,role,suspended_at,suspended_until FROM users WHERE id=$1"
 
-		hasData = true
-		users = append(users, user)
+	var user model.User
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&user.Id, &user.Role, &user.Suspended_at, &user.Suspended_until)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return user, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return user, errorMap
 	}
 
-	if hasData == false {
-		errorMap["user"] = "user not found"
-		return users, errorMap
-	}
+	return user, nil
+}
+
+func (repository *UserRepository) UpdateEmailWithTx(ctx context.Context, tx pgx.Tx, userUUID string, email string, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET email = $1, email_verified_at = NULL, updated_at = $2 WHERE id = $3"
+	_, err := tx.Exec(ctx, query, email, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) AddEmailVerificationToken(ctx context.Context, verificationToken model.EmailVerificationToken, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO email_verification_tokens (user_id,email,hashed_token,created_at,expired_at) VALUES ($1,$2,$3,$4,$5)"
+	_, err := repository.DB.Exec(ctx, query, verificationToken.User_id, verificationToken.Email, verificationToken.Hashed_token, verificationToken.Created_at, verificationToken.Expired_at)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) GetEmailVerificationTokenWithTx(ctx context.Context, tx pgx.Tx, hashedToken string, errorMap map[string]string) (model.EmailVerificationToken, map[string]string) {
+	query := "SELECT id,user_id,email,expired_at,used_at FROM email_verification_tokens WHERE hashed_token=$1 FOR UPDATE"
+
+	verificationToken := model.EmailVerificationToken{Hashed_token: hashedToken}
+	err := tx.QueryRow(ctx, query, hashedToken).Scan(&verificationToken.Id, &verificationToken.User_id, &verificationToken.Email, &verificationToken.Expired_at, &verificationToken.Used_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["token"] = "verification token is invalid or expired"
+			return verificationToken, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return verificationToken, errorMap
+	}
+
+	return verificationToken, nil
+}
+
+// VerifyEmailWithTx only marks the address verified if it is still the one the token was sent to.
+func (repository *UserRepository) VerifyEmailWithTx(ctx context.Context, tx pgx.Tx, userUUID string, email string, verifiedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET email_verified_at = $1, updated_at = $1 WHERE id = $2 AND email = $3"
+	result, err := tx.Exec(ctx, query, verifiedAt, userUUID, email)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["token"] = "verification token is invalid or expired"
+		return errorMap
+	}
+
+	query = "UPDATE email_verification_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL"
+	_, err = tx.Exec(ctx, query, verifiedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) AddPasswordResetToken(ctx context.Context, resetToken model.PasswordResetToken, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO password_reset_tokens (user_id,hashed_token,created_at,expired_at) VALUES ($1,$2,$3,$4)"
+	_, err := repository.DB.Exec(ctx, query, resetToken.User_id, resetToken.Hashed_token, resetToken.Created_at, resetToken.Expired_at)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) GetPasswordResetTokenWithTx(ctx context.Context, tx pgx.Tx, hashedToken string, errorMap map[string]string) (model.PasswordResetToken, map[string]string) {
+	query := "SELECT id,user_id,expired_at,used_at FROM password_reset_tokens WHERE hashed_token=$1 FOR UPDATE"
+
+	resetToken := model.PasswordResetToken{Hashed_token: hashedToken}
+	err := tx.QueryRow(ctx, query, hashedToken).Scan(&resetToken.Id, &resetToken.User_id, &resetToken.Expired_at, &resetToken.Used_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["token"] = "reset token is invalid or expired"
+			return resetToken, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return resetToken, errorMap
+	}
+
+	return resetToken, nil
+}
+
+// UsePasswordResetTokensWithTx burns every outstanding reset token of the user, not only the one redeemed.
+func (repository *UserRepository) UsePasswordResetTokensWithTx(ctx context.Context, tx pgx.Tx, userUUID string, usedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL"
+	_, err := tx.Exec(ctx, query, usedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) UpdatePasswordWithTx(ctx context.Context, tx pgx.Tx, userUUID string, hashedPassword string, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET password = $1, updated_at = $2 WHERE id = $3"
+	_, err := tx.Exec(ctx, query, hashedPassword, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// GetTotpStateWithTx locks the user row so concurrent code submissions can't both pass the replay check.
+func (repository *UserRepository) GetTotpStateWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (model.TotpState, map[string]string) {
+	query := "SELECT id,username,COALESCE(totp_secret,''),totp_enabled_at,totp_last_step FROM users WHERE id=$1 FOR UPDATE"
+
+	var state model.TotpState
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&state.User_id, &state.Username, &state.Secret, &state.Enabled_at, &state.Last_step)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return state, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return state, errorMap
+	}
+
+	return state, nil
+}
+
+func (repository *UserRepository) SetTotpSecretWithTx(ctx context.Context, tx pgx.Tx, userUUID string, encryptedSecret string, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET totp_secret = $1, totp_enabled_at = NULL, totp_last_step = 0, updated_at = $2 WHERE id = $3"
+	_, err := tx.Exec(ctx, query, encryptedSecret, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) EnableTotpWithTx(ctx context.Context, tx pgx.Tx, userUUID string, enabledAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET totp_enabled_at = $1, updated_at = $1 WHERE id = $2"
+	_, err := tx.Exec(ctx, query, enabledAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) UpdateTotpLastStepWithTx(ctx context.Context, tx pgx.Tx, userUUID string, step int64, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET totp_last_step = $1 WHERE id = $2"
+	_, err := tx.Exec(ctx, query, step, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) DisableTotpWithTx(ctx context.Context, tx pgx.Tx, userUUID string, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = $1 WHERE id = $2"
+	_, err := tx.Exec(ctx, query, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	query = "DELETE FROM mfa_recovery_codes WHERE user_id = $1"
+	_, err = tx.Exec(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) ReplaceRecoveryCodesWithTx(ctx context.Context, tx pgx.Tx, userUUID string, hashedCodes []string, createdAt time.Time, errorMap map[string]string) map[string]string {
+	query := "DELETE FROM mfa_recovery_codes WHERE user_id = $1"
+	_, err := tx.Exec(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	batch := &pgx.Batch{}
+	for _, hashedCode := range hashedCodes {
+		batch.Queue("INSERT INTO mfa_recovery_codes (user_id,hashed_code,created_at) VALUES ($1,$2,$3)", userUUID, hashedCode, createdAt)
+	}
+
+	err = tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// UseRecoveryCodeWithTx burns the code and reports whether an unused one matched.
+func (repository *UserRepository) UseRecoveryCodeWithTx(ctx context.Context, tx pgx.Tx, userUUID string, hashedCode string, usedAt time.Time, errorMap map[string]string) (bool, map[string]string) {
+	query := "UPDATE mfa_recovery_codes SET used_at = $1 WHERE user_id = $2 AND hashed_code = $3 AND used_at IS NULL"
+	result, err := tx.Exec(ctx, query, usedAt, userUUID, hashedCode)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (repository *UserRepository) AddMfaChallenge(ctx context.Context, hashedToken string, challenge model.MfaChallenge, ttl time.Duration, errorMap map[string]string) map[string]string {
+	key := "mfa_challenge:" + hashedToken
+
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", challenge.User_id, "device_label", challenge.Device_label, "attempts", 0)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) GetMfaChallenge(ctx context.Context, hashedToken string, errorMap map[string]string) (model.MfaChallenge, map[string]string) {
+	challenge := model.MfaChallenge{}
+
+	values, err := repository.DBCache.HGetAll(ctx, "mfa_challenge:"+hashedToken).Result()
+	if err != nil {
+		errorMap["internal"] = "failed to get into redis"
+		return challenge, errorMap
+	}
+
+	if values["user_id"] == "" {
+		errorMap["mfa_token"] = "mfa token is invalid or expired"
+		return challenge, errorMap
+	}
+
+	challenge.User_id = values["user_id"]
+	challenge.Device_label = values["device_label"]
+	challenge.Attempts, _ = strconv.Atoi(values["attempts"])
+
+	return challenge, nil
+}
+
+func (repository *UserRepository) IncrMfaChallengeAttempts(ctx context.Context, hashedToken string, errorMap map[string]string) (int, map[string]string) {
+	attempts, err := incrMfaChallengeAttemptsScript.Run(ctx, repository.DBCache, []string{"mfa_challenge:" + hashedToken}).Int()
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return 0, errorMap
+	}
+
+	if attempts < 0 {
+		errorMap["mfa_token"] = "mfa token is invalid or expired"
+		return 0, errorMap
+	}
+
+	return attempts, nil
+}
+
+func (repository *UserRepository) DeleteMfaChallenge(ctx context.Context, hashedToken string, errorMap map[string]string) map[string]string {
+	err := repository.DBCache.Del(ctx, "mfa_challenge:"+hashedToken).Err()
+	if err != nil {
+		errorMap["internal"] = "failed to delete key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+// CheckUserExistence runs on every authenticated request, so it turns away suspended accounts in the same query.
+func (repository *UserRepository) CheckUserExistence(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
+	query := "SELECT suspended_at,suspended_until FROM users WHERE id=$1"
+
+	var suspendedAt *time.Time
+	var suspendedUntil *time.Time
+	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&suspendedAt, &suspendedUntil)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errorMap["user"] = "user not found"
+			return errorMap
+		}
+		errorMap["internal"] = "failed to query database"
+		return errorMap
+		//repository.Log.Panic("failed to query database", zap.Error(err))
+	}
+
+	if helper.IsSuspended(suspendedAt, suspendedUntil, time.Now()) {
+		return helper.NewAccountSuspendedError(suspendedUntil)
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) GetUserInfo(ctx context.Context, userUUID string, errorMap map[string]string) (model.UserInfoResponse, map[string]string) {
+	query := "SELECT id,username,COALESCE(email,''),email_verified_at IS NOT NULL,totp_enabled_at IS NOT NULL,display_name,avatar_url,bio,locale,timezone FROM users WHERE id=$1"
+
+	user := model.UserInfoResponse{}
+	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&user.Id, &user.Username, &user.Email, &user.EmailVerified, &user.MfaEnabled,
+		&user.DisplayName, &user.AvatarURL, &user.Bio, &user.Locale, &user.Timezone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return user, errorMap
+		} else {
+			fmt.Println(err.Error())
+			errorMap["internal"] = "failed to query into database"
+			return user, errorMap
+		}
+	}
+
+	return user, nil
+}
+
+// UpdateProfile only touches the fields that are set, nil keeps the stored value.
+func (repository *UserRepository) UpdateProfile(ctx context.Context, userUUID string, profile model.UserProfileUpdateRequest, updatedAt time.Time, errorMap map[string]string) map[string]string {
+	query := `
+	UPDATE users SET
+		display_name = COALESCE($1, display_name),
+		avatar_url = COALESCE($2, avatar_url),
+		bio = COALESCE($3, bio),
+		locale = COALESCE($4, locale),
+		timezone = COALESCE($5, timezone),
+		updated_at = $6
+	WHERE id = $7
+	`
+
+	result, err := repository.DB.Exec(ctx, query, profile.DisplayName, profile.AvatarURL, profile.Bio, profile.Locale, profile.Timezone, updatedAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	if result.RowsAffected() == 0 {
+		errorMap["user"] = "user not found"
+		return errorMap
+	}
+
+	return nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// SearchUsers pages through users ordered by username, afterUsername is the last username of the previous page.
+// Queries shorter than three characters only match name prefixes, longer ones match anywhere via the trigram indexes.
+func (repository *UserRepository) SearchUsers(ctx context.Context, userUUID string, search string, afterUsername string, limit int, errorMap map[string]string) ([]model.AllUserInfoResponse, map[string]string) {
+	// suspended users are hidden, as are users blocked by the caller and users who blocked the caller
+	query := `SELECT id,username,display_name,avatar_url FROM users WHERE id!=$1 AND deletion_scheduled_at IS NULL
+	AND (suspended_at IS NULL OR suspended_until <= NOW())
+	AND NOT EXISTS(SELECT 1 FROM user_blocks WHERE (blocker_id=$1 AND blocked_id=users.id) OR (blocker_id=users.id AND blocked_id=$1))`
+	args := []any{userUUID}
+
+	if search != "" {
+		pattern := escapeLike(strings.ToLower(search)) + "%"
+		if utf8.RuneCountInString(search) >= 3 {
+			pattern = "%" + pattern
+		}
+
+		args = append(args, pattern)
+		query += fmt.Sprintf(" AND (lower(username) LIKE $%d OR lower(display_name) LIKE $%d)", len(args), len(args))
+	}
+
+	if afterUsername != "" {
+		args = append(args, afterUsername)
+		query += fmt.Sprintf(" AND username > $%d", len(args))
+	}
+
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY username LIMIT $%d", len(args))
+
+	users := []model.AllUserInfoResponse{}
+
+	rows, err := repository.DB.Query(ctx, query, args...)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return users, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user model.AllUserInfoResponse
+		err = rows.Scan(&user.Id, &user.Username, &user.DisplayName, &user.AvatarURL)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return users, errorMap
+		}
+
+		users = append(users, user)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return users, errorMap
+	}
 
 	return users, nil
 }
+
+func (repository *UserRepository) GetPasswordWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (string, map[string]string) {
+	query := "SELECT password FROM users WHERE id=$1 FOR UPDATE"
+
+	var password string
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&password)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return password, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return password, errorMap
+	}
+
+	return password, nil
+}
+
+func (repository *UserRepository) ScheduleAccountDeletionWithTx(ctx context.Context, tx pgx.Tx, userUUID string, requestedAt time.Time, scheduledAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE users SET deletion_requested_at = $1, deletion_scheduled_at = $2, updated_at = $1 WHERE id = $3"
+	_, err := tx.Exec(ctx, query, requestedAt, scheduledAt, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// CancelAccountDeletionWithTx clears a pending deletion, canceled is false when none was scheduled.
+func (repository *UserRepository) CancelAccountDeletionWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (bool, map[string]string) {
+	query := "UPDATE users SET deletion_requested_at = NULL, deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL"
+	result, err := tx.Exec(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (repository *UserRepository) GetDueAccountDeletions(ctx context.Context, now time.Time, limit int, errorMap map[string]string) ([]string, map[string]string) {
+	query := "SELECT id FROM users WHERE deletion_scheduled_at <= $1 ORDER BY deletion_scheduled_at LIMIT $2"
+
+	userIDs := []string{}
+
+	rows, err := repository.DB.Query(ctx, query, now, limit)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return userIDs, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return userIDs, errorMap
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return userIDs, errorMap
+	}
+
+	return userIDs, nil
+}
+
+// LockDueAccountDeletionWithTx re-checks the schedule under a row lock, so a login that canceled the deletion in the
+// meantime or another purge worker holding the row both make it report false.
+func (repository *UserRepository) LockDueAccountDeletionWithTx(ctx context.Context, tx pgx.Tx, userUUID string, now time.Time, errorMap map[string]string) (bool, map[string]string) {
+	query := "SELECT id FROM users WHERE id = $1 AND deletion_scheduled_at <= $2 FOR UPDATE SKIP LOCKED"
+
+	var userID string
+	err := tx.QueryRow(ctx, query, userUUID, now).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return true, nil
+}
+
+func (repository *UserRepository) GetConversationIDsWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) ([]int, map[string]string) {
+	query := "SELECT conversation_id FROM conversation_participants WHERE user_id = $1"
+
+	conversationIDs := []int{}
+
+	rows, err := tx.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return conversationIDs, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID int
+		err = rows.Scan(&conversationID)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return conversationIDs, errorMap
+		}
+
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return conversationIDs, errorMap
+	}
+
+	return conversationIDs, nil
+}
+
+// AnonymizeMessagesWithTx hands the user's messages over to the tombstone sender, the text stays for the other participants.
+func (repository *UserRepository) AnonymizeMessagesWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) map[string]string {
+	query := "UPDATE messages SET sender_id = $1 WHERE sender_id = $2"
+	_, err := tx.Exec(ctx, query, model.DeletedUserID, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// HandOverConversationParticipationWithTx moves the user's seat in their conversations to the tombstone sender,
+// so the others still find the conversation and its history. A conversation the tombstone already sits in just
+// loses the user.
+func (repository *UserRepository) HandOverConversationParticipationWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) map[string]string {
+	query := `
+	UPDATE conversation_participants cp SET user_id = $1
+	WHERE cp.user_id = $2
+	  AND NOT EXISTS (
+		SELECT 1 FROM conversation_participants tombstone WHERE tombstone.conversation_id = cp.conversation_id AND tombstone.user_id = $1
+	  )
+	`
+	_, err := tx.Exec(ctx, query, model.DeletedUserID, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	query = "DELETE FROM conversation_participants WHERE user_id = $1"
+	_, err = tx.Exec(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// GetDataExportFilePathsWithTx returns the archives of the user's exports, their rows go with the user row but
+// the files have to be removed separately.
+func (repository *UserRepository) GetDataExportFilePathsWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) ([]string, map[string]string) {
+	query := "SELECT file_path FROM data_exports WHERE user_id = $1 AND file_path != ''"
+
+	filePaths := []string{}
+
+	rows, err := tx.Query(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return filePaths, errorMap
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var filePath string
+		err = rows.Scan(&filePath)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return filePaths, errorMap
+		}
+
+		filePaths = append(filePaths, filePath)
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query into database"
+		return filePaths, errorMap
+	}
+
+	return filePaths, nil
+}
+
+// DeleteUserWithTx removes the user row, tokens, codes, contacts and blocks go with it through their cascades.
+func (repository *UserRepository) DeleteUserWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) map[string]string {
+	query := "DELETE FROM users WHERE id = $1"
+	_, err := tx.Exec(ctx, query, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) AddOidcState(ctx context.Context, hashedState string, state model.OidcState, ttl time.Duration, errorMap map[string]string) map[string]string {
+	key := "oidc_state:" + hashedState
+
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "nonce", state.Nonce, "code_verifier", state.Code_verifier, "device_label", state.Device_label)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+// TakeOidcState reads and deletes the state in one go, so a callback can only be completed once.
+func (repository *UserRepository) TakeOidcState(ctx context.Context, hashedState string, errorMap map[string]string) (model.OidcState, map[string]string) {
+	key := "oidc_state:" + hashedState
+	state := model.OidcState{}
+
+	var values *redis.MapStringStringCmd
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		values = pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to get into redis"
+		return state, errorMap
+	}
+
+	if values.Val()["nonce"] == "" {
+		errorMap["state"] = "sign in request is invalid or expired"
+		return state, errorMap
+	}
+
+	state.Nonce = values.Val()["nonce"]
+	state.Code_verifier = values.Val()["code_verifier"]
+	state.Device_label = values.Val()["device_label"]
+
+	return state, nil
+}
+
+func (repository *UserRepository) GetUserIdentityWithTx(ctx context.Context, tx pgx.Tx, issuer string, subject string, errorMap map[string]string) (model.UserIdentity, map[string]string) {
+	query := "SELECT issuer,subject,user_id,email,created_at,last_login_at FROM user_identities WHERE issuer=$1 AND subject=$2"
+
+	var identity model.UserIdentity
+	err := tx.QueryRow(ctx, query, issuer, subject).Scan(&identity.Issuer, &identity.Subject, &identity.User_id, &identity.Email,
+		&identity.Created_at, &identity.Last_login_at)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["identity"] = "identity not found"
+			return identity, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return identity, errorMap
+	}
+
+	return identity, nil
+}
+
+func (repository *UserRepository) AddUserIdentityWithTx(ctx context.Context, tx pgx.Tx, identity model.UserIdentity, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO user_identities (issuer,subject,user_id,email,created_at,last_login_at) VALUES ($1,$2,$3,$4,$5,$6)"
+	_, err := tx.Exec(ctx, query, identity.Issuer, identity.Subject, identity.User_id, identity.Email, identity.Created_at, identity.Last_login_at)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *UserRepository) UpdateUserIdentityLoginWithTx(ctx context.Context, tx pgx.Tx, issuer string, subject string, email string, lastLoginAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE user_identities SET email = $1, last_login_at = $2 WHERE issuer = $3 AND subject = $4"
+	_, err := tx.Exec(ctx, query, email, lastLoginAt, issuer, subject)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// GetUserByVerifiedEmailWithTx only matches addresses the account owner has proven, an unverified address
+// says nothing about who holds the account.
+func (repository *UserRepository) GetUserByVerifiedEmailWithTx(ctx context.Context, tx pgx.Tx, email string, errorMap map[string]string) (model.User, map[string]string) {
+	query := "SELECT id,username,email FROM users WHERE email=$1 AND email_verified_at IS NOT NULL"
+
+	var user model.User
+	err := tx.QueryRow(ctx, query, email).Scan(&user.Id, &user.Username, &user.Email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return user, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return user, errorMap
+	}
+
+	return user, nil
+}
+
+func (repository *UserRepository) AddMagicLink(ctx context.Context, jti string, magicLink model.MagicLink, ttl time.Duration, errorMap map[string]string) map[string]string {
+	key := "magic_link:" + jti
+
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", magicLink.User_id, "email", magicLink.Email)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to set key in redis db"
+		return errorMap
+	}
+
+	return nil
+}
+
+// TakeMagicLink reads and deletes the link in one go, the first request to present it is the only one that counts.
+func (repository *UserRepository) TakeMagicLink(ctx context.Context, jti string, errorMap map[string]string) (model.MagicLink, map[string]string) {
+	key := "magic_link:" + jti
+	magicLink := model.MagicLink{}
+
+	var values *redis.MapStringStringCmd
+	_, err := repository.DBCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		values = pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		errorMap["internal"] = "failed to get into redis"
+		return magicLink, errorMap
+	}
+
+	if values.Val()["user_id"] == "" {
+		errorMap["token"] = "sign in link is invalid or expired"
+		return magicLink, errorMap
+	}
+
+	magicLink.User_id = values.Val()["user_id"]
+	magicLink.Email = values.Val()["email"]
+
+	return magicLink, nil
+}
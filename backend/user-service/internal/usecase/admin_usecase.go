@@ -0,0 +1,408 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// AdminUsecase backs the moderation endpoints. Which endpoint a role may call is decided by the permission
+// middleware, which account it may act on is decided here: nobody acts on themselves, and only a higher role
+// can act on an account.
+type AdminUsecase struct {
+	AdminRepository *repository.AdminRepository
+	UserUsecase     *UserUsecase
+	DB              *pgxpool.Pool
+	Log             *zap.Logger
+	Config          *koanf.Koanf
+}
+
+func NewAdminUsecase(adminRepository *repository.AdminRepository, userUsecase *UserUsecase, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf) *AdminUsecase {
+	return &AdminUsecase{
+		AdminRepository: adminRepository,
+		UserUsecase:     userUsecase,
+		DB:              db,
+		Log:             zap,
+		Config:          koanf,
+	}
+}
+
+func (usecase *AdminUsecase) GetUsers(ctx context.Context, search string, role string, suspended string, cursor string, limit int, errorMap map[string]string) (model.AdminUserListResponse, map[string]string) {
+	response := model.AdminUserListResponse{}
+
+	filter := model.AdminUserFilter{
+		Search: strings.TrimSpace(search),
+		Role:   role,
+	}
+
+	if utf8.RuneCountInString(filter.Search) > 254 {
+		errorMap["q"] = "search query must be at most 254 characters"
+		return response, errorMap
+	}
+
+	if filter.Role != "" && !helper.IsValidRole(filter.Role) {
+		errorMap["role"] = "role must be one of user, moderator or admin"
+		return response, errorMap
+	}
+
+	if suspended != "" {
+		if suspended != "true" && suspended != "false" {
+			errorMap["suspended"] = "suspended must be true or false"
+			return response, errorMap
+		}
+		value := suspended == "true"
+		filter.Suspended = &value
+	}
+
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			errorMap["cursor"] = "cursor is invalid"
+			return response, errorMap
+		}
+		filter.AfterUsername = string(decoded)
+	}
+
+	users, errorMap := usecase.AdminRepository.GetUsers(ctx, filter, limit+1, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor := base64.RawURLEncoding.EncodeToString([]byte(users[limit-1].Username))
+		response.NextCursor = &nextCursor
+		response.HasMore = true
+	}
+
+	response.Users = users
+
+	return response, nil
+}
+
+func (usecase *AdminUsecase) GetUser(ctx context.Context, userUUID string, errorMap map[string]string) (model.AdminUserDetailResponse, map[string]string) {
+	user, errorMap := usecase.AdminRepository.GetUser(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return user, errorMap
+	}
+
+	user.Sessions, errorMap = usecase.UserUsecase.GetSessions(ctx, userUUID, "", map[string]string{})
+	if errorMap != nil {
+		return user, errorMap
+	}
+
+	return user, nil
+}
+
+// newAdminAuditLog records an action an admin took on someone else's account, the address and browser are the admin's.
+func newAdminAuditLog(event string, actorUUID string, targetUUID string, client model.ClientInfo, metadata map[string]string) model.AuditLog {
+	auditLog := newAuditLog(event, targetUUID, client, metadata)
+	auditLog.Actor_id = actorUUID
+
+	return auditLog
+}
+
+func checkAdminTarget(actorUUID string, actorRole string, targetUUID string, targetRole string) map[string]string {
+	if actorUUID == targetUUID {
+		return map[string]string{"user": "you can't do this to your own account"}
+	}
+
+	if !helper.OutranksRole(actorRole, targetRole) {
+		return map[string]string{"permission": "you don't have permission to act on this account"}
+	}
+
+	return nil
+}
+
+// SuspendUser flags the account and signs it out everywhere. Suspending an already suspended account replaces
+// the earlier suspension.
+func (usecase *AdminUsecase) SuspendUser(ctx context.Context, actorUUID string, actorRole string, targetUUID string, payload model.AdminSuspendRequest, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	reason := strings.TrimSpace(payload.Reason)
+	if reason == "" {
+		errorMap["reason"] = "reason is required to not be empty"
+		return errorMap
+	} else if utf8.RuneCountInString(reason) > 500 {
+		errorMap["reason"] = "reason must be at most 500 characters"
+		return errorMap
+	} else if helper.ContainsControl(reason, true) {
+		errorMap["reason"] = "reason must not contain control characters"
+		return errorMap
+	}
+
+	now := time.Now()
+
+	var suspendedUntil *time.Time
+	if payload.Duration != "" {
+		duration, err := time.ParseDuration(payload.Duration)
+		if err != nil || duration <= 0 {
+			errorMap["duration"] = "duration must be a positive duration such as 72h"
+			return errorMap
+		}
+		until := now.Add(duration)
+		suspendedUntil = &until
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to start transaction"}
+	}
+
+	targetRole, errorMap := usecase.AdminRepository.GetUserRoleWithTx(ctx, tx, targetUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = checkAdminTarget(actorUUID, actorRole, targetUUID, targetRole)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	user := model.User{
+		Id:                targetUUID,
+		Suspended_at:      &now,
+		Suspended_until:   suspendedUntil,
+		Suspension_reason: reason,
+		Suspended_by:      actorUUID,
+	}
+
+	errorMap = usecase.AdminRepository.SuspendUserWithTx(ctx, tx, user, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	errorMap = usecase.UserUsecase.revokeAllSessions(ctx, targetUUID, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	// websocket-service closes the connections the account still has open when this reaches them
+	event := model.UserEvent{
+		Type:         "account.suspended",
+		UserID:       targetUUID,
+		RecipientIDs: []string{targetUUID},
+		OccurredAt:   now,
+	}
+	if suspendedUntil != nil {
+		event.Data = map[string]string{"suspended_until": suspendedUntil.UTC().Format(time.RFC3339)}
+	}
+
+	err = usecase.UserUsecase.EventRepository.Publish(ctx, event)
+	if err != nil {
+		usecase.Log.Error("failed to publish account suspended event", zap.String("user_id", targetUUID), zap.Error(err))
+	}
+
+	metadata := map[string]string{"reason": reason}
+	if suspendedUntil != nil {
+		metadata["suspended_until"] = suspendedUntil.UTC().Format(time.RFC3339)
+	}
+	usecase.UserUsecase.audit(ctx, newAdminAuditLog("admin.user_suspended", actorUUID, targetUUID, client, metadata))
+
+	usecase.Log.Info("user suspended",
+		zap.String("event", "user_suspended"),
+		zap.String("actor_id", actorUUID),
+		zap.String("user_id", targetUUID),
+		zap.Timep("suspended_until", suspendedUntil),
+	)
+
+	return nil
+}
+
+func (usecase *AdminUsecase) UnsuspendUser(ctx context.Context, actorUUID string, actorRole string, targetUUID string, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to start transaction"}
+	}
+
+	targetRole, errorMap := usecase.AdminRepository.GetUserRoleWithTx(ctx, tx, targetUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = checkAdminTarget(actorUUID, actorRole, targetUUID, targetRole)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	lifted, errorMap := usecase.AdminRepository.UnsuspendUserWithTx(ctx, tx, targetUUID, time.Now(), map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if !lifted {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"suspension": "user is not suspended"}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.UserUsecase.audit(ctx, newAdminAuditLog("admin.user_unsuspended", actorUUID, targetUUID, client, nil))
+
+	usecase.Log.Info("user unsuspended", zap.String("event", "user_unsuspended"), zap.String("actor_id", actorUUID), zap.String("user_id", targetUUID))
+
+	return nil
+}
+
+// ForceLogout ends every session of the account, the owner can sign straight back in.
+func (usecase *AdminUsecase) ForceLogout(ctx context.Context, actorUUID string, actorRole string, targetUUID string, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	user, errorMap := usecase.AdminRepository.GetUser(ctx, targetUUID, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	errorMap = checkAdminTarget(actorUUID, actorRole, targetUUID, user.Role)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	errorMap = usecase.UserUsecase.revokeAllSessions(ctx, targetUUID, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	usecase.UserUsecase.audit(ctx, newAdminAuditLog("admin.user_logged_out", actorUUID, targetUUID, client, nil))
+
+	usecase.Log.Info("user force logged out", zap.String("event", "user_force_logout"), zap.String("actor_id", actorUUID), zap.String("user_id", targetUUID))
+
+	return nil
+}
+
+// UpdateUserRole changes the role kept in the database. Tokens already out there still carry the old role,
+// so they are revoked and the next refresh picks up the new one.
+func (usecase *AdminUsecase) UpdateUserRole(ctx context.Context, actorUUID string, actorRole string, targetUUID string, payload model.AdminRoleUpdateRequest, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	if !helper.IsValidRole(payload.Role) {
+		errorMap["role"] = "role must be one of user, moderator or admin"
+		return errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to start transaction"}
+	}
+
+	targetRole, errorMap := usecase.AdminRepository.GetUserRoleWithTx(ctx, tx, targetUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	// an admin demoting themselves could leave nobody able to undo it
+	errorMap = checkAdminTarget(actorUUID, actorRole, targetUUID, targetRole)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if targetRole == payload.Role {
+		_ = tx.Rollback(ctx)
+		return nil
+	}
+
+	errorMap = usecase.AdminRepository.UpdateUserRoleWithTx(ctx, tx, targetUUID, payload.Role, time.Now(), map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	errorMap = usecase.UserUsecase.UserRepository.RevokeUserAccessTokens(ctx, targetUUID, time.Now(), accessTokenLifetime, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	usecase.UserUsecase.audit(ctx, newAdminAuditLog("admin.role_changed", actorUUID, targetUUID, client, map[string]string{"old_role": targetRole, "role": payload.Role}))
+
+	usecase.Log.Info("user role changed",
+		zap.String("event", "user_role_changed"),
+		zap.String("actor_id", actorUUID),
+		zap.String("user_id", targetUUID),
+		zap.String("old_role", targetRole),
+		zap.String("role", payload.Role),
+	)
+
+	return nil
+}
+
+func (usecase *AdminUsecase) GetAuditLogs(ctx context.Context, userUUID string, actorUUID string, event string, cursor string, limit int, errorMap map[string]string) (model.AuditLogListResponse, map[string]string) {
+	response := model.AuditLogListResponse{Events: []model.AuditLogResponse{}}
+
+	if len(event) > 50 {
+		errorMap["event"] = "event must be at most 50 characters"
+		return response, errorMap
+	}
+
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	beforeID, ok := decodeAuditCursor(cursor)
+	if !ok {
+		errorMap["cursor"] = "cursor is invalid"
+		return response, errorMap
+	}
+
+	filter := model.AuditLogFilter{
+		UserID:   userUUID,
+		ActorID:  actorUUID,
+		Event:    event,
+		BeforeID: beforeID,
+	}
+
+	auditLogs, errorMap := usecase.UserUsecase.AuditRepository.GetAuditLogs(ctx, filter, limit+1, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	if len(auditLogs) > limit {
+		auditLogs = auditLogs[:limit]
+		response.NextCursor = encodeAuditCursor(auditLogs[limit-1].Id)
+		response.HasMore = true
+	}
+
+	for _, auditLog := range auditLogs {
+		response.Events = append(response.Events, model.AuditLogResponse{
+			Id:        auditLog.Id,
+			Event:     auditLog.Event,
+			UserId:    auditLog.User_id,
+			ActorId:   auditLog.Actor_id,
+			IpAddress: auditLog.Ip_address,
+			UserAgent: auditLog.User_agent,
+			Metadata:  auditLog.Metadata,
+			CreatedAt: auditLog.Created_at,
+		})
+	}
+
+	return response, nil
+}
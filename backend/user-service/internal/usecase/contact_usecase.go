@@ -0,0 +1,341 @@
+package usecase
+
+import (
+	"context"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ContactUsecase struct {
+	ContactRepository *repository.ContactRepository
+	EventRepository   *repository.EventRepository
+	DB                *pgxpool.Pool
+	Log               *zap.Logger
+	Config            *koanf.Koanf
+}
+
+func NewContactUsecase(contactRepository *repository.ContactRepository, eventRepository *repository.EventRepository, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf) *ContactUsecase {
+	return &ContactUsecase{
+		ContactRepository: contactRepository,
+		EventRepository:   eventRepository,
+		DB:                db,
+		Log:               zap,
+		Config:            koanf,
+	}
+}
+
+// SendContactRequest asks another user to become a contact. If they already asked the caller, their request
+// is accepted instead of opening a second one.
+func (usecase *ContactUsecase) SendContactRequest(ctx context.Context, userUUID string, payload model.ContactRequestCreateRequest, errorMap map[string]string) (model.ContactRequestSendResponse, map[string]string) {
+	response := model.ContactRequestSendResponse{}
+
+	_, err := uuid.Parse(payload.UserID)
+	if err != nil {
+		errorMap["user_id"] = "user id is not valid"
+		return response, errorMap
+	}
+
+	if payload.UserID == userUUID {
+		errorMap["user_id"] = "can't send a contact request to yourself"
+		return response, errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return response, errorMap
+	}
+
+	errorMap = usecase.ContactRepository.CheckUserExistsWithTx(ctx, tx, payload.UserID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	isContact, errorMap := usecase.ContactRepository.IsContactWithTx(ctx, tx, userUUID, payload.UserID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	if isContact {
+		_ = tx.Rollback(ctx)
+		return response, map[string]string{"user_id": "user is already a contact"}
+	}
+
+	blocked, errorMap := usecase.ContactRepository.IsBlockedWithTx(ctx, tx, userUUID, payload.UserID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	if blocked {
+		_ = tx.Rollback(ctx)
+		return response, map[string]string{"user_id": "can't send a contact request to this user"}
+	}
+
+	pending, found, errorMap := usecase.ContactRepository.GetPendingRequestBetweenWithTx(ctx, tx, userUUID, payload.UserID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	now := time.Now()
+
+	if found && pending.Requester_id == userUUID {
+		_ = tx.Rollback(ctx)
+		return response, map[string]string{"user_id": "contact request is already pending"}
+	}
+
+	if found {
+		errorMap = usecase.acceptWithTx(ctx, tx, pending, now)
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return response, errorMap
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return response, map[string]string{"internal": "failed to commit transaction"}
+		}
+
+		usecase.publishContactAccepted(ctx, pending, now)
+
+		response = model.ContactRequestSendResponse{
+			Id:     pending.Id,
+			Status: "Accepted",
+		}
+
+		return response, nil
+	}
+
+	request := model.ContactRequest{
+		Id:           uuid.New().String(),
+		Requester_id: userUUID,
+		Addressee_id: payload.UserID,
+		Status:       "Pending",
+		Created_at:   now,
+	}
+
+	errorMap = usecase.ContactRepository.AddContactRequestWithTx(ctx, tx, request, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return response, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	response = model.ContactRequestSendResponse{
+		Id:     request.Id,
+		Status: request.Status,
+	}
+
+	return response, nil
+}
+
+func (usecase *ContactUsecase) acceptWithTx(ctx context.Context, tx pgx.Tx, request model.ContactRequest, now time.Time) map[string]string {
+	errorMap := usecase.ContactRepository.UpdateContactRequestStatusWithTx(ctx, tx, request.Id, "Accepted", now, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	errorMap = usecase.ContactRepository.AddContactWithTx(ctx, tx, request.Requester_id, request.Addressee_id, now, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	return nil
+}
+
+// publishContactAccepted runs after commit, the contact exists either way so a failed publish is only logged.
+func (usecase *ContactUsecase) publishContactAccepted(ctx context.Context, request model.ContactRequest, now time.Time) {
+	err := usecase.EventRepository.Publish(ctx, model.UserEvent{
+		Type:         "contact.accepted",
+		UserID:       request.Addressee_id,
+		RecipientIDs: []string{request.Requester_id, request.Addressee_id},
+		Data: map[string]string{
+			"request_id":   request.Id,
+			"requester_id": request.Requester_id,
+			"addressee_id": request.Addressee_id,
+		},
+		OccurredAt: now,
+	})
+	if err != nil {
+		usecase.Log.Warn("failed to publish contact accepted event", zap.String("request_id", request.Id), zap.Error(err))
+	}
+}
+
+func (usecase *ContactUsecase) AcceptContactRequest(ctx context.Context, userUUID string, requestID string, errorMap map[string]string) map[string]string {
+	request, now, errorMap := usecase.closeContactRequest(ctx, userUUID, requestID, "Accepted", errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	usecase.publishContactAccepted(ctx, request, now)
+
+	return nil
+}
+
+func (usecase *ContactUsecase) DeclineContactRequest(ctx context.Context, userUUID string, requestID string, errorMap map[string]string) map[string]string {
+	_, _, errorMap = usecase.closeContactRequest(ctx, userUUID, requestID, "Declined", errorMap)
+	return errorMap
+}
+
+func (usecase *ContactUsecase) CancelContactRequest(ctx context.Context, userUUID string, requestID string, errorMap map[string]string) map[string]string {
+	_, _, errorMap = usecase.closeContactRequest(ctx, userUUID, requestID, "Canceled", errorMap)
+	return errorMap
+}
+
+// closeContactRequest moves a pending request to its final status. Only the addressee may accept or decline
+// and only the requester may cancel; anyone else gets the same not found as a request that doesn't exist.
+func (usecase *ContactUsecase) closeContactRequest(ctx context.Context, userUUID string, requestID string, status string, errorMap map[string]string) (model.ContactRequest, time.Time, map[string]string) {
+	now := time.Now()
+
+	_, err := uuid.Parse(requestID)
+	if err != nil {
+		errorMap["contact_request"] = "contact request not found"
+		return model.ContactRequest{}, now, errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return model.ContactRequest{}, now, errorMap
+	}
+
+	request, errorMap := usecase.ContactRepository.GetContactRequestWithTx(ctx, tx, requestID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return request, now, errorMap
+	}
+
+	allowed := request.Addressee_id == userUUID
+	if status == "Canceled" {
+		allowed = request.Requester_id == userUUID
+	}
+
+	if !allowed {
+		_ = tx.Rollback(ctx)
+		return request, now, map[string]string{"contact_request": "contact request not found"}
+	}
+
+	if request.Status != "Pending" {
+		_ = tx.Rollback(ctx)
+		return request, now, map[string]string{"contact_request": "contact request is no longer pending"}
+	}
+
+	if status == "Accepted" {
+		errorMap = usecase.acceptWithTx(ctx, tx, request, now)
+	} else {
+		errorMap = usecase.ContactRepository.UpdateContactRequestStatusWithTx(ctx, tx, request.Id, status, now, map[string]string{})
+	}
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return request, now, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return request, now, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return request, now, nil
+}
+
+func (usecase *ContactUsecase) GetContactRequests(ctx context.Context, userUUID string, errorMap map[string]string) (model.ContactRequestListResponse, map[string]string) {
+	return usecase.ContactRepository.GetPendingContactRequests(ctx, userUUID, errorMap)
+}
+
+func (usecase *ContactUsecase) GetContacts(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.ContactResponse, map[string]string) {
+	return usecase.ContactRepository.GetContacts(ctx, userUUID, errorMap)
+}
+
+func (usecase *ContactUsecase) RemoveContact(ctx context.Context, userUUID string, contactUUID string, errorMap map[string]string) map[string]string {
+	_, err := uuid.Parse(contactUUID)
+	if err != nil {
+		errorMap["contact"] = "contact not found"
+		return errorMap
+	}
+
+	return usecase.ContactRepository.DeleteContact(ctx, userUUID, contactUUID, errorMap)
+}
+
+// BlockUser also drops the contact and any open request between the two, so nothing is left to accept later.
+// Blocking someone who is already blocked is not an error.
+func (usecase *ContactUsecase) BlockUser(ctx context.Context, userUUID string, blockedUUID string, errorMap map[string]string) map[string]string {
+	_, err := uuid.Parse(blockedUUID)
+	if err != nil {
+		errorMap["user_id"] = "user not found"
+		return errorMap
+	}
+
+	if blockedUUID == userUUID {
+		errorMap["user_id"] = "can't block yourself"
+		return errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return errorMap
+	}
+
+	errorMap = usecase.ContactRepository.CheckUserExistsWithTx(ctx, tx, blockedUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	now := time.Now()
+
+	errorMap = usecase.ContactRepository.AddBlockWithTx(ctx, tx, userUUID, blockedUUID, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.ContactRepository.DeleteContactWithTx(ctx, tx, userUUID, blockedUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.ContactRepository.CancelPendingRequestBetweenWithTx(ctx, tx, userUUID, blockedUUID, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return nil
+}
+
+func (usecase *ContactUsecase) UnblockUser(ctx context.Context, userUUID string, blockedUUID string, errorMap map[string]string) map[string]string {
+	_, err := uuid.Parse(blockedUUID)
+	if err != nil {
+		errorMap["block"] = "block not found"
+		return errorMap
+	}
+
+	return usecase.ContactRepository.DeleteBlock(ctx, userUUID, blockedUUID, errorMap)
+}
+
+func (usecase *ContactUsecase) GetBlockedUsers(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.BlockedUserResponse, map[string]string) {
+	return usecase.ContactRepository.GetBlockedUsers(ctx, userUUID, errorMap)
+}
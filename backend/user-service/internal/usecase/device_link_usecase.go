@@ -0,0 +1,269 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// no 0/O or 1/I, the code is read off one screen and typed or scanned into another
+const deviceLinkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const deviceLinkCodeLength = 8
+
+const deviceLinkPollInterval = time.Second
+
+// DeviceLinkUsecase signs a new browser in from a device that is already signed in. The new browser opens a
+// link request and shows its code as a QR code, the signed in device approves it, and the new browser's next
+// poll receives a session of its own from UserUsecase.
+type DeviceLinkUsecase struct {
+	DeviceLinkRepository *repository.DeviceLinkRepository
+	UserUsecase          *UserUsecase
+	DB                   *pgxpool.Pool
+	Log                  *zap.Logger
+	Config               *koanf.Koanf
+}
+
+func NewDeviceLinkUsecase(deviceLinkRepository *repository.DeviceLinkRepository, userUsecase *UserUsecase, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf) *DeviceLinkUsecase {
+	return &DeviceLinkUsecase{
+		DeviceLinkRepository: deviceLinkRepository,
+		UserUsecase:          userUsecase,
+		DB:                   db,
+		Log:                  zap,
+		Config:               koanf,
+	}
+}
+
+func generateDeviceLinkCode() (string, error) {
+	code := make([]byte, deviceLinkCodeLength)
+	for i := range code {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(deviceLinkCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = deviceLinkCodeAlphabet[index.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// normalizeDeviceLinkCode lets the code be typed in lower case or with the dash it is displayed with.
+func normalizeDeviceLinkCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func (usecase *DeviceLinkUsecase) CreateDeviceLink(ctx context.Context, payload model.DeviceLinkCreateRequest, client model.ClientInfo, errorMap map[string]string) (model.DeviceLinkCreateResponse, map[string]string) {
+	response := model.DeviceLinkCreateResponse{}
+
+	if len(payload.DeviceLabel) > 100 {
+		errorMap["device_label"] = "device label must be at most 100 characters"
+		return response, errorMap
+	}
+
+	pollToken, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		errorMap["internal"] = "failed to generate poll token"
+		return response, errorMap
+	}
+
+	ttl := helper.ConfigDuration(usecase.Config, "DEVICE_LINK_TTL", 5*time.Minute)
+	now := time.Now()
+
+	link := model.DeviceLink{
+		Status:       "Pending",
+		Device_label: payload.DeviceLabel,
+		User_agent:   truncateUserAgent(client.User_agent),
+		Ip_address:   client.Ip_address,
+		Created_at:   now,
+		Expires_at:   now.Add(ttl),
+	}
+
+	for attempt := 0; attempt < 5 && response.Code == ""; attempt++ {
+		link.Code, err = generateDeviceLinkCode()
+		if err != nil {
+			return response, map[string]string{"internal": "failed to generate device link code"}
+		}
+
+		created, errorMap := usecase.DeviceLinkRepository.AddDeviceLink(ctx, helper.GenerateSHA256Hash(pollToken), link, ttl, map[string]string{})
+		if errorMap != nil {
+			return response, errorMap
+		}
+
+		if created {
+			response.Code = link.Code
+		}
+	}
+
+	if response.Code == "" {
+		return response, map[string]string{"internal": "failed to find a free device link code"}
+	}
+
+	linkURL := helper.ConfigString(usecase.Config, "DEVICE_LINK_URL", "http://localhost:4200/link-device")
+
+	response = model.DeviceLinkCreateResponse{
+		PollToken: pollToken,
+		Code:      link.Code,
+		QrPayload: linkURL + "?code=" + url.QueryEscape(link.Code),
+		ExpiresIn: int(ttl.Seconds()),
+		Interval:  int(deviceLinkPollInterval.Seconds()),
+	}
+
+	return response, nil
+}
+
+// GetDeviceLink shows the signed in user what they are about to approve, so a code someone else sent them
+// can be recognised as not being their own browser.
+func (usecase *DeviceLinkUsecase) GetDeviceLink(ctx context.Context, code string, errorMap map[string]string) (model.DeviceLinkResponse, map[string]string) {
+	_, link, errorMap := usecase.DeviceLinkRepository.GetDeviceLinkByCode(ctx, normalizeDeviceLinkCode(code), errorMap)
+	if errorMap != nil {
+		return model.DeviceLinkResponse{}, errorMap
+	}
+
+	if link.Status != "Pending" {
+		return model.DeviceLinkResponse{}, map[string]string{"device_link": "device link not found"}
+	}
+
+	response := model.DeviceLinkResponse{
+		Code:        link.Code,
+		DeviceLabel: link.Device_label,
+		UserAgent:   link.User_agent,
+		IpAddress:   link.Ip_address,
+		CreatedAt:   link.Created_at,
+		ExpiresAt:   link.Expires_at,
+	}
+
+	return response, nil
+}
+
+func (usecase *DeviceLinkUsecase) ApproveDeviceLink(ctx context.Context, userUUID string, code string, errorMap map[string]string) map[string]string {
+	return usecase.answerDeviceLink(ctx, userUUID, code, "Approved", errorMap)
+}
+
+func (usecase *DeviceLinkUsecase) DeclineDeviceLink(ctx context.Context, userUUID string, code string, errorMap map[string]string) map[string]string {
+	return usecase.answerDeviceLink(ctx, userUUID, code, "Declined", errorMap)
+}
+
+func (usecase *DeviceLinkUsecase) answerDeviceLink(ctx context.Context, userUUID string, code string, status string, errorMap map[string]string) map[string]string {
+	hashedPollToken, link, errorMap := usecase.DeviceLinkRepository.GetDeviceLinkByCode(ctx, normalizeDeviceLinkCode(code), errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	answered, errorMap := usecase.DeviceLinkRepository.AnswerDeviceLink(ctx, hashedPollToken, status, userUUID, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if !answered {
+		return map[string]string{"device_link": "device link was already answered"}
+	}
+
+	usecase.Log.Info("device link answered",
+		zap.String("event", "device_link_answered"),
+		zap.String("user_id", userUUID),
+		zap.String("status", status),
+		zap.String("device_ip_address", link.Ip_address),
+	)
+
+	return nil
+}
+
+// PollDeviceLink reports where the link stands, and with wait set it holds the request open until the link is
+// answered or DEVICE_LINK_POLL_WAIT runs out. Once approved, the first poll to claim it gets the session.
+func (usecase *DeviceLinkUsecase) PollDeviceLink(ctx context.Context, payload model.DeviceLinkPollRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, string, map[string]string) {
+	token := model.Token{}
+
+	if payload.PollToken == "" {
+		errorMap["poll_token"] = "poll token is required to not be empty"
+		return token, "", errorMap
+	}
+
+	hashedPollToken := helper.GenerateSHA256Hash(payload.PollToken)
+
+	deadline := time.Now()
+	if payload.Wait {
+		deadline = deadline.Add(helper.ConfigDuration(usecase.Config, "DEVICE_LINK_POLL_WAIT", 25*time.Second))
+	}
+
+	for {
+		link, errorMap := usecase.DeviceLinkRepository.GetDeviceLink(ctx, hashedPollToken, map[string]string{})
+		if errorMap != nil {
+			return token, "", errorMap
+		}
+
+		switch link.Status {
+		case "Approved":
+			return usecase.claimDeviceLink(ctx, hashedPollToken, link, client)
+		case "Declined":
+			errorMap = usecase.DeviceLinkRepository.DeleteDeviceLink(ctx, hashedPollToken, link.Code, map[string]string{})
+			if errorMap != nil {
+				usecase.Log.Warn("failed to delete device link", zap.String("code", link.Code))
+			}
+			return token, "", map[string]string{"device_link": "device link was declined"}
+		case "Claimed":
+			return token, "", map[string]string{"device_link": "device link is invalid or expired"}
+		}
+
+		if !time.Now().Add(deviceLinkPollInterval).Before(deadline) {
+			return token, "pending", nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return token, "pending", nil
+		case <-time.After(deviceLinkPollInterval):
+		}
+	}
+}
+
+func (usecase *DeviceLinkUsecase) claimDeviceLink(ctx context.Context, hashedPollToken string, link model.DeviceLink, client model.ClientInfo) (model.Token, string, map[string]string) {
+	token := model.Token{}
+
+	claimed, errorMap := usecase.DeviceLinkRepository.ClaimDeviceLink(ctx, hashedPollToken, map[string]string{})
+	if errorMap != nil {
+		return token, "", errorMap
+	}
+
+	if !claimed {
+		return token, "", map[string]string{"device_link": "device link is invalid or expired"}
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return token, "", map[string]string{"internal": "failed to start transaction"}
+	}
+
+	// the new browser gets its own session, signing it out later leaves the approving device signed in
+	session := newSession(link.User_id, link.Device_label, client)
+
+	token, errorMap = usecase.UserUsecase.generateToken(ctx, tx, session, time.Now(), map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, "", errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, "", map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	errorMap = usecase.DeviceLinkRepository.DeleteDeviceLink(ctx, hashedPollToken, link.Code, map[string]string{})
+	if errorMap != nil {
+		usecase.Log.Warn("failed to delete device link", zap.String("user_id", link.User_id))
+	}
+
+	usecase.Log.Info("device linked", zap.String("event", "device_linked"), zap.String("user_id", link.User_id), zap.String("session_id", session.Id))
+	usecase.UserUsecase.audit(ctx, newAuditLog("login.succeeded", link.User_id, client, map[string]string{"method": "device_link", "session_id": session.Id}))
+
+	return token, "approved", nil
+}
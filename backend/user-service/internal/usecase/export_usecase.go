@@ -0,0 +1,309 @@
+package usecase
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportBuildTimeout bounds one archive build, anything still Pending after twice that is considered abandoned.
+const exportBuildTimeout = 15 * time.Minute
+
+type ExportUsecase struct {
+	ExportRepository *repository.ExportRepository
+	DB               *pgxpool.Pool
+	Log              *zap.Logger
+	Config           *koanf.Koanf
+}
+
+func NewExportUsecase(exportRepository *repository.ExportRepository, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf) *ExportUsecase {
+	return &ExportUsecase{
+		ExportRepository: exportRepository,
+		DB:               db,
+		Log:              zap,
+		Config:           koanf,
+	}
+}
+
+func newDataExportResponse(export model.DataExport) model.DataExportResponse {
+	response := model.DataExportResponse{
+		Id:          export.Id,
+		Status:      export.Status,
+		CreatedAt:   export.Created_at,
+		CompletedAt: export.Completed_at,
+		ExpiresAt:   export.Expires_at,
+	}
+
+	if export.Status == "Ready" {
+		response.DownloadURL = "/api/account/export/" + export.Id + "/download"
+	}
+
+	return response
+}
+
+// RequestDataExport queues an export and builds it in the background, the caller polls GetDataExport until it's Ready.
+func (usecase *ExportUsecase) RequestDataExport(ctx context.Context, userUUID string, errorMap map[string]string) (model.DataExportResponse, map[string]string) {
+	export := model.DataExport{
+		Id:         uuid.New().String(),
+		User_id:    userUUID,
+		Status:     "Pending",
+		Created_at: time.Now(),
+	}
+
+	errorMap = usecase.ExportRepository.AddDataExport(ctx, export, errorMap)
+	if errorMap != nil {
+		return model.DataExportResponse{}, errorMap
+	}
+
+	go func() {
+		buildCtx, cancel := context.WithTimeout(context.Background(), exportBuildTimeout)
+		defer cancel()
+
+		usecase.buildDataExport(buildCtx, export)
+	}()
+
+	return newDataExportResponse(export), nil
+}
+
+func (usecase *ExportUsecase) GetDataExport(ctx context.Context, userUUID string, exportID string, errorMap map[string]string) (model.DataExportResponse, map[string]string) {
+	_, err := uuid.Parse(exportID)
+	if err != nil {
+		errorMap["export"] = "export not found"
+		return model.DataExportResponse{}, errorMap
+	}
+
+	export, errorMap := usecase.ExportRepository.GetDataExport(ctx, exportID, userUUID, errorMap)
+	if errorMap != nil {
+		return model.DataExportResponse{}, errorMap
+	}
+
+	return newDataExportResponse(export), nil
+}
+
+// GetDataExportFile returns the archive path of a ready export that hasn't expired yet.
+func (usecase *ExportUsecase) GetDataExportFile(ctx context.Context, userUUID string, exportID string, errorMap map[string]string) (string, map[string]string) {
+	_, err := uuid.Parse(exportID)
+	if err != nil {
+		errorMap["export"] = "export not found"
+		return "", errorMap
+	}
+
+	export, errorMap := usecase.ExportRepository.GetDataExport(ctx, exportID, userUUID, errorMap)
+	if errorMap != nil {
+		return "", errorMap
+	}
+
+	if export.Status == "Expired" || (export.Expires_at != nil && !time.Now().Before(*export.Expires_at)) {
+		return "", map[string]string{"export": "export has expired"}
+	}
+
+	if export.Status != "Ready" {
+		return "", map[string]string{"export": "export is not ready"}
+	}
+
+	return export.File_path, nil
+}
+
+func (usecase *ExportUsecase) buildDataExport(ctx context.Context, export model.DataExport) {
+	exportDir := helper.ConfigString(usecase.Config, "DATA_EXPORT_DIR", "exports")
+	filePath := filepath.Join(exportDir, export.Id+".zip")
+
+	err := usecase.writeDataExport(ctx, export.User_id, filePath)
+	if err != nil {
+		usecase.Log.Error("failed to build data export", zap.String("export_id", export.Id), zap.String("user_id", export.User_id), zap.Error(err))
+		_ = os.Remove(filePath)
+
+		errorMap := usecase.ExportRepository.FailDataExport(context.Background(), export.Id, time.Now(), map[string]string{})
+		if errorMap != nil {
+			usecase.Log.Error("failed to mark data export as failed", zap.String("export_id", export.Id))
+		}
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(helper.ConfigDuration(usecase.Config, "DATA_EXPORT_TTL", 168*time.Hour))
+
+	// an account purged while its export was being built takes the finished archive with it
+	errorMap := usecase.ExportRepository.CompleteDataExport(ctx, export.Id, filePath, now, expiresAt, map[string]string{})
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			usecase.Log.Error("failed to mark data export as ready", zap.String("export_id", export.Id))
+		}
+		_ = os.Remove(filePath)
+		return
+	}
+
+	usecase.Log.Info("data export ready", zap.String("event", "data_export_ready"), zap.String("export_id", export.Id), zap.String("user_id", export.User_id))
+}
+
+// writeDataExport writes profile.json, sessions.json, conversations.json and messages.json into a zip archive.
+// The archive is built under a temporary name and renamed at the end so a half written file is never served.
+func (usecase *ExportUsecase) writeDataExport(ctx context.Context, userUUID string, filePath string) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0o700)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	profile, errorMap := usecase.ExportRepository.GetProfileExport(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return fmt.Errorf("failed to read export data: %v", errorMap)
+	}
+
+	err = writeJSONFile(archive, "profile.json", profile)
+	if err != nil {
+		return err
+	}
+
+	sessions, errorMap := usecase.ExportRepository.GetSessionsExport(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return fmt.Errorf("failed to read export data: %v", errorMap)
+	}
+
+	err = writeJSONFile(archive, "sessions.json", sessions)
+	if err != nil {
+		return err
+	}
+
+	conversations, errorMap := usecase.ExportRepository.GetConversationsExport(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return fmt.Errorf("failed to read export data: %v", errorMap)
+	}
+
+	err = writeJSONFile(archive, "conversations.json", conversations)
+	if err != nil {
+		return err
+	}
+
+	err = usecase.writeMessages(ctx, archive, userUUID)
+	if err != nil {
+		return err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
+
+// writeMessages streams messages.json as a JSON array, one message at a time.
+func (usecase *ExportUsecase) writeMessages(ctx context.Context, archive *zip.Writer, userUUID string) error {
+	writer, err := archive.Create("messages.json")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(writer, "[")
+	if err != nil {
+		return err
+	}
+
+	separator := "\n"
+	err = usecase.ExportRepository.ForEachMessageExport(ctx, userUUID, func(message model.ExportMessage) error {
+		data, err := sonic.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(writer, separator)
+		if err != nil {
+			return err
+		}
+		separator = ",\n"
+
+		_, err = writer.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(writer, "\n]\n")
+	return err
+}
+
+func writeJSONFile(archive *zip.Writer, name string, value interface{}) error {
+	data, err := sonic.ConfigStd.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(data)
+	return err
+}
+
+// RunExportCleanup deletes expired archives and gives up on abandoned builds every DATA_EXPORT_CLEANUP_INTERVAL
+// until ctx is done.
+func (usecase *ExportUsecase) RunExportCleanup(ctx context.Context) {
+	ticker := time.NewTicker(helper.ConfigDuration(usecase.Config, "DATA_EXPORT_CLEANUP_INTERVAL", time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			errorMap := usecase.CleanupDataExports(ctx)
+			if errorMap != nil {
+				usecase.Log.Error("failed to clean up data exports", zap.Any("errors", errorMap))
+			}
+		}
+	}
+}
+
+func (usecase *ExportUsecase) CleanupDataExports(ctx context.Context) map[string]string {
+	now := time.Now()
+
+	errorMap := usecase.ExportRepository.FailStaleDataExports(ctx, now.Add(-2*exportBuildTimeout), now, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	filePaths, errorMap := usecase.ExportRepository.ExpireDataExports(ctx, now, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	for _, filePath := range filePaths {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			usecase.Log.Warn("failed to remove expired data export", zap.String("file_path", filePath), zap.Error(err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,495 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PasskeyUsecase runs the WebAuthn registration and authentication ceremonies. A passkey sign in ends in the
+// same session and tokens as a password login, so the token issuance is left to UserUsecase.
+type PasskeyUsecase struct {
+	PasskeyRepository *repository.PasskeyRepository
+	UserUsecase       *UserUsecase
+	DB                *pgxpool.Pool
+	Log               *zap.Logger
+	Config            *koanf.Koanf
+}
+
+func NewPasskeyUsecase(passkeyRepository *repository.PasskeyRepository, userUsecase *UserUsecase, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf) *PasskeyUsecase {
+	return &PasskeyUsecase{
+		PasskeyRepository: passkeyRepository,
+		UserUsecase:       userUsecase,
+		DB:                db,
+		Log:               zap,
+		Config:            koanf,
+	}
+}
+
+func (usecase *PasskeyUsecase) rpID() string {
+	return helper.ConfigString(usecase.Config, "WEBAUTHN_RP_ID", "localhost")
+}
+
+func (usecase *PasskeyUsecase) origins() []string {
+	return strings.Split(helper.ConfigString(usecase.Config, "WEBAUTHN_ORIGINS", "http://localhost:4200"), ",")
+}
+
+func (usecase *PasskeyUsecase) challengeTTL() time.Duration {
+	return helper.ConfigDuration(usecase.Config, "WEBAUTHN_CHALLENGE_TTL", 5*time.Minute)
+}
+
+func (usecase *PasskeyUsecase) newChallenge(ctx context.Context, userUUID string, ceremony string) (string, map[string]string) {
+	challenge, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		return "", map[string]string{"internal": "failed to generate challenge"}
+	}
+
+	errorMap := usecase.PasskeyRepository.AddWebAuthnChallenge(ctx, helper.GenerateSHA256Hash(challenge), model.WebAuthnChallenge{
+		User_id:  userUUID,
+		Ceremony: ceremony,
+	}, usecase.challengeTTL(), map[string]string{})
+	if errorMap != nil {
+		return "", errorMap
+	}
+
+	return challenge, nil
+}
+
+func newCredentialDescriptors(passkeys []model.Passkey) []model.PublicKeyCredentialDescriptor {
+	descriptors := []model.PublicKeyCredentialDescriptor{}
+	for _, passkey := range passkeys {
+		descriptor := model.PublicKeyCredentialDescriptor{
+			Type: "public-key",
+			Id:   base64.RawURLEncoding.EncodeToString(passkey.Credential_id),
+		}
+		if passkey.Transports != "" {
+			descriptor.Transports = strings.Split(passkey.Transports, ",")
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	return descriptors
+}
+
+// GetRegistrationOptions returns the options for navigator.credentials.create. Passkeys the account already has
+// are excluded so the same authenticator isn't registered twice.
+func (usecase *PasskeyUsecase) GetRegistrationOptions(ctx context.Context, userUUID string, errorMap map[string]string) (model.PasskeyCreationOptionsResponse, map[string]string) {
+	response := model.PasskeyCreationOptionsResponse{}
+
+	user, errorMap := usecase.UserUsecase.UserRepository.GetUserByID(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	passkeys, errorMap := usecase.PasskeyRepository.GetPasskeys(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	challenge, errorMap := usecase.newChallenge(ctx, userUUID, "registration")
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	response = model.PasskeyCreationOptionsResponse{
+		Challenge: challenge,
+		Rp: model.PublicKeyCredentialRpEntity{
+			Id:   usecase.rpID(),
+			Name: helper.ConfigString(usecase.Config, "WEBAUTHN_RP_NAME", "MyChat"),
+		},
+		User: model.PublicKeyCredentialUserEntity{
+			Id:          base64.RawURLEncoding.EncodeToString([]byte(user.Id)),
+			Name:        user.Username,
+			DisplayName: user.Username,
+		},
+		PubKeyCredParams: []model.PublicKeyCredentialParameters{
+			{Type: "public-key", Alg: helper.COSEAlgES256},
+			{Type: "public-key", Alg: helper.COSEAlgEdDSA},
+			{Type: "public-key", Alg: helper.COSEAlgRS256},
+		},
+		Timeout:            int(usecase.challengeTTL().Milliseconds()),
+		ExcludeCredentials: newCredentialDescriptors(passkeys),
+		AuthenticatorSelection: model.AuthenticatorSelectionCriteria{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+
+	return response, nil
+}
+
+// RegisterPasskey verifies the attestation made for a challenge from GetRegistrationOptions and stores the
+// credential. The authenticator has to have verified the user, a passkey replaces both the password and the
+// second factor when signing in.
+func (usecase *PasskeyUsecase) RegisterPasskey(ctx context.Context, userUUID string, payload model.PasskeyRegisterRequest, errorMap map[string]string) (model.PasskeyResponse, map[string]string) {
+	response := model.PasskeyResponse{}
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	if utf8.RuneCountInString(name) > 100 {
+		errorMap["name"] = "name must be at most 100 characters"
+		return response, errorMap
+	} else if helper.ContainsControl(name, false) {
+		errorMap["name"] = "name must not contain control characters"
+		return response, errorMap
+	}
+
+	authData, errorMap := usecase.verifyRegistration(ctx, userUUID, payload.Credential, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	passkey := model.Passkey{
+		Id:            uuid.New().String(),
+		User_id:       userUUID,
+		Credential_id: authData.CredentialID,
+		Public_key:    authData.PublicKey,
+		Sign_count:    int64(authData.SignCount),
+		Transports:    strings.Join(payload.Credential.Response.Transports, ","),
+		Name:          name,
+		Created_at:    time.Now(),
+	}
+
+	if len(passkey.Transports) > 255 {
+		passkey.Transports = ""
+	}
+
+	errorMap = usecase.PasskeyRepository.AddPasskey(ctx, passkey, map[string]string{})
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	usecase.Log.Info("passkey registered", zap.String("event", "passkey_registered"), zap.String("user_id", userUUID), zap.String("passkey_id", passkey.Id))
+
+	response = model.PasskeyResponse{
+		Id:        passkey.Id,
+		Name:      passkey.Name,
+		CreatedAt: passkey.Created_at,
+	}
+
+	return response, nil
+}
+
+// verifyRegistration runs every check of a registration short of storing the credential. The challenge is spent
+// here, so a failed attempt has to start over with new options.
+func (usecase *PasskeyUsecase) verifyRegistration(ctx context.Context, userUUID string, credential model.PublicKeyCredential, errorMap map[string]string) (helper.WebAuthnAuthenticatorData, map[string]string) {
+	authData := helper.WebAuthnAuthenticatorData{}
+
+	clientDataJSON, err := helper.DecodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		errorMap["credential"] = "client data is not valid base64url"
+		return authData, errorMap
+	}
+
+	attestationObject, err := helper.DecodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		errorMap["credential"] = "attestation object is not valid base64url"
+		return authData, errorMap
+	}
+
+	clientData, err := helper.ParseWebAuthnClientData(clientDataJSON, "webauthn.create", usecase.origins())
+	if err != nil {
+		errorMap["credential"] = err.Error()
+		return authData, errorMap
+	}
+
+	challenge, errorMap := usecase.PasskeyRepository.TakeWebAuthnChallenge(ctx, helper.GenerateSHA256Hash(clientData.Challenge), errorMap)
+	if errorMap != nil {
+		return authData, errorMap
+	}
+
+	if challenge.Ceremony != "registration" || challenge.User_id != userUUID {
+		return authData, map[string]string{"challenge": "challenge is invalid or expired"}
+	}
+
+	authData, err = helper.ParseWebAuthnAttestationObject(attestationObject)
+	if err != nil {
+		return authData, map[string]string{"credential": err.Error()}
+	}
+
+	err = authData.CheckRPID(usecase.rpID())
+	if err != nil {
+		return authData, map[string]string{"credential": err.Error()}
+	}
+
+	if !authData.UserPresent() || !authData.UserVerified() {
+		return authData, map[string]string{"credential": "authenticator did not verify the user"}
+	}
+
+	_, _, err = helper.ParseCOSEKey(authData.PublicKey)
+	if err != nil {
+		return authData, map[string]string{"credential": err.Error()}
+	}
+
+	rawID, err := helper.DecodeBase64URL(credential.RawId)
+	if err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+		return authData, map[string]string{"credential": "credential id does not match the attested credential"}
+	}
+
+	return authData, nil
+}
+
+func (usecase *PasskeyUsecase) GetPasskeys(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.PasskeyResponse, map[string]string) {
+	passkeys, errorMap := usecase.PasskeyRepository.GetPasskeys(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return nil, errorMap
+	}
+
+	response := []model.PasskeyResponse{}
+	for _, passkey := range passkeys {
+		response = append(response, model.PasskeyResponse{
+			Id:         passkey.Id,
+			Name:       passkey.Name,
+			CreatedAt:  passkey.Created_at,
+			LastUsedAt: passkey.Last_used_at,
+		})
+	}
+
+	return response, nil
+}
+
+func (usecase *PasskeyUsecase) DeletePasskey(ctx context.Context, userUUID string, passkeyID string, errorMap map[string]string) map[string]string {
+	_, err := uuid.Parse(passkeyID)
+	if err != nil {
+		errorMap["passkey"] = "passkey not found"
+		return errorMap
+	}
+
+	return usecase.PasskeyRepository.DeletePasskey(ctx, userUUID, passkeyID, errorMap)
+}
+
+// GetLoginOptions returns the options for navigator.credentials.get. Without a username the browser offers
+// whichever discoverable passkeys it holds for us.
+func (usecase *PasskeyUsecase) GetLoginOptions(ctx context.Context, payload model.PasskeyLoginOptionsRequest, errorMap map[string]string) (model.PasskeyRequestOptionsResponse, map[string]string) {
+	response := model.PasskeyRequestOptionsResponse{}
+
+	userUUID := ""
+	allowCredentials := []model.PublicKeyCredentialDescriptor{}
+
+	if payload.Username != "" {
+		user, errorMap := usecase.UserUsecase.UserRepository.GetUserByUsername(ctx, helper.CanonicalUsername(payload.Username), map[string]string{})
+		if errorMap != nil && errorMap["user"] == "" {
+			return response, errorMap
+		}
+
+		if errorMap == nil {
+			passkeys, errorMap := usecase.PasskeyRepository.GetPasskeys(ctx, user.Id, map[string]string{})
+			if errorMap != nil {
+				return response, errorMap
+			}
+
+			userUUID = user.Id
+			allowCredentials = newCredentialDescriptors(passkeys)
+		}
+	}
+
+	challenge, errorMap := usecase.newChallenge(ctx, userUUID, "login")
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	response = model.PasskeyRequestOptionsResponse{
+		Challenge:        challenge,
+		RpId:             usecase.rpID(),
+		Timeout:          int(usecase.challengeTTL().Milliseconds()),
+		AllowCredentials: allowCredentials,
+		UserVerification: "required",
+	}
+
+	return response, nil
+}
+
+// Login verifies an assertion for a challenge from GetLoginOptions and opens a session. A sign count that
+// doesn't move forward means two authenticators hold the same key, so the assertion is refused.
+func (usecase *PasskeyUsecase) Login(ctx context.Context, payload model.PasskeyLoginRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, map[string]string) {
+	token := model.Token{}
+
+	if len(payload.DeviceLabel) > 100 {
+		errorMap["device_label"] = "device label must be at most 100 characters"
+		return token, errorMap
+	}
+
+	assertion, errorMap := usecase.readAssertion(ctx, payload.Credential, errorMap)
+	if errorMap != nil {
+		return token, errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return token, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	passkey, errorMap := usecase.PasskeyRepository.GetPasskeyByCredentialIDWithTx(ctx, tx, assertion.CredentialID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	reason, errorMap := verifyPasskeyAssertion(assertion, passkey)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+
+		if reason != "" {
+			usecase.Log.Warn("passkey assertion rejected",
+				zap.String("event", "passkey_assertion_rejected"),
+				zap.String("reason", reason),
+				zap.String("user_id", passkey.User_id),
+				zap.String("passkey_id", passkey.Id),
+				zap.Int64("stored_sign_count", passkey.Sign_count),
+				zap.Uint32("sign_count", assertion.AuthData.SignCount),
+				zap.String("ip_address", client.Ip_address),
+			)
+			usecase.UserUsecase.audit(ctx, newAuditLog("login.failed", passkey.User_id, client, map[string]string{"method": "passkey", "reason": reason, "passkey_id": passkey.Id}))
+		}
+
+		return token, errorMap
+	}
+
+	signCount := int64(assertion.AuthData.SignCount)
+	now := time.Now()
+
+	errorMap = usecase.PasskeyRepository.UpdatePasskeyUsageWithTx(ctx, tx, passkey.Id, signCount, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	errorMap = usecase.UserUsecase.cancelAccountDeletion(ctx, tx, passkey.User_id)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	session := newSession(passkey.User_id, payload.DeviceLabel, client)
+
+	token, errorMap = usecase.UserUsecase.generateToken(ctx, tx, session, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.UserUsecase.audit(ctx, newAuditLog("login.succeeded", passkey.User_id, client, map[string]string{"method": "passkey", "session_id": session.Id}))
+
+	return token, nil
+}
+
+// passkeyAssertion is a decoded sign in response whose challenge has been spent and whose authenticator data
+// checked out. What is left to check needs the stored passkey.
+type passkeyAssertion struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+	AuthData          helper.WebAuthnAuthenticatorData
+	Challenge         model.WebAuthnChallenge
+}
+
+// readAssertion decodes a sign in response and runs the checks that don't need the passkey, spending the challenge.
+func (usecase *PasskeyUsecase) readAssertion(ctx context.Context, credential model.PublicKeyCredential, errorMap map[string]string) (passkeyAssertion, map[string]string) {
+	assertion := passkeyAssertion{}
+	var err error
+
+	assertion.CredentialID, err = helper.DecodeBase64URL(credential.RawId)
+	if err != nil || len(assertion.CredentialID) == 0 {
+		errorMap["credential"] = "credential id is not valid base64url"
+		return assertion, errorMap
+	}
+
+	assertion.ClientDataJSON, err = helper.DecodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		errorMap["credential"] = "client data is not valid base64url"
+		return assertion, errorMap
+	}
+
+	assertion.AuthenticatorData, err = helper.DecodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		errorMap["credential"] = "authenticator data is not valid base64url"
+		return assertion, errorMap
+	}
+
+	assertion.Signature, err = helper.DecodeBase64URL(credential.Response.Signature)
+	if err != nil {
+		errorMap["credential"] = "signature is not valid base64url"
+		return assertion, errorMap
+	}
+
+	assertion.UserHandle, err = helper.DecodeBase64URL(credential.Response.UserHandle)
+	if err != nil {
+		errorMap["credential"] = "user handle is not valid base64url"
+		return assertion, errorMap
+	}
+
+	clientData, err := helper.ParseWebAuthnClientData(assertion.ClientDataJSON, "webauthn.get", usecase.origins())
+	if err != nil {
+		errorMap["credential"] = err.Error()
+		return assertion, errorMap
+	}
+
+	assertion.Challenge, errorMap = usecase.PasskeyRepository.TakeWebAuthnChallenge(ctx, helper.GenerateSHA256Hash(clientData.Challenge), errorMap)
+	if errorMap != nil {
+		return assertion, errorMap
+	}
+
+	if assertion.Challenge.Ceremony != "login" {
+		return assertion, map[string]string{"challenge": "challenge is invalid or expired"}
+	}
+
+	assertion.AuthData, err = helper.ParseWebAuthnAuthenticatorData(assertion.AuthenticatorData)
+	if err != nil {
+		return assertion, map[string]string{"credential": err.Error()}
+	}
+
+	err = assertion.AuthData.CheckRPID(usecase.rpID())
+	if err != nil {
+		return assertion, map[string]string{"credential": err.Error()}
+	}
+
+	if !assertion.AuthData.UserPresent() || !assertion.AuthData.UserVerified() {
+		return assertion, map[string]string{"credential": "authenticator did not verify the user"}
+	}
+
+	return assertion, nil
+}
+
+// verifyPasskeyAssertion checks the assertion against the passkey it names. The reason is what the audit log
+// records for a refused assertion, it is empty when there is nothing worth recording. A sign count that doesn't
+// move forward means two authenticators hold the same key.
+func verifyPasskeyAssertion(assertion passkeyAssertion, passkey model.Passkey) (string, map[string]string) {
+	// a challenge asked for a given username only signs in to that account
+	if (assertion.Challenge.User_id != "" && assertion.Challenge.User_id != passkey.User_id) || (len(assertion.UserHandle) > 0 && string(assertion.UserHandle) != passkey.User_id) {
+		return "", map[string]string{"credential": "passkey is not recognized"}
+	}
+
+	err := helper.VerifyWebAuthnAssertion(passkey.Public_key, assertion.AuthenticatorData, assertion.ClientDataJSON, assertion.Signature)
+	if err != nil {
+		return "invalid_signature", map[string]string{"credential": "passkey signature is not valid"}
+	}
+
+	signCount := int64(assertion.AuthData.SignCount)
+	if (signCount != 0 || passkey.Sign_count != 0) && signCount <= passkey.Sign_count {
+		return "clone_suspected", map[string]string{"credential": "passkey may have been cloned, sign in another way"}
+	}
+
+	return "", nil
+}
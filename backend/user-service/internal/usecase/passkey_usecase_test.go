@@ -0,0 +1,331 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/webauthntest"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"testing"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:4200"
+)
+
+var testPasskeyAlgorithms = map[string]int64{
+	"ES256": webauthntest.AlgES256,
+	"EdDSA": webauthntest.AlgEdDSA,
+}
+
+// the ceremonies are run up to the point where the passkey would be stored or looked up, which is all postgres
+func newTestPasskeyUsecase(t *testing.T) *PasskeyUsecase {
+	t.Helper()
+
+	log := zap.NewNop()
+	config := newTestConfig(t, map[string]interface{}{
+		"WEBAUTHN_RP_ID":   testRPID,
+		"WEBAUTHN_ORIGINS": "https://chat.example," + testOrigin,
+	})
+
+	return NewPasskeyUsecase(repository.NewPasskeyRepository(log, nil, newTestRedis(t)), nil, nil, log, config)
+}
+
+func newTestAuthenticator(t *testing.T, algorithm int64) *webauthntest.Authenticator {
+	t.Helper()
+
+	authenticator, err := webauthntest.New(algorithm, testRPID, testOrigin)
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	return authenticator
+}
+
+func registerTestPasskey(t *testing.T, usecase *PasskeyUsecase, authenticator *webauthntest.Authenticator, userUUID string) model.Passkey {
+	t.Helper()
+	ctx := context.Background()
+
+	challenge, errorMap := usecase.newChallenge(ctx, userUUID, "registration")
+	if errorMap != nil {
+		t.Fatalf("failed to create challenge: %v", errorMap)
+	}
+
+	authData, errorMap := usecase.verifyRegistration(ctx, userUUID, authenticator.Create(challenge), map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("registration rejected: %v", errorMap)
+	}
+
+	return model.Passkey{
+		Id:            uuid.New().String(),
+		User_id:       userUUID,
+		Credential_id: authData.CredentialID,
+		Public_key:    authData.PublicKey,
+		Sign_count:    int64(authData.SignCount),
+	}
+}
+
+func newTestLoginChallenge(t *testing.T, usecase *PasskeyUsecase) string {
+	t.Helper()
+
+	options, errorMap := usecase.GetLoginOptions(context.Background(), model.PasskeyLoginOptionsRequest{}, map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("failed to get login options: %v", errorMap)
+	}
+
+	return options.Challenge
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	for name, algorithm := range testPasskeyAlgorithms {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			usecase := newTestPasskeyUsecase(t)
+			authenticator := newTestAuthenticator(t, algorithm)
+			userUUID := uuid.New().String()
+
+			passkey := registerTestPasskey(t, usecase, authenticator, userUUID)
+			if !bytes.Equal(passkey.Credential_id, authenticator.CredentialID) {
+				t.Fatalf("credential id = %x, expected %x", passkey.Credential_id, authenticator.CredentialID)
+			}
+
+			for i := 0; i < 2; i++ {
+				assertion, errorMap := usecase.readAssertion(ctx, authenticator.Get(newTestLoginChallenge(t, usecase), userUUID), map[string]string{})
+				if errorMap != nil {
+					t.Fatalf("assertion rejected: %v", errorMap)
+				}
+
+				if !bytes.Equal(assertion.CredentialID, passkey.Credential_id) {
+					t.Fatalf("credential id = %x, expected %x", assertion.CredentialID, passkey.Credential_id)
+				}
+
+				reason, errorMap := verifyPasskeyAssertion(assertion, passkey)
+				if errorMap != nil {
+					t.Fatalf("assertion rejected (%s): %v", reason, errorMap)
+				}
+
+				passkey.Sign_count = int64(assertion.AuthData.SignCount)
+			}
+		})
+	}
+}
+
+func TestPasskeyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		ceremony string
+		userUUID string
+		bend     func(authenticator *webauthntest.Authenticator)
+		errorKey string
+	}{
+		{name: "wrong origin", bend: func(authenticator *webauthntest.Authenticator) { authenticator.Origin = "https://evil.example" }, errorKey: "credential"},
+		{name: "wrong rp id hash", bend: func(authenticator *webauthntest.Authenticator) { authenticator.RPID = "evil.example" }, errorKey: "credential"},
+		{name: "missing uv flag", bend: func(authenticator *webauthntest.Authenticator) { authenticator.Flags = webauthntest.FlagUserPresent }, errorKey: "credential"},
+		{name: "missing up flag", bend: func(authenticator *webauthntest.Authenticator) { authenticator.Flags = webauthntest.FlagUserVerified }, errorKey: "credential"},
+		{name: "login challenge", ceremony: "login", errorKey: "challenge"},
+		{name: "challenge of another account", userUUID: uuid.New().String(), errorKey: "challenge"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			usecase := newTestPasskeyUsecase(t)
+			authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+			userUUID := uuid.New().String()
+
+			ceremony := "registration"
+			if test.ceremony != "" {
+				ceremony = test.ceremony
+			}
+
+			challengeUserUUID := userUUID
+			if test.userUUID != "" {
+				challengeUserUUID = test.userUUID
+			}
+
+			challenge, errorMap := usecase.newChallenge(ctx, challengeUserUUID, ceremony)
+			if errorMap != nil {
+				t.Fatalf("failed to create challenge: %v", errorMap)
+			}
+
+			if test.bend != nil {
+				test.bend(authenticator)
+			}
+
+			_, errorMap = usecase.verifyRegistration(ctx, userUUID, authenticator.Create(challenge), map[string]string{})
+			if errorMap == nil {
+				t.Fatal("registration was accepted")
+			}
+			if errorMap[test.errorKey] == "" {
+				t.Fatalf("expected a %q error, got %v", test.errorKey, errorMap)
+			}
+		})
+	}
+}
+
+func TestPasskeyRegistrationRejectsReusedChallenge(t *testing.T) {
+	ctx := context.Background()
+	usecase := newTestPasskeyUsecase(t)
+	authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+	userUUID := uuid.New().String()
+
+	challenge, errorMap := usecase.newChallenge(ctx, userUUID, "registration")
+	if errorMap != nil {
+		t.Fatalf("failed to create challenge: %v", errorMap)
+	}
+
+	credential := authenticator.Create(challenge)
+
+	_, errorMap = usecase.verifyRegistration(ctx, userUUID, credential, map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("registration rejected: %v", errorMap)
+	}
+
+	_, errorMap = usecase.verifyRegistration(ctx, userUUID, credential, map[string]string{})
+	if errorMap["challenge"] == "" {
+		t.Fatalf("replayed registration: expected a challenge error, got %v", errorMap)
+	}
+}
+
+func TestPasskeyLoginRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		bend     func(authenticator *webauthntest.Authenticator)
+		errorKey string
+	}{
+		{name: "wrong origin", bend: func(authenticator *webauthntest.Authenticator) { authenticator.Origin = "https://evil.example" }, errorKey: "credential"},
+		{name: "wrong rp id hash", bend: func(authenticator *webauthntest.Authenticator) { authenticator.RPID = "evil.example" }, errorKey: "credential"},
+		{name: "missing uv flag", bend: func(authenticator *webauthntest.Authenticator) { authenticator.Flags = webauthntest.FlagUserPresent }, errorKey: "credential"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usecase := newTestPasskeyUsecase(t)
+			authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+			userUUID := uuid.New().String()
+			registerTestPasskey(t, usecase, authenticator, userUUID)
+
+			challenge := newTestLoginChallenge(t, usecase)
+			test.bend(authenticator)
+
+			_, errorMap := usecase.readAssertion(context.Background(), authenticator.Get(challenge, userUUID), map[string]string{})
+			if errorMap == nil {
+				t.Fatal("assertion was accepted")
+			}
+			if errorMap[test.errorKey] == "" {
+				t.Fatalf("expected a %q error, got %v", test.errorKey, errorMap)
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginRejectsReusedChallenge(t *testing.T) {
+	ctx := context.Background()
+	usecase := newTestPasskeyUsecase(t)
+	authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+	userUUID := uuid.New().String()
+	registerTestPasskey(t, usecase, authenticator, userUUID)
+
+	credential := authenticator.Get(newTestLoginChallenge(t, usecase), userUUID)
+
+	_, errorMap := usecase.readAssertion(ctx, credential, map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("assertion rejected: %v", errorMap)
+	}
+
+	_, errorMap = usecase.readAssertion(ctx, credential, map[string]string{})
+	if errorMap["challenge"] == "" {
+		t.Fatalf("replayed assertion: expected a challenge error, got %v", errorMap)
+	}
+
+	// a registration challenge isn't good for signing in either
+	challenge, errorMap := usecase.newChallenge(ctx, userUUID, "registration")
+	if errorMap != nil {
+		t.Fatalf("failed to create challenge: %v", errorMap)
+	}
+
+	_, errorMap = usecase.readAssertion(ctx, authenticator.Get(challenge, userUUID), map[string]string{})
+	if errorMap["challenge"] == "" {
+		t.Fatalf("registration challenge: expected a challenge error, got %v", errorMap)
+	}
+}
+
+func TestVerifyPasskeyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		bend   func(authenticator *webauthntest.Authenticator, passkey *model.Passkey, credential *model.PublicKeyCredential)
+		reason string
+	}{
+		{
+			name: "sign count that doesn't increase",
+			bend: func(authenticator *webauthntest.Authenticator, passkey *model.Passkey, credential *model.PublicKeyCredential) {
+				passkey.Sign_count = int64(authenticator.SignCount)
+			},
+			reason: "clone_suspected",
+		},
+		{
+			name: "sign count that goes back",
+			bend: func(authenticator *webauthntest.Authenticator, passkey *model.Passkey, credential *model.PublicKeyCredential) {
+				passkey.Sign_count = int64(authenticator.SignCount) + 10
+			},
+			reason: "clone_suspected",
+		},
+		{
+			name: "tampered signature",
+			bend: func(authenticator *webauthntest.Authenticator, passkey *model.Passkey, credential *model.PublicKeyCredential) {
+				signature, _ := helper.DecodeBase64URL(credential.Response.Signature)
+				signature[len(signature)-1] ^= 0x01
+				credential.Response.Signature = encodeTestBase64URL(signature)
+			},
+			reason: "invalid_signature",
+		},
+		{
+			name: "signature from another key",
+			bend: func(authenticator *webauthntest.Authenticator, passkey *model.Passkey, credential *model.PublicKeyCredential) {
+				passkey.Public_key = newTestAuthenticator(t, webauthntest.AlgES256).COSEKey()
+			},
+			reason: "invalid_signature",
+		},
+		{
+			name: "passkey of another account",
+			bend: func(authenticator *webauthntest.Authenticator, passkey *model.Passkey, credential *model.PublicKeyCredential) {
+				passkey.User_id = uuid.New().String()
+			},
+			reason: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usecase := newTestPasskeyUsecase(t)
+			authenticator := newTestAuthenticator(t, webauthntest.AlgES256)
+			userUUID := uuid.New().String()
+			passkey := registerTestPasskey(t, usecase, authenticator, userUUID)
+
+			credential := authenticator.Get(newTestLoginChallenge(t, usecase), userUUID)
+			test.bend(authenticator, &passkey, &credential)
+
+			assertion, errorMap := usecase.readAssertion(context.Background(), credential, map[string]string{})
+			if errorMap != nil {
+				t.Fatalf("assertion rejected before the passkey check: %v", errorMap)
+			}
+
+			reason, errorMap := verifyPasskeyAssertion(assertion, passkey)
+			if errorMap == nil {
+				t.Fatal("assertion was accepted")
+			}
+			if reason != test.reason {
+				t.Fatalf("reason = %q, expected %q", reason, test.reason)
+			}
+		})
+	}
+}
+
+func encodeTestBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,37 @@
+package usecase
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/knadh/koanf/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+)
+
+// newTestRedis starts an in-memory redis for the test. It answers CLUSTER SLOTS with itself, so the cluster
+// client the repositories take works against it unchanged.
+func newTestRedis(t *testing.T) *redis.ClusterClient {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return client
+}
+
+func newTestConfig(t *testing.T, values map[string]interface{}) *koanf.Koanf {
+	t.Helper()
+
+	config := koanf.New(".")
+	for key, value := range values {
+		err := config.Set(key, value)
+		if err != nil {
+			t.Fatalf("failed to set %s: %v", key, err)
+		}
+	}
+
+	return config
+}
@@ -2,8 +2,12 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/mailer"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
 	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
 	"github.com/golang-jwt/jwt/v5"
@@ -12,37 +16,71 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/knadh/koanf/v2"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+const accessTokenLifetime = 24 * time.Hour
+
 type UserUsecase struct {
-	UserRepository *repository.UserRepository
-	DB             *pgxpool.Pool
-	Log            *zap.Logger
-	Config         *koanf.Koanf
+	UserRepository  *repository.UserRepository
+	EventRepository *repository.EventRepository
+	AuditRepository *repository.AuditRepository
+	DB              *pgxpool.Pool
+	Log             *zap.Logger
+	Config          *koanf.Koanf
+	KeySet          *helper.KeySet
+	Mailer          mailer.Mailer
+	OidcProvider    *helper.OidcProvider
+
+	dummyPasswordHash string
+}
+
+func NewUserUsecase(userRepository *repository.UserRepository, eventRepository *repository.EventRepository, auditRepository *repository.AuditRepository, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf, keySet *helper.KeySet, mailer mailer.Mailer, oidcProvider *helper.OidcProvider) *UserUsecase {
+	usecase := &UserUsecase{
+		UserRepository:  userRepository,
+		EventRepository: eventRepository,
+		AuditRepository: auditRepository,
+		DB:              db,
+		Log:             zap,
+		Config:          koanf,
+		KeySet:          keySet,
+		Mailer:          mailer,
+		OidcProvider:    oidcProvider,
+	}
+
+	// compared against when the username doesn't exist, so both failures cost one hash round
+	dummyPasswordHash, err := helper.HashPassword("mychat-dummy-password", usecase.passwordParams())
+	if err != nil {
+		zap.Fatal("failed to generate dummy password hash: " + err.Error())
+	}
+	usecase.dummyPasswordHash = dummyPasswordHash
+
+	return usecase
 }
 
-func NewUserUsecase(userRepository *repository.UserRepository, db *pgxpool.Pool, zap *zap.Logger, koanf *koanf.Koanf) *UserUsecase {
-	return &UserUsecase{
-		UserRepository: userRepository,
-		DB:             db,
-		Log:            zap,
-		Config:         koanf,
+// passwordParams reads the argon2id cost; raising it makes every older hash get rehashed on its next login.
+func (usecase *UserUsecase) passwordParams() helper.PasswordParams {
+	return helper.PasswordParams{
+		Memory:      uint32(helper.ConfigInt(usecase.Config, "ARGON2_MEMORY_KIB", 64*1024)),
+		Iterations:  uint32(helper.ConfigInt(usecase.Config, "ARGON2_ITERATIONS", 3)),
+		Parallelism: uint8(helper.ConfigInt(usecase.Config, "ARGON2_PARALLELISM", 2)),
+		SaltLength:  16,
+		KeyLength:   32,
 	}
 }
 
-func (usecase *UserUsecase) Register(ctx context.Context, payload model.UserRegisterRequest, errorMap map[string]string) (model.Token, map[string]string) {
+func (usecase *UserUsecase) Register(ctx context.Context, payload model.UserRegisterRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, map[string]string) {
 	token := model.Token{}
 
-	if payload.Username == "" {
-		errorMap["username"] = "username is required to not be empty"
-		return token, errorMap
-	} else if len(payload.Username) < 4 {
-		errorMap["username"] = "username must be at least 4 characters"
-		return token, errorMap
-	} else if len(payload.Username) > 22 {
-		errorMap["username"] = "username must be at most 22 characters"
+	username, usernameCanonical, usernameSkeleton, errorMap := usecase.normalizeUsername(payload.Username, errorMap)
+	if errorMap != nil {
 		return token, errorMap
 	}
 
@@ -52,75 +90,153 @@ func (usecase *UserUsecase) Register(ctx context.Context, payload model.UserRegi
 	} else if len(payload.Password) < 5 {
 		errorMap["password"] = "password must be at least 5 characters"
 		return token, errorMap
-	} else if len(payload.Password) > 20 {
-		errorMap["password"] = "password must be at most 20 characters"
+	} else if len(payload.Password) > 128 {
+		errorMap["password"] = "password must be at most 128 characters"
 		return token, errorMap
 	}
 
-	// start transaction
-	tx, err := usecase.DB.Begin(ctx)
-	if err != nil {
-		errorMap["internal"] = "failed to start transaction"
+	if len(payload.DeviceLabel) > 100 {
+		errorMap["device_label"] = "device label must be at most 100 characters"
 		return token, errorMap
 	}
 
-	err = usecase.UserRepository.CheckUsernameUniqueWithTx(ctx, tx, payload.Username, errorMap)
-	if err != nil {
-		_ = tx.Rollback(ctx)
+	email := ""
+	if payload.Email != "" {
+		normalizedEmail, ok := helper.NormalizeEmail(payload.Email)
+		if !ok {
+			errorMap["email"] = "email is not a valid address"
+			return token, errorMap
+		}
+		email = normalizedEmail
+	} else if helper.ConfigBool(usecase.Config, "EMAIL_REQUIRED", false) {
+		errorMap["email"] = "email is required to not be empty"
 		return token, errorMap
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+	hashedPassword, err := helper.HashPassword(payload.Password, usecase.passwordParams())
 	if err != nil {
 		errorMap["internal"] = "error generating password hash"
 		return token, errorMap
 	}
 
-	now := time.Now()
-	user := model.User{
-		Id:         uuid.New().String(),
-		Username:   payload.Username,
-		Password:   string(hashedPassword),
-		Created_at: now,
-		Updated_at: now,
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return token, errorMap
 	}
 
-	errorMap = usecase.UserRepository.RegisterWithTx(ctx, tx, user, errorMap)
+	now := time.Now()
+
+	errorMap = usecase.UserRepository.CheckUsernameUniqueWithTx(ctx, tx, usernameCanonical, usernameSkeleton, "", now, map[string]string{})
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return token, errorMap
 	}
 
-	token, errorMap = usecase.generateToken(ctx, tx, user.Id, now, errorMap)
+	if email != "" {
+		errorMap = usecase.UserRepository.CheckEmailUniqueWithTx(ctx, tx, email, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return token, errorMap
+		}
+	}
+
+	user := model.User{
+		Id:                 uuid.New().String(),
+		Username:           username,
+		Username_canonical: usernameCanonical,
+		Username_skeleton:  usernameSkeleton,
+		Password:           hashedPassword,
+		Email:              email,
+		Created_at:         now,
+		Updated_at:         now,
+	}
+
+	errorMap = usecase.UserRepository.RegisterWithTx(ctx, tx, user, map[string]string{})
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return token, errorMap
 	}
 
+	// an address that still needs verifying keeps the account from signing in, so no session is opened
+	if user.Email == "" || helper.ConfigBool(usecase.Config, "EMAIL_UNVERIFIED_LOGIN_ALLOWED", true) {
+		session := newSession(user.Id, payload.DeviceLabel, client)
+
+		token, errorMap = usecase.generateToken(ctx, tx, session, now, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return token, errorMap
+		}
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
-		fmt.Println(err)
+		return token, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.audit(ctx, newAuditLog("register", user.Id, client, nil))
+
+	if email != "" {
+		errorMap = usecase.sendEmailVerification(ctx, user)
+		if errorMap != nil {
+			usecase.Log.Error("failed to create email verification token", zap.String("user_id", user.Id))
+		}
 	}
 
 	return token, nil
 }
 
-func (usecase *UserUsecase) generateToken(ctx context.Context, tx pgx.Tx, userID string, now time.Time, errorMap map[string]string) (model.Token, map[string]string) {
+func newSession(userID string, deviceLabel string, client model.ClientInfo) model.Session {
+	if deviceLabel == "" {
+		deviceLabel = "Unknown device"
+	}
+
+	return model.Session{
+		Id:           uuid.New().String(),
+		User_id:      userID,
+		Device_label: deviceLabel,
+		User_agent:   truncateUserAgent(client.User_agent),
+		Ip_address:   client.Ip_address,
+	}
+}
+
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > 255 {
+		return userAgent[:255]
+	}
+
+	return userAgent
+}
+
+// generateToken signs a new access/refresh pair for the given session and stores the refresh token hash.
+// The session id doubles as the refresh token family id.
+func (usecase *UserUsecase) generateToken(ctx context.Context, tx pgx.Tx, session model.Session, now time.Time, errorMap map[string]string) (model.Token, map[string]string) {
 	token := model.Token{}
 
-	secretKeyAccess := usecase.Config.String("SECRET_KEY_ACCESS_TOKEN")
-	secretKeyAccessByte := []byte(secretKeyAccess)
+	// the role is read fresh on every issue and refresh, a role change reaches the claims with the next token.
+	// Every sign in path and every refresh ends up here, so this is also where a suspended account is stopped.
+	user, errorMap := usecase.UserRepository.GetUserAccessWithTx(ctx, tx, session.User_id, errorMap)
+	if errorMap != nil {
+		return token, errorMap
+	}
+
+	if helper.IsSuspended(user.Suspended_at, user.Suspended_until, now) {
+		return token, helper.NewAccountSuspendedError(user.Suspended_until)
+	}
 
-	accessExpirationTime := now.Add(24 * time.Hour)
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  userID,
+	accessExpirationTime := now.Add(accessTokenLifetime)
+	accessTokenString, err := usecase.KeySet.Sign(jwt.MapClaims{
+		"id":   session.User_id,
+		"sid":  session.Id,
+		"role": user.Role,
+		"jti":  uuid.New().String(),
+		// millisecond precision keeps a token issued right after a revocation from falling inside its second
+		"iat": float64(now.UnixMilli()) / 1000,
 		"exp": accessExpirationTime.Unix(),
 	})
-
-	accessTokenString, err := accessToken.SignedString(secretKeyAccessByte)
 	if err != nil {
-		errorMap["internal"] = "failed to sign access token"
-		return token, errorMap
+		return token, map[string]string{"internal": "failed to sign access token"}
 	}
 
 	secretKeyRefresh := usecase.Config.String("SECRET_KEY_REFRESH_TOKEN")
@@ -128,31 +244,31 @@ func (usecase *UserUsecase) generateToken(ctx context.Context, tx pgx.Tx, userID
 
 	refreshExpirationTime := now.Add(30 * 24 * time.Hour)
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  userID,
+		"id":  session.User_id,
+		"jti": uuid.New().String(),
 		"exp": refreshExpirationTime.Unix(),
 	})
 
 	refreshTokenString, err := refreshToken.SignedString(secretKeyRefreshByte)
 	if err != nil {
-		errorMap["internal"] = "failed to sign access token"
-		return token, errorMap
+		return token, map[string]string{"internal": "failed to sign access token"}
 	}
 
 	hashedRefreshToken := helper.GenerateSHA256Hash(refreshTokenString)
 
 	refreshTokenToDB := model.RefreshToken{
-		User_id:              userID,
+		User_id:              session.User_id,
+		Family_id:            session.Id,
 		Hashed_refresh_token: hashedRefreshToken,
+		Device_label:         session.Device_label,
+		User_agent:           session.User_agent,
+		Ip_address:           session.Ip_address,
 		Created_at:           now,
+		Last_used_at:         now,
 		Expired_at:           refreshExpirationTime,
 	}
 
-	errorMap = usecase.UserRepository.UpdateRefreshTokenWithTx(ctx, tx, "Revoke", userID, errorMap)
-	if errorMap != nil {
-		return token, errorMap
-	}
-
-	errorMap = usecase.UserRepository.AddRefreshTokenWithTx(ctx, tx, refreshTokenToDB, errorMap)
+	errorMap = usecase.UserRepository.AddRefreshTokenWithTx(ctx, tx, refreshTokenToDB, map[string]string{})
 	if errorMap != nil {
 		return token, errorMap
 	}
@@ -167,28 +283,262 @@ func (usecase *UserUsecase) generateToken(ctx context.Context, tx pgx.Tx, userID
 	return token, nil
 }
 
-func (usecase *UserUsecase) Login(ctx context.Context, payload model.UserRegisterRequest, errorMap map[string]string) (model.Token, map[string]string) {
+func newLoginLockedError(lockout time.Duration) map[string]string {
+	return map[string]string{
+		"login":       "too many failed login attempts, try again later",
+		"retry_after": strconv.Itoa(int(math.Ceil(lockout.Seconds()))),
+	}
+}
+
+// lockoutDuration doubles the base lockout for every failure past the limit, up to the configured ceiling.
+func (usecase *UserUsecase) lockoutDuration(failures int64, limit int64) time.Duration {
+	base := helper.ConfigDuration(usecase.Config, "LOGIN_LOCKOUT_BASE", time.Minute)
+	ceiling := helper.ConfigDuration(usecase.Config, "LOGIN_LOCKOUT_MAX", time.Hour)
+
+	duration := base
+	for i := limit; i < failures && duration < ceiling; i++ {
+		duration *= 2
+	}
+
+	return min(duration, ceiling)
+}
+
+// addLoginFailure counts the failure against both the username and the ip and locks whichever went over its limit.
+func (usecase *UserUsecase) addLoginFailure(ctx context.Context, userUUID string, username string, client model.ClientInfo) map[string]string {
+	window := helper.ConfigDuration(usecase.Config, "LOGIN_FAILURE_WINDOW", time.Hour)
+
+	subjects := []struct {
+		scope   string
+		subject string
+		limit   int64
+	}{
+		{"user", username, int64(helper.ConfigInt(usecase.Config, "LOGIN_MAX_ATTEMPTS", 5))},
+		{"ip", client.Ip_address, int64(helper.ConfigInt(usecase.Config, "LOGIN_IP_MAX_ATTEMPTS", 50))},
+	}
+
+	for _, subject := range subjects {
+		if subject.subject == "" {
+			continue
+		}
+
+		failures, errorMap := usecase.UserRepository.AddLoginFailure(ctx, subject.scope, subject.subject, window, map[string]string{})
+		if errorMap != nil {
+			return errorMap
+		}
+
+		if failures < subject.limit {
+			continue
+		}
+
+		lockout := usecase.lockoutDuration(failures, subject.limit)
+
+		errorMap = usecase.UserRepository.LockLogin(ctx, subject.scope, subject.subject, lockout, map[string]string{})
+		if errorMap != nil {
+			return errorMap
+		}
+
+		usecase.Log.Warn("login lockout triggered",
+			zap.String("event", "login_lockout"),
+			zap.String("scope", subject.scope),
+			zap.String("subject", subject.subject),
+			zap.String("ip_address", client.Ip_address),
+			zap.Int64("failures", failures),
+			zap.Duration("lockout", lockout),
+		)
+
+		// an ip lockout isn't about the account that happened to be tried last
+		lockedUserUUID := ""
+		if subject.scope == "user" {
+			lockedUserUUID = userUUID
+		}
+		usecase.audit(ctx, newAuditLog("login.locked", lockedUserUUID, client, map[string]string{
+			"scope":    subject.scope,
+			"subject":  subject.subject,
+			"failures": strconv.FormatInt(failures, 10),
+			"lockout":  lockout.String(),
+		}))
+	}
+
+	return nil
+}
+
+// Login returns a challenge instead of tokens when the account has two-factor authentication enabled;
+// the tokens are issued by CompleteMfaLogin once the second factor checks out.
+func (usecase *UserUsecase) Login(ctx context.Context, payload model.UserLoginRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, *model.MfaChallengeResponse, map[string]string) {
 	token := model.Token{}
 
-	if payload.Username == "" {
+	// any spelling of the name signs in, "Alice" and "alice" are the same account
+	usernameCanonical := helper.CanonicalUsername(payload.Username)
+	if usernameCanonical == "" {
 		errorMap["username"] = "username is required to not be empty"
-		return token, errorMap
-	} else if len(payload.Username) < 4 {
-		errorMap["username"] = "username must be at least 4 characters"
-		return token, errorMap
-	} else if len(payload.Username) > 22 {
+		return token, nil, errorMap
+	} else if utf8.RuneCountInString(usernameCanonical) > 22 {
 		errorMap["username"] = "username must be at most 22 characters"
-		return token, errorMap
+		return token, nil, errorMap
 	}
 
 	if payload.Password == "" {
 		errorMap["password"] = "password is required to not be empty"
-		return token, errorMap
+		return token, nil, errorMap
 	} else if len(payload.Password) < 5 {
 		errorMap["password"] = "password must be at least 5 characters"
+		return token, nil, errorMap
+	} else if len(payload.Password) > 128 {
+		errorMap["password"] = "password must be at most 128 characters"
+		return token, nil, errorMap
+	}
+
+	if len(payload.DeviceLabel) > 100 {
+		errorMap["device_label"] = "device label must be at most 100 characters"
+		return token, nil, errorMap
+	}
+
+	// locked out attempts are turned away before they cost a database lookup or a hash round
+	lockout, errorMap := usecase.UserRepository.GetLoginLockout(ctx, usernameCanonical, client.Ip_address, errorMap)
+	if errorMap != nil {
+		return token, nil, errorMap
+	}
+
+	if lockout > 0 {
+		usecase.audit(ctx, newAuditLog("login.failed", "", client, map[string]string{"method": "password", "reason": "locked", "username": usernameCanonical}))
+		return token, nil, newLoginLockedError(lockout)
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return token, nil, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	user, errorMap := usecase.UserRepository.LoginWithTx(ctx, tx, usernameCanonical, map[string]string{})
+	if errorMap != nil && errorMap["user"] == "" {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	// an unknown username gets the same answer, and roughly the same timing, as a wrong password
+	passwordHash := user.Password
+	if errorMap != nil {
+		passwordHash = usecase.dummyPasswordHash
+	}
+
+	params := usecase.passwordParams()
+
+	match, needsRehash, err := helper.VerifyPassword(payload.Password, passwordHash, params)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		usecase.Log.Error("failed to verify password", zap.String("user_id", user.Id), zap.Error(err))
+		return token, nil, map[string]string{"internal": "failed to verify password"}
+	}
+
+	if !match || errorMap != nil {
+		_ = tx.Rollback(ctx)
+
+		reason := "wrong_password"
+		if errorMap != nil {
+			reason = "unknown_user"
+		}
+		usecase.audit(ctx, newAuditLog("login.failed", user.Id, client, map[string]string{"method": "password", "reason": reason, "username": usernameCanonical}))
+
+		errorMap = usecase.addLoginFailure(ctx, user.Id, usernameCanonical, client)
+		if errorMap != nil {
+			return token, nil, errorMap
+		}
+
+		return token, nil, map[string]string{"password": "wrong username or password"}
+	}
+
+	// only told after the password checked out, so the suspension of an account isn't given away to strangers
+	if helper.IsSuspended(user.Suspended_at, user.Suspended_until, time.Now()) {
+		_ = tx.Rollback(ctx)
+		usecase.audit(ctx, newAuditLog("login.failed", user.Id, client, map[string]string{"method": "password", "reason": "suspended"}))
+		return token, nil, helper.NewAccountSuspendedError(user.Suspended_until)
+	}
+
+	// accounts without an address, including those from before emails existed, can only add one after signing in
+	if user.Email != "" && user.Email_verified_at == nil && !helper.ConfigBool(usecase.Config, "EMAIL_UNVERIFIED_LOGIN_ALLOWED", true) {
+		_ = tx.Rollback(ctx)
+		return token, nil, map[string]string{"email": "email address is not verified"}
+	}
+
+	// the plaintext is only around now, so this is when legacy bcrypt and outdated argon2id hashes get upgraded
+	if needsRehash {
+		rehashedPassword, err := helper.HashPassword(payload.Password, params)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return token, nil, map[string]string{"internal": "error generating password hash"}
+		}
+
+		errorMap = usecase.UserRepository.UpdatePasswordWithTx(ctx, tx, user.Id, rehashedPassword, time.Now(), map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return token, nil, errorMap
+		}
+	}
+
+	if user.Totp_enabled_at != nil {
+		err = tx.Commit(ctx)
+		if err != nil {
+			return token, nil, map[string]string{"internal": "failed to commit transaction"}
+		}
+
+		challenge, errorMap := usecase.newMfaChallenge(ctx, user.Id, payload.DeviceLabel)
+		return token, challenge, errorMap
+	}
+
+	errorMap = usecase.cancelAccountDeletion(ctx, tx, user.Id)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	// every login opens its own session, other devices stay signed in
+	session := newSession(user.Id, payload.DeviceLabel, client)
+
+	token, errorMap = usecase.generateToken(ctx, tx, session, time.Now(), map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, nil, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.audit(ctx, newAuditLog("login.succeeded", user.Id, client, map[string]string{"method": "password", "session_id": session.Id}))
+
+	errorMap = usecase.UserRepository.ResetLoginFailures(ctx, "user", usernameCanonical, map[string]string{})
+	if errorMap != nil {
+		usecase.Log.Warn("failed to reset login failures", zap.String("user_id", user.Id))
+	}
+
+	return token, nil, nil
+}
+
+func (usecase *UserUsecase) RefreshToken(ctx context.Context, refreshTokenString string, client model.ClientInfo, errorMap map[string]string) (model.Token, map[string]string) {
+	token := model.Token{}
+
+	if refreshTokenString == "" {
+		errorMap["auth"] = "no refresh token provided"
 		return token, errorMap
-	} else if len(payload.Password) > 20 {
-		errorMap["password"] = "password must be at most 20 characters"
+	}
+
+	secretKeyRefresh := usecase.Config.String("SECRET_KEY_REFRESH_TOKEN")
+	secretKeyRefreshByte := []byte(secretKeyRefresh)
+
+	_, err := jwt.Parse(refreshTokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return secretKeyRefreshByte, nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			errorMap["auth"] = "refresh token is expired"
+			return token, errorMap
+		}
+		errorMap["auth"] = "refresh token is invalid"
 		return token, errorMap
 	}
 
@@ -199,53 +549,2018 @@ func (usecase *UserUsecase) Login(ctx context.Context, payload model.UserRegiste
 		return token, errorMap
 	}
 
-	defer helper.CommitOrRollback(ctx, tx, usecase.Log)
+	hashedRefreshToken := helper.GenerateSHA256Hash(refreshTokenString)
 
-	user, errorMap := usecase.UserRepository.LoginWithTx(ctx, tx, payload.Username, errorMap)
+	storedToken, errorMap := usecase.UserRepository.GetRefreshTokenWithTx(ctx, tx, hashedRefreshToken, errorMap)
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return token, errorMap
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password))
-	if err != nil {
-		errorMap["password"] = "wrong username or password"
+	now := time.Now()
+
+	// a rotated token being presented again means it was copied, so the whole family is burned
+	if storedToken.Status == "Rotated" {
+		errorMap = usecase.UserRepository.RevokeRefreshTokenFamilyWithTx(ctx, tx, storedToken.Family_id, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return token, errorMap
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return token, map[string]string{"internal": "failed to commit transaction"}
+		}
+
+		usecase.Log.Warn("refresh token reuse detected", zap.String("user_id", storedToken.User_id), zap.String("family_id", storedToken.Family_id))
+		usecase.audit(ctx, newAuditLog("token.reuse_detected", storedToken.User_id, client, map[string]string{"session_id": storedToken.Family_id}))
+
+		errorMap = usecase.UserRepository.RevokeSessionAccessTokens(ctx, storedToken.Family_id, accessTokenLifetime, map[string]string{})
+		if errorMap != nil {
+			return token, errorMap
+		}
+
+		return token, map[string]string{"auth": "refresh token reuse detected"}
+	}
+
+	if storedToken.Status != "Valid" {
+		_ = tx.Rollback(ctx)
+		return token, map[string]string{"auth": "refresh token is revoked"}
+	}
+
+	if now.After(storedToken.Expired_at) {
+		_ = tx.Rollback(ctx)
+		return token, map[string]string{"auth": "refresh token is expired"}
+	}
+
+	errorMap = usecase.UserRepository.UpdateRefreshTokenStatusWithTx(ctx, tx, "Rotated", storedToken.Id, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
 		return token, errorMap
 	}
 
-	token, errorMap = usecase.generateToken(ctx, tx, user.Id, time.Now(), errorMap)
+	session := model.Session{
+		Id:           storedToken.Family_id,
+		User_id:      storedToken.User_id,
+		Device_label: storedToken.Device_label,
+		User_agent:   storedToken.User_agent,
+		Ip_address:   client.Ip_address,
+	}
+
+	if client.User_agent != "" {
+		session.User_agent = truncateUserAgent(client.User_agent)
+	}
+
+	token, errorMap = usecase.generateToken(ctx, tx, session, now, map[string]string{})
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return token, errorMap
 	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.audit(ctx, newAuditLog("token.refreshed", session.User_id, client, map[string]string{"session_id": session.Id}))
+
 	return token, nil
 }
 
-func (usecase *UserUsecase) CheckUserExistance(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
-	err := usecase.UserRepository.CheckUserExistence(ctx, userUUID, errorMap)
+func (usecase *UserUsecase) GetSessions(ctx context.Context, userUUID string, currentSessionID string, errorMap map[string]string) ([]model.SessionResponse, map[string]string) {
+	response := []model.SessionResponse{}
+
+	sessions, errorMap := usecase.UserRepository.GetSessions(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	for _, session := range sessions {
+		response = append(response, model.SessionResponse{
+			Id:          session.Id,
+			DeviceLabel: session.Device_label,
+			UserAgent:   session.User_agent,
+			IpAddress:   session.Ip_address,
+			CreatedAt:   session.Created_at,
+			LastUsedAt:  session.Last_used_at,
+			Current:     session.Id == currentSessionID,
+		})
+	}
+
+	return response, nil
+}
+
+func newAuditLog(event string, userUUID string, client model.ClientInfo, metadata map[string]string) model.AuditLog {
+	return model.AuditLog{
+		Event:      event,
+		User_id:    userUUID,
+		Actor_id:   userUUID,
+		Ip_address: client.Ip_address,
+		User_agent: truncateUserAgent(client.User_agent),
+		Metadata:   metadata,
+		Created_at: time.Now(),
+	}
+}
+
+// audit appends to the audit log once the action itself went through. A failed write is logged rather than
+// failing a sign in that already happened, and a client hanging up doesn't cut the entry.
+func (usecase *UserUsecase) audit(ctx context.Context, auditLog model.AuditLog) {
+	errorMap := usecase.AuditRepository.AddAuditLog(context.WithoutCancel(ctx), auditLog, map[string]string{})
+	if errorMap != nil {
+		usecase.Log.Error("failed to write audit log", zap.String("audit_event", auditLog.Event), zap.String("user_id", auditLog.User_id))
+	}
+}
+
+func decodeAuditCursor(cursor string) (int64, bool) {
+	if cursor == "" {
+		return 0, true
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
-		return err
+		return 0, false
+	}
+
+	beforeID, err := strconv.ParseInt(string(decoded), 10, 64)
+	if err != nil || beforeID <= 0 {
+		return 0, false
+	}
+
+	return beforeID, true
+}
+
+func encodeAuditCursor(id int64) *string {
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+	return &cursor
+}
+
+// GetRecentActivity is the account owner's view of the audit log, newest first.
+func (usecase *UserUsecase) GetRecentActivity(ctx context.Context, userUUID string, cursor string, limit int, errorMap map[string]string) (model.ActivityListResponse, map[string]string) {
+	response := model.ActivityListResponse{Events: []model.ActivityResponse{}}
+
+	if limit <= 0 || limit > 50 {
+		limit = 20
+	}
+
+	beforeID, ok := decodeAuditCursor(cursor)
+	if !ok {
+		errorMap["cursor"] = "cursor is invalid"
+		return response, errorMap
+	}
+
+	// one extra row tells whether another page exists without a count query
+	auditLogs, errorMap := usecase.AuditRepository.GetAuditLogs(ctx, model.AuditLogFilter{UserID: userUUID, BeforeID: beforeID}, limit+1, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	if len(auditLogs) > limit {
+		auditLogs = auditLogs[:limit]
+		response.NextCursor = encodeAuditCursor(auditLogs[limit-1].Id)
+		response.HasMore = true
+	}
+
+	for _, auditLog := range auditLogs {
+		activity := model.ActivityResponse{
+			Id:        auditLog.Id,
+			Event:     auditLog.Event,
+			IpAddress: auditLog.Ip_address,
+			UserAgent: auditLog.User_agent,
+			Metadata:  auditLog.Metadata,
+			CreatedAt: auditLog.Created_at,
+		}
+
+		// the address and browser of an admin acting on the account are the admin's, not the owner's
+		if auditLog.Actor_id != "" && auditLog.Actor_id != userUUID {
+			activity.ByAdmin = true
+			activity.IpAddress = ""
+			activity.UserAgent = ""
+		}
+
+		response.Events = append(response.Events, activity)
+	}
+
+	return response, nil
+}
+
+func (usecase *UserUsecase) RevokeSession(ctx context.Context, userUUID string, sessionID string, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	if sessionID == "" {
+		errorMap["session"] = "session id is required to not be empty"
+		return errorMap
+	}
+
+	errorMap = usecase.UserRepository.RevokeSession(ctx, userUUID, sessionID, errorMap)
+	if errorMap != nil {
+		return errorMap
 	}
 
+	errorMap = usecase.UserRepository.RevokeSessionAccessTokens(ctx, sessionID, accessTokenLifetime, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	usecase.audit(ctx, newAuditLog("session.revoked", userUUID, client, map[string]string{"session_id": sessionID}))
+
 	return nil
 }
 
-func (usecase *UserUsecase) GetUserInfo(ctx context.Context, userUUID string, errorMap map[string]string) (model.UserInfoResponse, map[string]string) {
-	user, errorMap := usecase.UserRepository.GetUserInfo(ctx, userUUID, errorMap)
+func (usecase *UserUsecase) RevokeAllSessions(ctx context.Context, userUUID string, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	errorMap = usecase.revokeAllSessions(ctx, userUUID, errorMap)
 	if errorMap != nil {
-		return user, errorMap
+		return errorMap
 	}
 
-	return user, nil
+	usecase.audit(ctx, newAuditLog("sessions.revoked", userUUID, client, nil))
 
+	return nil
 }
 
-func (usecase *UserUsecase) GetAllUserData(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.AllUserInfoResponse, map[string]string) {
-	user, errorMap := usecase.UserRepository.GetAllUserData(ctx, userUUID, errorMap)
+// revokeAllSessions signs the account out everywhere, callers that aren't the owner record their own audit event.
+func (usecase *UserUsecase) revokeAllSessions(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
+	errorMap = usecase.UserRepository.RevokeAllSessions(ctx, userUUID, errorMap)
 	if errorMap != nil {
-		return user, errorMap
+		return errorMap
 	}
 
-	return user, nil
+	errorMap = usecase.UserRepository.RevokeUserAccessTokens(ctx, userUUID, time.Now(), accessTokenLifetime, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	return nil
+}
+
+// Logout revokes whatever the client still holds. The access token may already be expired, so only its
+// signature is checked before its jti goes on the deny list. Its session is only ended while the token is still
+// live or next to a refresh token from the same session, a leaked expired token can't sign anyone out.
+func (usecase *UserUsecase) Logout(ctx context.Context, accessTokenString string, refreshTokenString string, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	hashedRefreshToken := ""
+	refreshFamilyID := ""
+	if refreshTokenString != "" {
+		hashedRefreshToken = helper.GenerateSHA256Hash(refreshTokenString)
+
+		refreshFamilyID, errorMap = usecase.UserRepository.GetRefreshTokenFamily(ctx, hashedRefreshToken, map[string]string{})
+		if errorMap != nil && errorMap["internal"] != "" {
+			return errorMap
+		}
+	}
+
+	if accessTokenString != "" {
+		token, err := jwt.Parse(accessTokenString, usecase.KeySet.Keyfunc, jwt.WithValidMethods([]string{"RS256", "EdDSA"}), jwt.WithoutClaimsValidation())
+		if err == nil {
+			claims, _ := token.Claims.(jwt.MapClaims)
+			userUUID, _ := claims["id"].(string)
+			sessionID, _ := claims["sid"].(string)
+			jti, _ := claims["jti"].(string)
+
+			remaining := time.Duration(0)
+			expiresAt, err := claims.GetExpirationTime()
+			if err == nil && expiresAt != nil {
+				remaining = time.Until(expiresAt.Time)
+			}
+
+			if remaining > 0 && jti != "" {
+				errorMap = usecase.UserRepository.RevokeAccessToken(ctx, jti, remaining, map[string]string{})
+				if errorMap != nil {
+					return errorMap
+				}
+			}
+
+			if sessionID != "" && (remaining > 0 || sessionID == refreshFamilyID) {
+				errorMap = usecase.UserRepository.RevokeSession(ctx, userUUID, sessionID, map[string]string{})
+				if errorMap != nil && errorMap["internal"] != "" {
+					return errorMap
+				}
+
+				errorMap = usecase.UserRepository.RevokeSessionAccessTokens(ctx, sessionID, accessTokenLifetime, map[string]string{})
+				if errorMap != nil {
+					return errorMap
+				}
+
+				// a logout with only a refresh token can't be tied to an account without another lookup, so only this one is recorded
+				if userUUID != "" {
+					usecase.audit(ctx, newAuditLog("logout", userUUID, client, map[string]string{"session_id": sessionID}))
+				}
+			}
+		}
+	}
+
+	if hashedRefreshToken != "" {
+		errorMap = usecase.UserRepository.RevokeRefreshTokenFamilyByHash(ctx, hashedRefreshToken, map[string]string{})
+		if errorMap != nil {
+			return errorMap
+		}
+	}
+
+	return nil
+}
+
+// ForgotPassword never tells the caller whether the account exists. The answer only depends on the request
+// itself, the lookup, the token and the mail all happen in the background.
+func (usecase *UserUsecase) ForgotPassword(ctx context.Context, payload model.PasswordForgotRequest, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	if payload.Username == "" && payload.Email == "" {
+		errorMap["username"] = "username or email is required to not be empty"
+		return errorMap
+	}
+
+	allowed, errorMap := usecase.allowMailRequest(ctx, "password_reset", payload.Username, payload.Email, client)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if !allowed {
+		return nil
+	}
+
+	usecase.inBackground(func(ctx context.Context) {
+		user, ok := usecase.findMailRecipient(ctx, payload.Username, payload.Email)
+		if !ok {
+			return
+		}
+
+		resetToken, err := helper.GenerateRandomToken(32)
+		if err != nil {
+			usecase.Log.Error("failed to generate reset token", zap.String("user_id", user.Id), zap.Error(err))
+			return
+		}
+
+		ttl := helper.ConfigDuration(usecase.Config, "PASSWORD_RESET_TOKEN_TTL", 30*time.Minute)
+
+		now := time.Now()
+		errorMap := usecase.UserRepository.AddPasswordResetToken(ctx, model.PasswordResetToken{
+			User_id:      user.Id,
+			Hashed_token: helper.GenerateSHA256Hash(resetToken),
+			Created_at:   now,
+			Expired_at:   now.Add(ttl),
+		}, map[string]string{})
+		if errorMap != nil {
+			usecase.Log.Error("failed to add password reset token", zap.String("user_id", user.Id), zap.Any("errors", errorMap))
+			return
+		}
+
+		resetURL := helper.ConfigString(usecase.Config, "PASSWORD_RESET_URL", "http://localhost:4200/reset-password")
+
+		usecase.sendMail(ctx, user.Id, mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your MyChat password",
+			Body: "Hi " + user.Username + ",\n\n" +
+				"Someone asked to reset the password of your account. Use the link below within " + ttl.String() + " to choose a new one:\n\n" +
+				resetURL + "?token=" + resetToken + "\n\n" +
+				"If it wasn't you, you can ignore this email.",
+		})
+	})
+
+	return nil
+}
+
+// allowMailRequest caps how many mails can be asked for per requested account and per ip, so these endpoints
+// can't be used to flood someone's inbox. It counts whatever was asked for, existing account or not, and a
+// request over the cap is answered the same as any other. The counters are the login throttle's.
+func (usecase *UserUsecase) allowMailRequest(ctx context.Context, purpose string, username string, email string, client model.ClientInfo) (bool, map[string]string) {
+	subject := "user:" + helper.CanonicalUsername(username)
+	if email != "" {
+		normalizedEmail, ok := helper.NormalizeEmail(email)
+		if !ok {
+			return false, nil
+		}
+		subject = "email:" + normalizedEmail
+	}
+
+	window := helper.ConfigDuration(usecase.Config, "MAIL_REQUEST_WINDOW", time.Hour)
+
+	subjects := []struct {
+		scope   string
+		subject string
+		limit   int64
+	}{
+		{purpose, subject, int64(helper.ConfigInt(usecase.Config, "MAIL_REQUEST_MAX", 3))},
+		{purpose + "_ip", client.Ip_address, int64(helper.ConfigInt(usecase.Config, "MAIL_REQUEST_IP_MAX", 20))},
+	}
+
+	for _, subject := range subjects {
+		if subject.subject == "" {
+			continue
+		}
+
+		requests, errorMap := usecase.UserRepository.AddLoginFailure(ctx, subject.scope, subject.subject, window, map[string]string{})
+		if errorMap != nil {
+			return false, errorMap
+		}
+
+		if requests > subject.limit {
+			usecase.Log.Warn("mail request throttled",
+				zap.String("event", "mail_request_throttled"),
+				zap.String("scope", subject.scope),
+				zap.String("subject", subject.subject),
+				zap.String("ip_address", client.Ip_address),
+				zap.Int64("requests", requests),
+			)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// findMailRecipient looks the account up by address or by username. An unverified address may belong to someone
+// else, so an account without a verified one is treated the same as no account.
+func (usecase *UserUsecase) findMailRecipient(ctx context.Context, username string, email string) (model.User, bool) {
+	var user model.User
+	var errorMap map[string]string
+	if email != "" {
+		normalizedEmail, ok := helper.NormalizeEmail(email)
+		if !ok {
+			return user, false
+		}
+		user, errorMap = usecase.UserRepository.GetUserByEmail(ctx, normalizedEmail, map[string]string{})
+	} else {
+		user, errorMap = usecase.UserRepository.GetUserByUsername(ctx, helper.CanonicalUsername(username), map[string]string{})
+	}
+
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			usecase.Log.Error("failed to look up mail recipient", zap.Any("errors", errorMap))
+		}
+		return user, false
+	}
+
+	if user.Email == "" || user.Email_verified_at == nil {
+		return user, false
+	}
+
+	return user, true
+}
+
+// inBackground runs work the caller shouldn't wait for or be able to time. It gets its own deadline since the
+// request's context ends with the response.
+func (usecase *UserUsecase) inBackground(task func(ctx context.Context)) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		task(ctx)
+	}()
+}
+
+func (usecase *UserUsecase) sendMail(ctx context.Context, userUUID string, message mailer.Message) {
+	err := usecase.Mailer.Send(ctx, message)
+	if err != nil {
+		usecase.Log.Error("failed to send mail", zap.String("user_id", userUUID), zap.String("subject", message.Subject), zap.Error(err))
+	}
+}
+
+// sendMailAsync delivers in the background so a slow mail server neither blocks the request
+// nor reveals through timing whether a mail was sent at all.
+func (usecase *UserUsecase) sendMailAsync(userUUID string, message mailer.Message) {
+	usecase.inBackground(func(ctx context.Context) {
+		usecase.sendMail(ctx, userUUID, message)
+	})
+}
+
+func (usecase *UserUsecase) magicLinkSecretKey() ([]byte, map[string]string) {
+	secretKey := usecase.Config.String("SECRET_KEY_MAGIC_LINK")
+	if secretKey == "" {
+		return nil, map[string]string{"internal": "magic link secret key is not configured"}
+	}
+
+	return []byte(secretKey), nil
+}
+
+// RequestMagicLink mails a sign in link to the account's verified address. Like ForgotPassword its answer only
+// depends on the request, so it can't be used to find out which accounts exist.
+func (usecase *UserUsecase) RequestMagicLink(ctx context.Context, payload model.MagicLinkRequest, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	if payload.Username == "" && payload.Email == "" {
+		errorMap["username"] = "username or email is required to not be empty"
+		return errorMap
+	}
+
+	secretKey, errorMap := usecase.magicLinkSecretKey()
+	if errorMap != nil {
+		return errorMap
+	}
+
+	allowed, errorMap := usecase.allowMailRequest(ctx, "magic_link", payload.Username, payload.Email, client)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if !allowed {
+		return nil
+	}
+
+	usecase.inBackground(func(ctx context.Context) {
+		user, ok := usecase.findMailRecipient(ctx, payload.Username, payload.Email)
+		if !ok {
+			return
+		}
+
+		ttl := helper.ConfigDuration(usecase.Config, "MAGIC_LINK_TTL", 15*time.Minute)
+		now := time.Now()
+		jti := uuid.New().String()
+
+		// the signature lets a forged or tampered link be turned away without a lookup, the stored jti is what
+		// makes it single use
+		magicToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": user.Id,
+			"jti": jti,
+			"typ": "magic_link",
+			"iat": now.Unix(),
+			"exp": now.Add(ttl).Unix(),
+		}).SignedString(secretKey)
+		if err != nil {
+			usecase.Log.Error("failed to sign magic link", zap.String("user_id", user.Id), zap.Error(err))
+			return
+		}
+
+		errorMap := usecase.UserRepository.AddMagicLink(ctx, jti, model.MagicLink{
+			User_id: user.Id,
+			Email:   user.Email,
+		}, ttl, map[string]string{})
+		if errorMap != nil {
+			usecase.Log.Error("failed to add magic link", zap.String("user_id", user.Id), zap.Any("errors", errorMap))
+			return
+		}
+
+		magicLinkURL := helper.ConfigString(usecase.Config, "MAGIC_LINK_URL", "http://localhost:4200/login/magic")
+
+		usecase.sendMail(ctx, user.Id, mailer.Message{
+			To:      user.Email,
+			Subject: "Your MyChat sign in link",
+			Body: "Hi " + user.Username + ",\n\n" +
+				"Use the link below within " + ttl.String() + " to sign in to your account. It works once:\n\n" +
+				magicLinkURL + "?token=" + magicToken + "\n\n" +
+				"If you didn't ask to sign in, you can ignore this email.",
+		})
+	})
+
+	return nil
+}
+
+// CompleteMagicLinkLogin trades a link from RequestMagicLink for a session. The link stands in for the password
+// only, an account with two-factor authentication still gets a challenge.
+func (usecase *UserUsecase) CompleteMagicLinkLogin(ctx context.Context, payload model.MagicLinkLoginRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, *model.MfaChallengeResponse, map[string]string) {
+	token := model.Token{}
+
+	if payload.Token == "" {
+		errorMap["token"] = "token is required to not be empty"
+		return token, nil, errorMap
+	}
+
+	if len(payload.DeviceLabel) > 100 {
+		errorMap["device_label"] = "device label must be at most 100 characters"
+		return token, nil, errorMap
+	}
+
+	secretKey, errorMap := usecase.magicLinkSecretKey()
+	if errorMap != nil {
+		return token, nil, errorMap
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(payload.Token, claims, func(token *jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return token, nil, map[string]string{"token": "sign in link is invalid or expired"}
+	}
+
+	userUUID, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	tokenType, _ := claims["typ"].(string)
+	if userUUID == "" || jti == "" || tokenType != "magic_link" {
+		return token, nil, map[string]string{"token": "sign in link is invalid or expired"}
+	}
+
+	magicLink, errorMap := usecase.UserRepository.TakeMagicLink(ctx, jti, map[string]string{})
+	if errorMap != nil {
+		return token, nil, errorMap
+	}
+
+	if magicLink.User_id != userUUID {
+		return token, nil, map[string]string{"token": "sign in link is invalid or expired"}
+	}
+
+	// a link sent before the address changed doesn't sign in anymore
+	user, errorMap := usecase.UserRepository.GetUserByID(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			return token, nil, errorMap
+		}
+		return token, nil, map[string]string{"token": "sign in link is invalid or expired"}
+	}
+
+	if user.Email != magicLink.Email || user.Email_verified_at == nil {
+		return token, nil, map[string]string{"token": "sign in link is invalid or expired"}
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return token, nil, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	totpState, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	if totpState.Enabled_at != nil {
+		_ = tx.Rollback(ctx)
+
+		challenge, errorMap := usecase.newMfaChallenge(ctx, userUUID, payload.DeviceLabel)
+		return token, challenge, errorMap
+	}
+
+	errorMap = usecase.cancelAccountDeletion(ctx, tx, userUUID)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	session := newSession(userUUID, payload.DeviceLabel, client)
+
+	token, errorMap = usecase.generateToken(ctx, tx, session, time.Now(), map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, nil, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.Log.Info("magic link login", zap.String("event", "magic_link_login"), zap.String("user_id", userUUID), zap.String("ip_address", client.Ip_address))
+	usecase.audit(ctx, newAuditLog("login.succeeded", userUUID, client, map[string]string{"method": "magic_link", "session_id": session.Id}))
+
+	return token, nil, nil
+}
+
+func (usecase *UserUsecase) sendEmailVerification(ctx context.Context, user model.User) map[string]string {
+	verificationToken, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		return map[string]string{"internal": "failed to generate verification token"}
+	}
+
+	ttl := helper.ConfigDuration(usecase.Config, "EMAIL_VERIFICATION_TOKEN_TTL", 24*time.Hour)
+
+	now := time.Now()
+	errorMap := usecase.UserRepository.AddEmailVerificationToken(ctx, model.EmailVerificationToken{
+		User_id:      user.Id,
+		Email:        user.Email,
+		Hashed_token: helper.GenerateSHA256Hash(verificationToken),
+		Created_at:   now,
+		Expired_at:   now.Add(ttl),
+	}, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	verifyURL := helper.ConfigString(usecase.Config, "EMAIL_VERIFICATION_URL", "http://localhost:4200/verify-email")
+
+	usecase.sendMailAsync(user.Id, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your MyChat email address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Confirm this address for your account by opening the link below within " + ttl.String() + ":\n\n" +
+			verifyURL + "?token=" + verificationToken + "\n\n" +
+			"If you didn't sign up, you can ignore this email.",
+	})
+
+	return nil
+}
+
+func (usecase *UserUsecase) VerifyEmail(ctx context.Context, payload model.EmailVerifyRequest, errorMap map[string]string) map[string]string {
+	if payload.Token == "" {
+		errorMap["token"] = "token is required to not be empty"
+		return errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return errorMap
+	}
+
+	verificationToken, errorMap := usecase.UserRepository.GetEmailVerificationTokenWithTx(ctx, tx, helper.GenerateSHA256Hash(payload.Token), errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	now := time.Now()
+	if verificationToken.Used_at != nil || now.After(verificationToken.Expired_at) {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"token": "verification token is invalid or expired"}
+	}
+
+	errorMap = usecase.UserRepository.VerifyEmailWithTx(ctx, tx, verificationToken.User_id, verificationToken.Email, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return nil
+}
+
+func (usecase *UserUsecase) ResendEmailVerification(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
+	user, errorMap := usecase.UserRepository.GetUserByID(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if user.Email == "" {
+		return map[string]string{"email": "no email address on this account"}
+	}
+
+	if user.Email_verified_at != nil {
+		return map[string]string{"email": "email address is already verified"}
+	}
+
+	errorMap = usecase.sendEmailVerification(ctx, user)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	return nil
+}
+
+// normalizeUsername validates a requested username and derives its canonical form and skeleton.
+func (usecase *UserUsecase) normalizeUsername(requested string, errorMap map[string]string) (string, string, string, map[string]string) {
+	username, canonical, err := helper.NormalizeUsername(requested)
+	if err != nil {
+		errorMap["username"] = err.Error()
+		return "", "", "", errorMap
+	}
+
+	skeleton := helper.UsernameSkeleton(canonical)
+
+	extraReserved := strings.Split(usecase.Config.String("USERNAME_RESERVED"), ",")
+	if helper.IsReservedUsername(skeleton, extraReserved) {
+		errorMap["username"] = "username is reserved"
+		return "", "", "", errorMap
+	}
+
+	return username, canonical, skeleton, nil
+}
+
+// UpdateUsername renames the account. The old handle stays held for a while so nobody else can pick it up and
+// impersonate the owner, and renames are rate limited so handles can't be hoarded that way.
+func (usecase *UserUsecase) UpdateUsername(ctx context.Context, userUUID string, payload model.UsernameUpdateRequest, errorMap map[string]string) map[string]string {
+	username, usernameCanonical, usernameSkeleton, errorMap := usecase.normalizeUsername(payload.Username, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to start transaction"}
+	}
+
+	user, errorMap := usecase.UserRepository.GetUsernameWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if user.Username == username {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"username": "username is unchanged"}
+	}
+
+	now := time.Now()
+
+	lastChangedAt, errorMap := usecase.UserRepository.GetLastUsernameChangeWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	cooldown := helper.ConfigDuration(usecase.Config, "USERNAME_CHANGE_COOLDOWN", 24*time.Hour)
+	if lastChangedAt != nil && now.Before(lastChangedAt.Add(cooldown)) {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"username": "username was changed recently, try again later"}
+	}
+
+	errorMap = usecase.UserRepository.CheckUsernameUniqueWithTx(ctx, tx, usernameCanonical, usernameSkeleton, userUUID, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.UserRepository.AddUsernameHistoryWithTx(ctx, tx, model.UsernameHistory{
+		User_id:            userUUID,
+		Username:           user.Username,
+		Username_canonical: user.Username_canonical,
+		Username_skeleton:  user.Username_skeleton,
+		Changed_at:         now,
+		Held_until:         now.Add(helper.ConfigDuration(usecase.Config, "USERNAME_HOLD_PERIOD", 30*24*time.Hour)),
+	}, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	user.Username = username
+	user.Username_canonical = usernameCanonical
+	user.Username_skeleton = usernameSkeleton
+
+	errorMap = usecase.UserRepository.UpdateUsernameWithTx(ctx, tx, user, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return nil
+}
+
+func (usecase *UserUsecase) UpdateEmail(ctx context.Context, userUUID string, payload model.EmailUpdateRequest, errorMap map[string]string) map[string]string {
+	if payload.Email == "" {
+		errorMap["email"] = "email is required to not be empty"
+		return errorMap
+	}
+
+	email, ok := helper.NormalizeEmail(payload.Email)
+	if !ok {
+		errorMap["email"] = "email is not a valid address"
+		return errorMap
+	}
+
+	user, errorMap := usecase.UserRepository.GetUserByID(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if user.Email == email {
+		return map[string]string{"email": "email is unchanged"}
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to start transaction"}
+	}
+
+	errorMap = usecase.UserRepository.CheckEmailUniqueWithTx(ctx, tx, email, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.UserRepository.UpdateEmailWithTx(ctx, tx, userUUID, email, time.Now(), map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	user.Email = email
+
+	errorMap = usecase.sendEmailVerification(ctx, user)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	return nil
+}
+
+func (usecase *UserUsecase) ResetPassword(ctx context.Context, payload model.PasswordResetRequest, client model.ClientInfo, errorMap map[string]string) map[string]string {
+	if payload.Token == "" {
+		errorMap["token"] = "token is required to not be empty"
+		return errorMap
+	}
+
+	if payload.NewPassword == "" {
+		errorMap["new_password"] = "new password is required to not be empty"
+		return errorMap
+	} else if len(payload.NewPassword) < 5 {
+		errorMap["new_password"] = "new password must be at least 5 characters"
+		return errorMap
+	} else if len(payload.NewPassword) > 128 {
+		errorMap["new_password"] = "new password must be at most 128 characters"
+		return errorMap
+	}
+
+	hashedPassword, err := helper.HashPassword(payload.NewPassword, usecase.passwordParams())
+	if err != nil {
+		errorMap["internal"] = "error generating password hash"
+		return errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return errorMap
+	}
+
+	resetToken, errorMap := usecase.UserRepository.GetPasswordResetTokenWithTx(ctx, tx, helper.GenerateSHA256Hash(payload.Token), errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	now := time.Now()
+	if resetToken.Used_at != nil || now.After(resetToken.Expired_at) {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"token": "reset token is invalid or expired"}
+	}
+
+	errorMap = usecase.UserRepository.UpdatePasswordWithTx(ctx, tx, resetToken.User_id, string(hashedPassword), now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.UserRepository.UsePasswordResetTokensWithTx(ctx, tx, resetToken.User_id, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.audit(ctx, newAuditLog("password.changed", resetToken.User_id, client, map[string]string{"method": "reset"}))
+
+	// whoever knew the old password must not keep a session
+	errorMap = usecase.revokeAllSessions(ctx, resetToken.User_id, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	return nil
+}
+
+const (
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount       = 10
+)
+
+func (usecase *UserUsecase) mfaSecretKey() (string, map[string]string) {
+	secretKey := usecase.Config.String("MFA_SECRET_KEY")
+	if secretKey == "" {
+		return "", map[string]string{"internal": "mfa secret key is not configured"}
+	}
+
+	return secretKey, nil
+}
+
+func (usecase *UserUsecase) newMfaChallenge(ctx context.Context, userUUID string, deviceLabel string) (*model.MfaChallengeResponse, map[string]string) {
+	mfaToken, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		return nil, map[string]string{"internal": "failed to generate mfa token"}
+	}
+
+	ttl := helper.ConfigDuration(usecase.Config, "MFA_CHALLENGE_TTL", 5*time.Minute)
+
+	errorMap := usecase.UserRepository.AddMfaChallenge(ctx, helper.GenerateSHA256Hash(mfaToken), model.MfaChallenge{
+		User_id:      userUUID,
+		Device_label: deviceLabel,
+	}, ttl, map[string]string{})
+	if errorMap != nil {
+		return nil, errorMap
+	}
+
+	return &model.MfaChallengeResponse{
+		MfaRequired: true,
+		MfaToken:    mfaToken,
+		ExpiresIn:   int(ttl.Seconds()),
+	}, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code, never both.
+func (usecase *UserUsecase) verifySecondFactor(ctx context.Context, tx pgx.Tx, state model.TotpState, code string, recoveryCode string, now time.Time) map[string]string {
+	if (code == "") == (recoveryCode == "") {
+		return map[string]string{"code": "provide either a code or a recovery code"}
+	}
+
+	if recoveryCode != "" {
+		hashedCode := helper.GenerateSHA256Hash(helper.NormalizeRecoveryCode(recoveryCode))
+
+		used, errorMap := usecase.UserRepository.UseRecoveryCodeWithTx(ctx, tx, state.User_id, hashedCode, now, map[string]string{})
+		if errorMap != nil {
+			return errorMap
+		}
+
+		if !used {
+			return map[string]string{"recovery_code": "recovery code is invalid"}
+		}
+
+		return nil
+	}
+
+	secretKey, errorMap := usecase.mfaSecretKey()
+	if errorMap != nil {
+		return errorMap
+	}
+
+	secret, err := helper.DecryptString(secretKey, state.Secret)
+	if err != nil {
+		return map[string]string{"internal": "failed to decrypt totp secret"}
+	}
+
+	step, ok := helper.ValidateTOTP(secret, code, now, state.Last_step)
+	if !ok {
+		return map[string]string{"code": "code is invalid"}
+	}
+
+	errorMap = usecase.UserRepository.UpdateTotpLastStepWithTx(ctx, tx, state.User_id, step, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	return nil
+}
+
+// EnrollTotp starts (or restarts) enrollment. The secret stays inactive until ConfirmTotp sees a valid code.
+func (usecase *UserUsecase) EnrollTotp(ctx context.Context, userUUID string, errorMap map[string]string) (model.TotpEnrollResponse, map[string]string) {
+	response := model.TotpEnrollResponse{}
+
+	secretKey, errorMap := usecase.mfaSecretKey()
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		return response, map[string]string{"internal": "failed to generate totp secret"}
+	}
+
+	encryptedSecret, err := helper.EncryptString(secretKey, secret)
+	if err != nil {
+		return response, map[string]string{"internal": "failed to encrypt totp secret"}
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	hashedCodes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		recoveryCode, err := helper.GenerateRecoveryCode()
+		if err != nil {
+			return response, map[string]string{"internal": "failed to generate recovery code"}
+		}
+
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		hashedCodes = append(hashedCodes, helper.GenerateSHA256Hash(helper.NormalizeRecoveryCode(recoveryCode)))
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return response, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	state, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	if state.Enabled_at != nil {
+		_ = tx.Rollback(ctx)
+		return response, map[string]string{"mfa": "two-factor authentication is already enabled"}
+	}
+
+	now := time.Now()
+
+	errorMap = usecase.UserRepository.SetTotpSecretWithTx(ctx, tx, userUUID, encryptedSecret, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	errorMap = usecase.UserRepository.ReplaceRecoveryCodesWithTx(ctx, tx, userUUID, hashedCodes, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return response, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	issuer := helper.ConfigString(usecase.Config, "MFA_ISSUER", "MyChat")
+
+	response = model.TotpEnrollResponse{
+		Secret:        secret,
+		OtpauthURI:    helper.TOTPURI(issuer, state.Username, secret),
+		RecoveryCodes: recoveryCodes,
+	}
+
+	return response, nil
+}
+
+func (usecase *UserUsecase) ConfirmTotp(ctx context.Context, userUUID string, payload model.TotpCodeRequest, errorMap map[string]string) map[string]string {
+	if payload.Code == "" {
+		errorMap["code"] = "code is required to not be empty"
+		return errorMap
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return errorMap
+	}
+
+	state, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, userUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if state.Enabled_at != nil {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"mfa": "two-factor authentication is already enabled"}
+	}
+
+	if state.Secret == "" {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"mfa": "two-factor enrollment has not been started"}
+	}
+
+	now := time.Now()
+
+	errorMap = usecase.verifySecondFactor(ctx, tx, state, payload.Code, "", now)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.UserRepository.EnableTotpWithTx(ctx, tx, userUUID, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return nil
+}
+
+func (usecase *UserUsecase) DisableTotp(ctx context.Context, userUUID string, payload model.TotpCodeRequest, errorMap map[string]string) map[string]string {
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return errorMap
+	}
+
+	state, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, userUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if state.Enabled_at == nil {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"mfa": "two-factor authentication is not enabled"}
+	}
+
+	now := time.Now()
+
+	errorMap = usecase.verifySecondFactor(ctx, tx, state, payload.Code, payload.RecoveryCode, now)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	errorMap = usecase.UserRepository.DisableTotpWithTx(ctx, tx, userUUID, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return nil
+}
+
+// CompleteMfaLogin trades a pending challenge plus a valid second factor for the session Login would have opened.
+func (usecase *UserUsecase) CompleteMfaLogin(ctx context.Context, payload model.MfaLoginRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, map[string]string) {
+	token := model.Token{}
+
+	if payload.MfaToken == "" {
+		errorMap["mfa_token"] = "mfa token is required to not be empty"
+		return token, errorMap
+	}
+
+	hashedMfaToken := helper.GenerateSHA256Hash(payload.MfaToken)
+
+	challenge, errorMap := usecase.UserRepository.GetMfaChallenge(ctx, hashedMfaToken, errorMap)
+	if errorMap != nil {
+		return token, errorMap
+	}
+
+	attempts, errorMap := usecase.UserRepository.IncrMfaChallengeAttempts(ctx, hashedMfaToken, map[string]string{})
+	if errorMap != nil {
+		return token, errorMap
+	}
+
+	if attempts > mfaChallengeMaxAttempts {
+		_ = usecase.UserRepository.DeleteMfaChallenge(ctx, hashedMfaToken, map[string]string{})
+		usecase.audit(ctx, newAuditLog("login.failed", challenge.User_id, client, map[string]string{"method": "mfa", "reason": "too_many_attempts"}))
+		return token, map[string]string{"mfa_token": "too many attempts, sign in again"}
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return token, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	state, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, challenge.User_id, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	if state.Enabled_at == nil {
+		_ = tx.Rollback(ctx)
+		return token, map[string]string{"mfa_token": "mfa token is invalid or expired"}
+	}
+
+	now := time.Now()
+
+	errorMap = usecase.verifySecondFactor(ctx, tx, state, payload.Code, payload.RecoveryCode, now)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+
+		// wrong codes count towards the same lockout as wrong passwords, so fresh challenges don't reset the budget
+		if errorMap["internal"] == "" {
+			usecase.audit(ctx, newAuditLog("login.failed", challenge.User_id, client, map[string]string{"method": "mfa", "reason": "wrong_code"}))
+
+			lockoutErrorMap := usecase.addLoginFailure(ctx, challenge.User_id, helper.CanonicalUsername(state.Username), client)
+			if lockoutErrorMap != nil {
+				return token, lockoutErrorMap
+			}
+		}
+
+		return token, errorMap
+	}
+
+	errorMap = usecase.cancelAccountDeletion(ctx, tx, challenge.User_id)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	session := newSession(challenge.User_id, challenge.Device_label, client)
+
+	token, errorMap = usecase.generateToken(ctx, tx, session, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.audit(ctx, newAuditLog("login.succeeded", challenge.User_id, client, map[string]string{"method": "mfa", "session_id": session.Id}))
+
+	errorMap = usecase.UserRepository.DeleteMfaChallenge(ctx, hashedMfaToken, map[string]string{})
+	if errorMap != nil {
+		usecase.Log.Warn("failed to delete mfa challenge", zap.String("user_id", challenge.User_id))
+	}
+
+	errorMap = usecase.UserRepository.ResetLoginFailures(ctx, "user", helper.CanonicalUsername(state.Username), map[string]string{})
+	if errorMap != nil {
+		usecase.Log.Warn("failed to reset login failures", zap.String("user_id", challenge.User_id))
+	}
+
+	return token, nil
+}
+
+// StartOidcLogin remembers the state, nonce and PKCE verifier of a new sign in and returns the provider's
+// authorization url to send the browser to.
+func (usecase *UserUsecase) StartOidcLogin(ctx context.Context, deviceLabel string, errorMap map[string]string) (string, map[string]string) {
+	if usecase.OidcProvider == nil {
+		errorMap["oidc"] = "single sign-on is not configured"
+		return "", errorMap
+	}
+
+	if len(deviceLabel) > 100 {
+		errorMap["device_label"] = "device label must be at most 100 characters"
+		return "", errorMap
+	}
+
+	state, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		errorMap["internal"] = "failed to generate state"
+		return "", errorMap
+	}
+
+	nonce, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		errorMap["internal"] = "failed to generate nonce"
+		return "", errorMap
+	}
+
+	codeVerifier, codeChallenge, err := helper.NewPKCEVerifier()
+	if err != nil {
+		errorMap["internal"] = "failed to generate code verifier"
+		return "", errorMap
+	}
+
+	authorizationURL, err := usecase.OidcProvider.AuthCodeURL(ctx, state, nonce, codeChallenge)
+	if err != nil {
+		usecase.Log.Error("failed to load oidc provider metadata", zap.Error(err))
+		errorMap["internal"] = "failed to reach the identity provider"
+		return "", errorMap
+	}
+
+	errorMap = usecase.UserRepository.AddOidcState(ctx, helper.GenerateSHA256Hash(state), model.OidcState{
+		Nonce:         nonce,
+		Code_verifier: codeVerifier,
+		Device_label:  deviceLabel,
+	}, helper.ConfigDuration(usecase.Config, "OIDC_STATE_TTL", 10*time.Minute), errorMap)
+	if errorMap != nil {
+		return "", errorMap
+	}
+
+	return authorizationURL, nil
+}
+
+// CompleteOidcLogin handles the provider's redirect back. The state is spent whatever the outcome, the code is
+// exchanged with the PKCE verifier and the ID token has to carry the nonce from StartOidcLogin. Accounts with
+// two-factor authentication still get a challenge, same as a password login.
+func (usecase *UserUsecase) CompleteOidcLogin(ctx context.Context, payload model.OidcCallbackRequest, client model.ClientInfo, errorMap map[string]string) (model.Token, *model.MfaChallengeResponse, map[string]string) {
+	token := model.Token{}
+
+	if usecase.OidcProvider == nil {
+		errorMap["oidc"] = "single sign-on is not configured"
+		return token, nil, errorMap
+	}
+
+	if payload.State == "" {
+		errorMap["state"] = "sign in request is invalid or expired"
+		return token, nil, errorMap
+	}
+
+	state, errorMap := usecase.UserRepository.TakeOidcState(ctx, helper.GenerateSHA256Hash(payload.State), errorMap)
+	if errorMap != nil {
+		return token, nil, errorMap
+	}
+
+	if payload.Error != "" {
+		return token, nil, map[string]string{"oidc": "sign in was canceled or denied by the identity provider"}
+	}
+
+	if payload.Code == "" {
+		return token, nil, map[string]string{"code": "authorization code is required to not be empty"}
+	}
+
+	rawIDToken, err := usecase.OidcProvider.Exchange(ctx, payload.Code, state.Code_verifier)
+	if err != nil {
+		usecase.Log.Warn("failed to exchange oidc authorization code", zap.Error(err))
+		return token, nil, map[string]string{"oidc": "failed to exchange the authorization code"}
+	}
+
+	claims, err := usecase.OidcProvider.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		usecase.Log.Warn("oidc id token rejected", zap.String("event", "oidc_id_token_rejected"), zap.String("ip_address", client.Ip_address), zap.Error(err))
+		return token, nil, map[string]string{"oidc": "identity provider returned an invalid id token"}
+	}
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return token, nil, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	now := time.Now()
+
+	userUUID, errorMap := usecase.resolveOidcUserWithTx(ctx, tx, claims, now)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	totpState, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	if totpState.Enabled_at != nil {
+		err = tx.Commit(ctx)
+		if err != nil {
+			return token, nil, map[string]string{"internal": "failed to commit transaction"}
+		}
+
+		challenge, errorMap := usecase.newMfaChallenge(ctx, userUUID, state.Device_label)
+		return token, challenge, errorMap
+	}
+
+	errorMap = usecase.cancelAccountDeletion(ctx, tx, userUUID)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	session := newSession(userUUID, state.Device_label, client)
+
+	token, errorMap = usecase.generateToken(ctx, tx, session, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return token, nil, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return token, nil, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	usecase.Log.Info("oidc login", zap.String("event", "oidc_login"), zap.String("user_id", userUUID), zap.String("ip_address", client.Ip_address))
+	usecase.audit(ctx, newAuditLog("login.succeeded", userUUID, client, map[string]string{"method": "oidc", "session_id": session.Id}))
+
+	return token, nil, nil
+}
+
+// resolveOidcUserWithTx finds the account an identity signs in to. A known issuer and subject pair wins, then
+// an account whose verified address matches an address the provider vouches for gets linked, and otherwise a
+// new account is registered for the identity.
+func (usecase *UserUsecase) resolveOidcUserWithTx(ctx context.Context, tx pgx.Tx, claims *helper.OidcClaims, now time.Time) (string, map[string]string) {
+	email := ""
+	if claims.Email != "" && claims.IsEmailVerified() {
+		normalizedEmail, ok := helper.NormalizeEmail(claims.Email)
+		if ok {
+			email = normalizedEmail
+		}
+	}
+
+	identity, errorMap := usecase.UserRepository.GetUserIdentityWithTx(ctx, tx, claims.Issuer, claims.Subject, map[string]string{})
+	if errorMap == nil {
+		errorMap = usecase.UserRepository.UpdateUserIdentityLoginWithTx(ctx, tx, identity.Issuer, identity.Subject, email, now, map[string]string{})
+		if errorMap != nil {
+			return "", errorMap
+		}
+
+		return identity.User_id, nil
+	}
+
+	if errorMap["identity"] == "" {
+		return "", errorMap
+	}
+
+	userUUID := ""
+
+	if email != "" && helper.ConfigBool(usecase.Config, "OIDC_LINK_BY_EMAIL", true) {
+		user, errorMap := usecase.UserRepository.GetUserByVerifiedEmailWithTx(ctx, tx, email, map[string]string{})
+		if errorMap != nil && errorMap["user"] == "" {
+			return "", errorMap
+		}
+
+		if errorMap == nil {
+			userUUID = user.Id
+		}
+	}
+
+	if userUUID == "" {
+		if !helper.ConfigBool(usecase.Config, "OIDC_AUTO_REGISTER", true) {
+			return "", map[string]string{"oidc": "no account is linked to this identity"}
+		}
+
+		userUUID, errorMap = usecase.registerOidcUserWithTx(ctx, tx, claims, email, now)
+		if errorMap != nil {
+			return "", errorMap
+		}
+	}
+
+	errorMap = usecase.UserRepository.AddUserIdentityWithTx(ctx, tx, model.UserIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		User_id:       userUUID,
+		Email:         email,
+		Created_at:    now,
+		Last_login_at: now,
+	}, map[string]string{})
+	if errorMap != nil {
+		return "", errorMap
+	}
+
+	usecase.Log.Info("oidc identity linked", zap.String("event", "oidc_identity_linked"), zap.String("user_id", userUUID), zap.String("issuer", claims.Issuer))
+
+	return userUUID, nil
+}
+
+// registerOidcUserWithTx creates an account for a first time identity. Its password is random and never shown,
+// so it can only sign in through the provider until the owner sets one with a password reset.
+func (usecase *UserUsecase) registerOidcUserWithTx(ctx context.Context, tx pgx.Tx, claims *helper.OidcClaims, email string, now time.Time) (string, map[string]string) {
+	username, usernameCanonical, usernameSkeleton, errorMap := usecase.pickOidcUsernameWithTx(ctx, tx, claims, email, now)
+	if errorMap != nil {
+		return "", errorMap
+	}
+
+	// an address held by an unverified account isn't taken over, the new account just goes without one
+	if email != "" {
+		errorMap = usecase.UserRepository.CheckEmailUniqueWithTx(ctx, tx, email, map[string]string{})
+		if errorMap != nil && errorMap["email"] == "" {
+			return "", errorMap
+		}
+
+		if errorMap != nil {
+			email = ""
+		}
+	}
+
+	randomPassword, err := helper.GenerateRandomToken(32)
+	if err != nil {
+		return "", map[string]string{"internal": "failed to generate password"}
+	}
+
+	hashedPassword, err := helper.HashPassword(randomPassword, usecase.passwordParams())
+	if err != nil {
+		return "", map[string]string{"internal": "error generating password hash"}
+	}
+
+	user := model.User{
+		Id:                 uuid.New().String(),
+		Username:           username,
+		Username_canonical: usernameCanonical,
+		Username_skeleton:  usernameSkeleton,
+		Password:           hashedPassword,
+		Email:              email,
+		Created_at:         now,
+		Updated_at:         now,
+	}
+
+	errorMap = usecase.UserRepository.RegisterWithTx(ctx, tx, user, map[string]string{})
+	if errorMap != nil {
+		return "", errorMap
+	}
+
+	if email != "" {
+		errorMap = usecase.UserRepository.VerifyEmailWithTx(ctx, tx, user.Id, email, now, map[string]string{})
+		if errorMap != nil {
+			return "", errorMap
+		}
+	}
+
+	return user.Id, nil
+}
+
+// pickOidcUsernameWithTx starts from the provider's preferred username or the address's local part and adds
+// a random suffix until it finds one that is free.
+func (usecase *UserUsecase) pickOidcUsernameWithTx(ctx context.Context, tx pgx.Tx, claims *helper.OidcClaims, email string, now time.Time) (string, string, string, map[string]string) {
+	base := claims.PreferredUsername
+	if base == "" && email != "" {
+		base = email[:strings.Index(email, "@")]
+	}
+
+	base = strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, base), "._")
+
+	if utf8.RuneCountInString(base) < 4 {
+		base = "user"
+	}
+
+	// room for the suffix
+	if runes := []rune(base); len(runes) > 17 {
+		base = strings.TrimRight(string(runes[:17]), ".")
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 10; attempt++ {
+		if attempt > 0 {
+			suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return "", "", "", map[string]string{"internal": "failed to generate username"}
+			}
+			candidate = fmt.Sprintf("%s_%04d", base, suffix.Int64())
+		}
+
+		username, usernameCanonical, usernameSkeleton, errorMap := usecase.normalizeUsername(candidate, map[string]string{})
+		if errorMap != nil {
+			continue
+		}
+
+		errorMap = usecase.UserRepository.CheckUsernameUniqueWithTx(ctx, tx, usernameCanonical, usernameSkeleton, "", now, map[string]string{})
+		if errorMap != nil && errorMap["username"] == "" {
+			return "", "", "", errorMap
+		}
+
+		if errorMap == nil {
+			return username, usernameCanonical, usernameSkeleton, nil
+		}
+	}
+
+	return "", "", "", map[string]string{"internal": "failed to find a free username"}
+}
+
+func (usecase *UserUsecase) CheckAccessTokenRevoked(ctx context.Context, userUUID string, sessionID string, jti string, issuedAt int64, errorMap map[string]string) map[string]string {
+	revoked, errorMap := usecase.UserRepository.IsAccessTokenRevoked(ctx, userUUID, sessionID, jti, issuedAt, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if revoked {
+		return map[string]string{"auth": "token is revoked"}
+	}
+
+	return nil
+}
+
+func (usecase *UserUsecase) GetJWKS() model.JSONWebKeySet {
+	return usecase.KeySet.JWKS()
+}
+
+func (usecase *UserUsecase) CheckUserExistance(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
+	err := usecase.UserRepository.CheckUserExistence(ctx, userUUID, errorMap)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (usecase *UserUsecase) GetUserInfo(ctx context.Context, userUUID string, errorMap map[string]string) (model.UserInfoResponse, map[string]string) {
+	user, errorMap := usecase.UserRepository.GetUserInfo(ctx, userUUID, errorMap)
+	if errorMap != nil {
+		return user, errorMap
+	}
+
+	return user, nil
+
+}
+
+func (usecase *UserUsecase) UpdateUserInfo(ctx context.Context, userUUID string, payload model.UserProfileUpdateRequest, errorMap map[string]string) (model.UserInfoResponse, map[string]string) {
+	if payload.DisplayName == nil && payload.AvatarURL == nil && payload.Bio == nil && payload.Locale == nil && payload.Timezone == nil {
+		errorMap["profile"] = "at least one field is required to update"
+		return model.UserInfoResponse{}, errorMap
+	}
+
+	if payload.DisplayName != nil {
+		displayName := strings.TrimSpace(*payload.DisplayName)
+		if utf8.RuneCountInString(displayName) > 50 {
+			errorMap["display_name"] = "display name must be at most 50 characters"
+		} else if helper.ContainsControl(displayName, false) {
+			errorMap["display_name"] = "display name contains invalid characters"
+		}
+		payload.DisplayName = &displayName
+	}
+
+	if payload.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*payload.AvatarURL)
+		if len(avatarURL) > 2048 {
+			errorMap["avatar_url"] = "avatar url must be at most 2048 characters"
+		} else if avatarURL != "" && !helper.IsValidAvatarURL(avatarURL) {
+			errorMap["avatar_url"] = "avatar url must be an absolute https url"
+		}
+		payload.AvatarURL = &avatarURL
+	}
+
+	if payload.Bio != nil {
+		bio := strings.TrimSpace(strings.ReplaceAll(*payload.Bio, "\r\n", "\n"))
+		if utf8.RuneCountInString(bio) > 500 {
+			errorMap["bio"] = "bio must be at most 500 characters"
+		} else if helper.ContainsControl(bio, true) {
+			errorMap["bio"] = "bio contains invalid characters"
+		}
+		payload.Bio = &bio
+	}
+
+	if payload.Locale != nil && *payload.Locale != "" {
+		locale, ok := helper.NormalizeLocale(*payload.Locale)
+		if !ok || len(locale) > 35 {
+			errorMap["locale"] = "locale must be a valid language tag such as en-US"
+		}
+		payload.Locale = &locale
+	}
+
+	if payload.Timezone != nil && *payload.Timezone != "" {
+		if len(*payload.Timezone) > 64 || !helper.IsValidTimezone(*payload.Timezone) {
+			errorMap["timezone"] = "timezone must be a valid IANA time zone such as Asia/Jakarta"
+		}
+	}
+
+	if len(errorMap) > 0 {
+		return model.UserInfoResponse{}, errorMap
+	}
+
+	errorMap = usecase.UserRepository.UpdateProfile(ctx, userUUID, payload, time.Now(), errorMap)
+	if errorMap != nil {
+		return model.UserInfoResponse{}, errorMap
+	}
+
+	return usecase.UserRepository.GetUserInfo(ctx, userUUID, map[string]string{})
+}
+
+func (usecase *UserUsecase) SearchUsers(ctx context.Context, userUUID string, search string, cursor string, limit int, errorMap map[string]string) (model.UserSearchResponse, map[string]string) {
+	response := model.UserSearchResponse{}
+
+	search = strings.TrimSpace(search)
+	if utf8.RuneCountInString(search) > 50 {
+		errorMap["q"] = "search query must be at most 50 characters"
+		return response, errorMap
+	}
+
+	if limit <= 0 || limit > 50 {
+		limit = 20
+	}
+
+	afterUsername := ""
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			errorMap["cursor"] = "cursor is invalid"
+			return response, errorMap
+		}
+		afterUsername = string(decoded)
+	}
+
+	// one extra row tells whether another page exists without a count query
+	users, errorMap := usecase.UserRepository.SearchUsers(ctx, userUUID, search, afterUsername, limit+1, errorMap)
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor := base64.RawURLEncoding.EncodeToString([]byte(users[limit-1].Username))
+		response.NextCursor = &nextCursor
+		response.HasMore = true
+	}
+
+	response.Users = users
+
+	return response, nil
+}
+
+// cancelAccountDeletion runs on every completed sign in, coming back during the grace period keeps the account.
+func (usecase *UserUsecase) cancelAccountDeletion(ctx context.Context, tx pgx.Tx, userUUID string) map[string]string {
+	canceled, errorMap := usecase.UserRepository.CancelAccountDeletionWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if canceled {
+		usecase.Log.Info("account deletion canceled", zap.String("event", "account_deletion_canceled"), zap.String("user_id", userUUID))
+	}
+
+	return nil
+}
+
+// DeleteAccount schedules the account for deletion after the grace period and signs it out everywhere.
+// The password, and the second factor when one is enabled, are asked for again since this can't be undone
+// once the purge has run. Accounts made through single sign-on never saw their password, so leaving it out is
+// accepted when the current session was signed into within ACCOUNT_DELETION_REAUTH_WINDOW instead.
+func (usecase *UserUsecase) DeleteAccount(ctx context.Context, userUUID string, sessionID string, payload model.AccountDeleteRequest, errorMap map[string]string) (model.AccountDeletionResponse, map[string]string) {
+	response := model.AccountDeletionResponse{}
+
+	if len(payload.Password) > 128 {
+		errorMap["password"] = "password must be at most 128 characters"
+		return response, errorMap
+	}
+
+	now := time.Now()
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return response, errorMap
+	}
+
+	if payload.Password == "" {
+		signedInAt, errorMap := usecase.UserRepository.GetSessionStartedAtWithTx(ctx, tx, userUUID, sessionID, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return response, errorMap
+		}
+
+		reauthWindow := helper.ConfigDuration(usecase.Config, "ACCOUNT_DELETION_REAUTH_WINDOW", 10*time.Minute)
+		if signedInAt.Add(reauthWindow).Before(now) {
+			_ = tx.Rollback(ctx)
+			return response, map[string]string{"password": "password is required unless you signed in again within the last " + reauthWindow.String()}
+		}
+	} else {
+		passwordHash, errorMap := usecase.UserRepository.GetPasswordWithTx(ctx, tx, userUUID, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return response, errorMap
+		}
+
+		match, _, err := helper.VerifyPassword(payload.Password, passwordHash, usecase.passwordParams())
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			usecase.Log.Error("failed to verify password", zap.String("user_id", userUUID), zap.Error(err))
+			return response, map[string]string{"internal": "failed to verify password"}
+		}
+
+		if !match {
+			_ = tx.Rollback(ctx)
+			return response, map[string]string{"password": "wrong password"}
+		}
+	}
+
+	state, errorMap := usecase.UserRepository.GetTotpStateWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	if state.Enabled_at != nil {
+		errorMap = usecase.verifySecondFactor(ctx, tx, state, payload.Code, payload.RecoveryCode, now)
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return response, errorMap
+		}
+	}
+
+	scheduledAt := now.Add(helper.ConfigDuration(usecase.Config, "ACCOUNT_DELETION_GRACE_PERIOD", 720*time.Hour))
+
+	errorMap = usecase.UserRepository.ScheduleAccountDeletionWithTx(ctx, tx, userUUID, now, scheduledAt, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return response, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return response, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	errorMap = usecase.revokeAllSessions(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return response, errorMap
+	}
+
+	usecase.Log.Info("account deletion scheduled", zap.String("event", "account_deletion_scheduled"), zap.String("user_id", userUUID),
+		zap.Time("scheduled_at", scheduledAt))
+
+	response.DeletionScheduledAt = scheduledAt
+
+	return response, nil
+}
+
+// RunAccountPurge purges due accounts every ACCOUNT_PURGE_INTERVAL until ctx is done.
+func (usecase *UserUsecase) RunAccountPurge(ctx context.Context) {
+	ticker := time.NewTicker(helper.ConfigDuration(usecase.Config, "ACCOUNT_PURGE_INTERVAL", time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			errorMap := usecase.PurgeDueAccounts(ctx)
+			if errorMap != nil {
+				usecase.Log.Error("failed to purge deleted accounts", zap.Any("errors", errorMap))
+			}
+		}
+	}
+}
+
+// PurgeDueAccounts erases every account whose grace period is over, a batch at a time.
+func (usecase *UserUsecase) PurgeDueAccounts(ctx context.Context) map[string]string {
+	for {
+		userIDs, errorMap := usecase.UserRepository.GetDueAccountDeletions(ctx, time.Now(), 100, map[string]string{})
+		if errorMap != nil {
+			return errorMap
+		}
+
+		purged := 0
+		for _, userID := range userIDs {
+			ok, errorMap := usecase.purgeAccount(ctx, userID)
+			if errorMap != nil {
+				return errorMap
+			}
+
+			if ok {
+				purged++
+			}
+		}
+
+		// a short batch is the last one, and one where every row was taken by someone else would just spin
+		if len(userIDs) < 100 || purged == 0 {
+			return nil
+		}
+	}
+}
+
+// purgeAccount tombstones the user's messages, takes them out of their conversations and deletes the user row.
+// The rest of the system learns about it through the account.deleted event, which carries the conversations the
+// user was in so their caches can be cleaned up without a lookup.
+func (usecase *UserUsecase) purgeAccount(ctx context.Context, userUUID string) (bool, map[string]string) {
+	now := time.Now()
+
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		return false, map[string]string{"internal": "failed to start transaction"}
+	}
+
+	due, errorMap := usecase.UserRepository.LockDueAccountDeletionWithTx(ctx, tx, userUUID, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return false, errorMap
+	}
+
+	if !due {
+		_ = tx.Rollback(ctx)
+		return false, nil
+	}
+
+	conversationIDs, errorMap := usecase.UserRepository.GetConversationIDsWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return false, errorMap
+	}
+
+	errorMap = usecase.UserRepository.AnonymizeMessagesWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return false, errorMap
+	}
+
+	errorMap = usecase.UserRepository.HandOverConversationParticipationWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return false, errorMap
+	}
+
+	exportFilePaths, errorMap := usecase.UserRepository.GetDataExportFilePathsWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return false, errorMap
+	}
+
+	errorMap = usecase.UserRepository.DeleteUserWithTx(ctx, tx, userUUID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return false, errorMap
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	// the export cleanup only finds archives through their rows, which are gone now
+	for _, filePath := range exportFilePaths {
+		err = os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			usecase.Log.Error("failed to remove data export of deleted account", zap.String("user_id", userUUID), zap.String("file_path", filePath), zap.Error(err))
+		}
+	}
+
+	errorMap = usecase.UserRepository.RevokeUserAccessTokens(ctx, userUUID, now, accessTokenLifetime, map[string]string{})
+	if errorMap != nil {
+		usecase.Log.Warn("failed to revoke access tokens of deleted account", zap.String("user_id", userUUID))
+	}
+
+	conversations := make([]string, 0, len(conversationIDs))
+	for _, conversationID := range conversationIDs {
+		conversations = append(conversations, strconv.Itoa(conversationID))
+	}
+
+	err = usecase.EventRepository.Publish(ctx, model.UserEvent{
+		Type:         "account.deleted",
+		UserID:       userUUID,
+		RecipientIDs: []string{userUUID},
+		Data: map[string]string{
+			"conversation_ids": strings.Join(conversations, ","),
+		},
+		OccurredAt: now,
+	})
+	if err != nil {
+		usecase.Log.Error("failed to publish account deleted event", zap.String("user_id", userUUID), zap.Error(err))
+	}
+
+	usecase.Log.Info("account purged", zap.String("event", "account_purged"), zap.String("user_id", userUUID))
+
+	return true, nil
 }
@@ -0,0 +1,446 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/helper"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/oidctest"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testOidcClientID = "mychat"
+
+// reachedDatabase is what CompleteOidcLogin returns once the ID token is accepted, the test pool has nothing
+// listening behind it.
+var reachedDatabase = map[string]string{"internal": "failed to start transaction"}
+
+func newTestUserUsecase(t *testing.T, oidcProvider *helper.OidcProvider, values map[string]interface{}) *UserUsecase {
+	t.Helper()
+
+	config := map[string]interface{}{
+		"ARGON2_MEMORY_KIB": 1024,
+		"ARGON2_ITERATIONS": 1,
+	}
+	for key, value := range values {
+		config[key] = value
+	}
+
+	db, err := pgxpool.New(context.Background(), "postgres://mychat@127.0.0.1:1/mychat?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	log := zap.NewNop()
+
+	return NewUserUsecase(repository.NewUserRepository(log, db, newTestRedis(t)), nil, nil, db, log, newTestConfig(t, config), nil, nil, oidcProvider)
+}
+
+func newTestOidcUsecase(t *testing.T) (*UserUsecase, *oidctest.Provider) {
+	t.Helper()
+
+	standIn, err := oidctest.New(testOidcClientID)
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	t.Cleanup(standIn.Close)
+
+	oidcProvider := helper.NewOidcProvider(standIn.Issuer, testOidcClientID, "", "http://localhost:4200/oidc/callback", []string{"openid", "email"})
+
+	return newTestUserUsecase(t, oidcProvider, nil), standIn
+}
+
+// signInAtProvider starts a login and has the stand-in provider approve it, the returned payload is the
+// callback the browser would bring back.
+func signInAtProvider(t *testing.T, usecase *UserUsecase, standIn *oidctest.Provider, claims map[string]interface{}) model.OidcCallbackRequest {
+	t.Helper()
+
+	authorizationURL, errorMap := usecase.StartOidcLogin(context.Background(), "laptop", map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("failed to start login: %v", errorMap)
+	}
+
+	code, state, err := standIn.Authorize(authorizationURL, claims)
+	if err != nil {
+		t.Fatalf("authorization rejected: %v", err)
+	}
+
+	return model.OidcCallbackRequest{Code: code, State: state}
+}
+
+func completeOidcLogin(usecase *UserUsecase, payload model.OidcCallbackRequest) map[string]string {
+	_, _, errorMap := usecase.CompleteOidcLogin(context.Background(), payload, model.ClientInfo{Ip_address: "127.0.0.1"}, map[string]string{})
+	return errorMap
+}
+
+func TestCompleteOidcLoginAcceptsValidIDToken(t *testing.T) {
+	usecase, standIn := newTestOidcUsecase(t)
+
+	errorMap := completeOidcLogin(usecase, signInAtProvider(t, usecase, standIn, nil))
+	if !reflect.DeepEqual(errorMap, reachedDatabase) {
+		t.Fatalf("errorMap = %v, expected the login to reach the database", errorMap)
+	}
+}
+
+func TestCompleteOidcLoginRejectsReusedState(t *testing.T) {
+	usecase, standIn := newTestOidcUsecase(t)
+	payload := signInAtProvider(t, usecase, standIn, nil)
+
+	errorMap := completeOidcLogin(usecase, payload)
+	if !reflect.DeepEqual(errorMap, reachedDatabase) {
+		t.Fatalf("errorMap = %v, expected the login to reach the database", errorMap)
+	}
+
+	errorMap = completeOidcLogin(usecase, payload)
+	if errorMap["state"] == "" {
+		t.Fatalf("errorMap = %v, expected the reused state to be rejected", errorMap)
+	}
+}
+
+func TestCompleteOidcLoginSpendsStateOnProviderError(t *testing.T) {
+	usecase, standIn := newTestOidcUsecase(t)
+	payload := signInAtProvider(t, usecase, standIn, nil)
+
+	errorMap := completeOidcLogin(usecase, model.OidcCallbackRequest{State: payload.State, Error: "access_denied"})
+	if errorMap["oidc"] == "" {
+		t.Fatalf("errorMap = %v, expected the denied sign in to be reported", errorMap)
+	}
+
+	errorMap = completeOidcLogin(usecase, payload)
+	if errorMap["state"] == "" {
+		t.Fatalf("errorMap = %v, expected the spent state to be rejected", errorMap)
+	}
+}
+
+func TestCompleteOidcLoginRejectsInvalidIDToken(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"nonce mismatch": {"nonce": "other"},
+		"wrong issuer":   {"iss": "https://attacker.example"},
+		"wrong audience": {"aud": "other"},
+		"expired":        {"exp": time.Now().Add(-5 * time.Minute).Unix()},
+	}
+
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			usecase, standIn := newTestOidcUsecase(t)
+
+			errorMap := completeOidcLogin(usecase, signInAtProvider(t, usecase, standIn, claims))
+			if errorMap["oidc"] != "identity provider returned an invalid id token" {
+				t.Fatalf("errorMap = %v, expected the id token to be rejected", errorMap)
+			}
+		})
+	}
+}
+
+// the verifier is only known to the login that started the flow, so a code can't be redeemed through another one
+func TestCompleteOidcLoginForwardsCodeVerifier(t *testing.T) {
+	usecase, standIn := newTestOidcUsecase(t)
+
+	first := signInAtProvider(t, usecase, standIn, nil)
+	second := signInAtProvider(t, usecase, standIn, nil)
+
+	errorMap := completeOidcLogin(usecase, model.OidcCallbackRequest{Code: first.Code, State: second.State})
+	if errorMap["oidc"] != "failed to exchange the authorization code" {
+		t.Fatalf("errorMap = %v, expected the code exchange to fail", errorMap)
+	}
+
+	third := signInAtProvider(t, usecase, standIn, nil)
+
+	errorMap = completeOidcLogin(usecase, third)
+	if !reflect.DeepEqual(errorMap, reachedDatabase) {
+		t.Fatalf("errorMap = %v, expected the login to reach the database", errorMap)
+	}
+
+	verifiers := standIn.CodeVerifiers()
+	if len(verifiers) != 2 || verifiers[0] == "" || verifiers[0] == verifiers[1] {
+		t.Fatalf("token endpoint got verifiers %v, expected one per login", verifiers)
+	}
+}
+
+// fakeOidcTx answers the queries resolveOidcUserWithTx makes and remembers what it was asked.
+type fakeOidcTx struct {
+	pgx.Tx
+	identity       *model.UserIdentity
+	verifiedEmails map[string]string
+	queries        []string
+	execs          []fakeExec
+}
+
+type fakeExec struct {
+	sql  string
+	args []interface{}
+}
+
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (row fakeRow) Scan(dest ...interface{}) error {
+	if row.err != nil {
+		return row.err
+	}
+
+	if len(dest) != len(row.values) {
+		return fmt.Errorf("scanning %d columns into %d destinations", len(row.values), len(dest))
+	}
+
+	for i, value := range row.values {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+
+	return nil
+}
+
+func (tx *fakeOidcTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	tx.queries = append(tx.queries, sql)
+
+	switch {
+	case strings.Contains(sql, "FROM user_identities"):
+		if tx.identity == nil {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		identity := tx.identity
+		return fakeRow{values: []interface{}{identity.Issuer, identity.Subject, identity.User_id, identity.Email, identity.Created_at, identity.Last_login_at}}
+	case strings.Contains(sql, "email_verified_at IS NOT NULL"):
+		userUUID, ok := tx.verifiedEmails[args[0].(string)]
+		if !ok {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{values: []interface{}{userUUID, "existing", args[0].(string)}}
+	case strings.Contains(sql, "SELECT EXISTS(SELECT 1 FROM users WHERE email=$1)"):
+		_, ok := tx.verifiedEmails[args[0].(string)]
+		return fakeRow{values: []interface{}{ok}}
+	case strings.Contains(sql, "username_history"):
+		return fakeRow{values: []interface{}{false}}
+	default:
+		return fakeRow{err: errors.New("unexpected query")}
+	}
+}
+
+func (tx *fakeOidcTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.execs = append(tx.execs, fakeExec{sql: sql, args: args})
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (tx *fakeOidcTx) queried(fragment string) bool {
+	for _, sql := range tx.queries {
+		if strings.Contains(sql, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *fakeOidcTx) exec(prefix string) *fakeExec {
+	for i := range tx.execs {
+		if strings.HasPrefix(tx.execs[i].sql, prefix) {
+			return &tx.execs[i]
+		}
+	}
+	return nil
+}
+
+func newTestOidcClaims(email string, emailVerified interface{}) *helper.OidcClaims {
+	claims := &helper.OidcClaims{Email: email, EmailVerified: emailVerified, PreferredUsername: "oidc_user"}
+	claims.Issuer = "https://idp.example"
+	claims.Subject = "subject"
+	return claims
+}
+
+func TestResolveOidcUserWithTx(t *testing.T) {
+	const existingUserUUID = "5f0c8a8e-8d8e-4f6a-9a57-3a3c1e0b7a11"
+
+	tests := map[string]struct {
+		claims       *helper.OidcClaims
+		config       map[string]interface{}
+		identity     *model.UserIdentity
+		expectLinked bool
+		expectNew    bool
+		expectError  string
+	}{
+		"known identity": {
+			claims:       newTestOidcClaims("", nil),
+			identity:     &model.UserIdentity{Issuer: "https://idp.example", Subject: "subject", User_id: existingUserUUID},
+			expectLinked: true,
+		},
+		"verified email links the account": {
+			claims:       newTestOidcClaims("Owner@Example.com", true),
+			expectLinked: true,
+		},
+		"verified email sent as a string": {
+			claims:       newTestOidcClaims("owner@example.com", "true"),
+			expectLinked: true,
+		},
+		"unverified email registers a new account": {
+			claims:    newTestOidcClaims("owner@example.com", false),
+			expectNew: true,
+		},
+		"unverified email with auto register off": {
+			claims:      newTestOidcClaims("owner@example.com", false),
+			config:      map[string]interface{}{"OIDC_AUTO_REGISTER": false},
+			expectError: "no account is linked to this identity",
+		},
+		"linking by email turned off": {
+			claims:      newTestOidcClaims("owner@example.com", true),
+			config:      map[string]interface{}{"OIDC_LINK_BY_EMAIL": false, "OIDC_AUTO_REGISTER": false},
+			expectError: "no account is linked to this identity",
+		},
+		"unknown email with auto register off": {
+			claims:      newTestOidcClaims("stranger@example.com", true),
+			config:      map[string]interface{}{"OIDC_AUTO_REGISTER": false},
+			expectError: "no account is linked to this identity",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			usecase := newTestUserUsecase(t, nil, test.config)
+			tx := &fakeOidcTx{
+				identity:       test.identity,
+				verifiedEmails: map[string]string{"owner@example.com": existingUserUUID},
+			}
+
+			userUUID, errorMap := usecase.resolveOidcUserWithTx(context.Background(), tx, test.claims, time.Now())
+
+			if test.expectError != "" {
+				if errorMap["oidc"] != test.expectError {
+					t.Fatalf("errorMap = %v, expected %q", errorMap, test.expectError)
+				}
+				if len(tx.execs) != 0 {
+					t.Fatalf("rejected identity still wrote %q", tx.execs[0].sql)
+				}
+				return
+			}
+
+			if errorMap != nil {
+				t.Fatalf("failed to resolve user: %v", errorMap)
+			}
+
+			if !test.claims.IsEmailVerified() && tx.queried("email_verified_at IS NOT NULL") {
+				t.Fatal("an unverified address was used to look up an account")
+			}
+
+			register := tx.exec("INSERT INTO users")
+			if test.expectLinked && (userUUID != existingUserUUID || register != nil) {
+				t.Fatalf("resolved to %q, expected the existing account %q", userUUID, existingUserUUID)
+			}
+			if test.expectNew {
+				if register == nil || userUUID == existingUserUUID || register.args[0] != userUUID {
+					t.Fatalf("resolved to %q, expected a newly registered account", userUUID)
+				}
+				if register.args[5] != "" {
+					t.Fatalf("new account took the unverified address %q", register.args[5])
+				}
+			}
+
+			if test.identity == nil {
+				link := tx.exec("INSERT INTO user_identities")
+				if link == nil || link.args[2] != userUUID {
+					t.Fatal("identity was not linked to the resolved account")
+				}
+			}
+		})
+	}
+}
+
+func newTestKeySet(t *testing.T) *helper.KeySet {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	return &helper.KeySet{
+		ActiveKid: "test",
+		Keys: map[string]helper.SigningKey{
+			"test": {Kid: "test", Method: jwt.SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: publicKey},
+		},
+	}
+}
+
+func TestLogoutWithExpiredAccessTokenKeepsSession(t *testing.T) {
+	usecase := newTestUserUsecase(t, nil, nil)
+	usecase.KeySet = newTestKeySet(t)
+	ctx := context.Background()
+
+	accessToken, err := usecase.KeySet.Sign(jwt.MapClaims{
+		"id":  "5f0c8a8e-8d8e-4f6a-9a57-3a3c1e0b7a11",
+		"sid": "session",
+		"jti": "jti",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("failed to sign access token: %v", err)
+	}
+
+	errorMap := usecase.Logout(ctx, accessToken, "", model.ClientInfo{}, map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("logout failed: %v", errorMap)
+	}
+
+	revoked, err := usecase.UserRepository.DBCache.Exists(ctx, "session:session:revoked").Result()
+	if err != nil {
+		t.Fatalf("failed to read redis: %v", err)
+	}
+	if revoked != 0 {
+		t.Fatal("an expired access token on its own revoked its session")
+	}
+}
+
+func TestAllowMailRequestCapsEachSubject(t *testing.T) {
+	usecase := newTestUserUsecase(t, nil, map[string]interface{}{"MAIL_REQUEST_MAX": 2, "MAIL_REQUEST_IP_MAX": 2})
+	ctx := context.Background()
+	client := model.ClientInfo{Ip_address: "203.0.113.7"}
+
+	for i, expected := range []bool{true, true, false} {
+		allowed, errorMap := usecase.allowMailRequest(ctx, "password_reset", "", "Owner@Example.com", client)
+		if errorMap != nil {
+			t.Fatalf("failed to count request: %v", errorMap)
+		}
+		if allowed != expected {
+			t.Fatalf("request %d allowed = %v, expected %v", i+1, allowed, expected)
+		}
+	}
+
+	// the address is counted the same however it is spelled, the username is its own subject
+	allowed, _ := usecase.allowMailRequest(ctx, "password_reset", "", "owner@example.com", model.ClientInfo{})
+	if allowed {
+		t.Fatal("a differently spelled address got around the cap")
+	}
+
+	allowed, _ = usecase.allowMailRequest(ctx, "magic_link", "owner", "", model.ClientInfo{})
+	if !allowed {
+		t.Fatal("the cap of one purpose was applied to another")
+	}
+
+	// the refused request wasn't counted against the ip, this one is its third and goes over whatever it asks for
+	allowed, _ = usecase.allowMailRequest(ctx, "password_reset", "someone", "", client)
+	if allowed {
+		t.Fatal("the ip cap was not applied")
+	}
+}
+
+// the account is looked up after the answer, so the answer can't depend on whether it exists
+func TestForgotPasswordAnswersBeforeLookingUpTheAccount(t *testing.T) {
+	usecase := newTestUserUsecase(t, nil, nil)
+
+	errorMap := usecase.ForgotPassword(context.Background(), model.PasswordForgotRequest{Username: "someone"}, model.ClientInfo{}, map[string]string{})
+	if errorMap != nil {
+		t.Fatalf("errorMap = %v, expected the request to be answered without the database", errorMap)
+	}
+}
@@ -0,0 +1,190 @@
+// Package webauthntest is a software authenticator for exercising the WebAuthn ceremonies in tests. It speaks
+// the same wire format as a browser would hand us, but every field can be bent to produce a bad response.
+package webauthntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/ferdian3456/mychat/backend/user-service/internal/model"
+)
+
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+)
+
+const (
+	FlagUserPresent  byte = 0x01
+	FlagUserVerified byte = 0x04
+	FlagAttestedData byte = 0x40
+)
+
+// Authenticator holds one credential. RPID and Origin are what it claims in its responses and Flags what it
+// reports, tests change them to get responses a real authenticator wouldn't send.
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	Flags        byte
+	SignCount    uint32
+	Algorithm    int64
+	CredentialID []byte
+
+	signer crypto.Signer
+}
+
+// New creates an authenticator with a fresh ES256 or Ed25519 key that verifies the user on every ceremony.
+func New(algorithm int64, rpID string, origin string) (*Authenticator, error) {
+	var signer crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %d", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := make([]byte, 16)
+	_, err = rand.Read(credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		Flags:        FlagUserPresent | FlagUserVerified,
+		Algorithm:    algorithm,
+		CredentialID: credentialID,
+		signer:       signer,
+	}, nil
+}
+
+// ClientDataJSON is what the browser would collect for the ceremony, type is webauthn.create or webauthn.get.
+func (authenticator *Authenticator) ClientDataJSON(ceremonyType string, challenge string) []byte {
+	clientDataJSON, _ := sonic.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    authenticator.Origin,
+	})
+
+	return clientDataJSON
+}
+
+// COSEKey is the credential public key in the COSE encoding carried by attested credential data.
+func (authenticator *Authenticator) COSEKey() []byte {
+	switch publicKey := authenticator.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		publicKey.X.FillBytes(x)
+		publicKey.Y.FillBytes(y)
+
+		return EncodeCBOR(Map{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+	case ed25519.PublicKey:
+		return EncodeCBOR(Map{{1, 1}, {3, AlgEdDSA}, {-1, 6}, {-2, []byte(publicKey)}})
+	default:
+		return nil
+	}
+}
+
+// AuthenticatorData builds the authenticator data for the current RPID, Flags and SignCount. With attested set
+// it carries the credential id and public key, as in a registration.
+func (authenticator *Authenticator) AuthenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(authenticator.RPID))
+
+	flags := authenticator.Flags
+	if attested {
+		flags |= FlagAttestedData
+	}
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, authenticator.SignCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(authenticator.CredentialID)))
+		data = append(data, authenticator.CredentialID...)
+		data = append(data, authenticator.COSEKey()...)
+	}
+
+	return data
+}
+
+// AttestationObject wraps authenticator data in a "none" attestation.
+func (authenticator *Authenticator) AttestationObject(authenticatorData []byte) []byte {
+	return EncodeCBOR(Map{{"fmt", "none"}, {"attStmt", Map{}}, {"authData", authenticatorData}})
+}
+
+// Sign signs authenticatorData followed by the SHA-256 of clientDataJSON, as an assertion does.
+func (authenticator *Authenticator) Sign(authenticatorData []byte, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+
+	var signature []byte
+	switch signer := authenticator.signer.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		signature, _ = ecdsa.SignASN1(rand.Reader, signer, digest[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(signer, signed)
+	}
+
+	return signature
+}
+
+// Create answers navigator.credentials.create for the challenge.
+func (authenticator *Authenticator) Create(challenge string) model.PublicKeyCredential {
+	clientDataJSON := authenticator.ClientDataJSON("webauthn.create", challenge)
+	attestationObject := authenticator.AttestationObject(authenticator.AuthenticatorData(true))
+
+	return model.PublicKeyCredential{
+		Id:    encode(authenticator.CredentialID),
+		RawId: encode(authenticator.CredentialID),
+		Type:  "public-key",
+		Response: model.PublicKeyCredentialResponse{
+			ClientDataJSON:    encode(clientDataJSON),
+			AttestationObject: encode(attestationObject),
+			Transports:        []string{"internal"},
+		},
+	}
+}
+
+// Get answers navigator.credentials.get for the challenge, counting the signature first like a hardware key.
+// userHandle is the user id the credential was created for, empty leaves it out.
+func (authenticator *Authenticator) Get(challenge string, userHandle string) model.PublicKeyCredential {
+	authenticator.SignCount++
+
+	clientDataJSON := authenticator.ClientDataJSON("webauthn.get", challenge)
+	authenticatorData := authenticator.AuthenticatorData(false)
+
+	return model.PublicKeyCredential{
+		Id:    encode(authenticator.CredentialID),
+		RawId: encode(authenticator.CredentialID),
+		Type:  "public-key",
+		Response: model.PublicKeyCredentialResponse{
+			ClientDataJSON:    encode(clientDataJSON),
+			AuthenticatorData: encode(authenticatorData),
+			Signature:         encode(authenticator.Sign(authenticatorData, clientDataJSON)),
+			UserHandle:        encode([]byte(userHandle)),
+		},
+	}
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
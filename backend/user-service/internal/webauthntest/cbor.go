@@ -0,0 +1,74 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Map is a CBOR map that keeps its entries in the order given, which is all authenticators need.
+type Map []Entry
+
+type Entry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// EncodeCBOR encodes ints, int64s, strings, byte strings, bools, slices and Maps. It is written apart from the
+// decoder under test so the two don't share mistakes.
+func EncodeCBOR(value interface{}) []byte {
+	switch value := value.(type) {
+	case int:
+		return encodeCBORInt(int64(value))
+	case int64:
+		return encodeCBORInt(value)
+	case bool:
+		if value {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case []byte:
+		return append(encodeCBORHead(2, uint64(len(value))), value...)
+	case string:
+		return append(encodeCBORHead(3, uint64(len(value))), value...)
+	case []interface{}:
+		data := encodeCBORHead(4, uint64(len(value)))
+		for _, item := range value {
+			data = append(data, EncodeCBOR(item)...)
+		}
+		return data
+	case Map:
+		data := encodeCBORHead(5, uint64(len(value)))
+		for _, entry := range value {
+			data = append(data, EncodeCBOR(entry.Key)...)
+			data = append(data, EncodeCBOR(entry.Value)...)
+		}
+		return data
+	default:
+		panic(fmt.Sprintf("webauthntest: can't encode %T", value))
+	}
+}
+
+func encodeCBORInt(value int64) []byte {
+	if value < 0 {
+		return encodeCBORHead(1, uint64(-1-value))
+	}
+
+	return encodeCBORHead(0, uint64(value))
+}
+
+func encodeCBORHead(majorType byte, argument uint64) []byte {
+	head := majorType << 5
+
+	switch {
+	case argument < 24:
+		return []byte{head | byte(argument)}
+	case argument <= 0xff:
+		return []byte{head | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{head | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{head | 26}, uint32(argument))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{head | 27}, argument)
+	}
+}
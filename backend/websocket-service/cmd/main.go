@@ -44,13 +44,19 @@ func main() {
 	postgresql := config.NewPostgresqlPool(koanf, zap)
 	kafkaProducer := config.NewKafkaProducer(koanf, zap)
 	kafkaConsumer := config.NewKafkaConsumer(koanf, zap)
+	jwks := config.NewJWKSCache(koanf, zap)
 
 	defer kafkaProducer.Close()
 	defer kafkaConsumer.Close()
 	defer postgresql.Close()
 	defer rdb.Close()
 
+	// background workers run until a stop signal arrives
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
 	config.Server(&config.ServerConfig{
+		Context:       workerCtx,
 		Router:        httprouter,
 		DB:            postgresql,
 		DBCache:       rdb,
@@ -58,6 +64,7 @@ func main() {
 		Config:        koanf,
 		KafkaProducer: kafkaProducer,
 		KafkaConsumer: kafkaConsumer,
+		JWKS:          jwks,
 	})
 
 	//httprouter.POST("/api/conversation", handlers.AuthMiddleware(handlers.CreateConversation))
@@ -90,6 +97,7 @@ func main() {
 
 	<-stop
 	zap.Info("Got one of stop signals")
+	stopWorkers()
 
 	if err := server.Shutdown(ctx); err != nil {
 		zap.Warn("Timeout, forced kill!", zapLog.Error(err))
@@ -15,6 +15,7 @@ require (
 	github.com/knadh/koanf/v2 v2.2.2
 	github.com/redis/go-redis/v9 v9.11.0
 	go.uber.org/zap v1.27.0
+	golang.org/x/text v0.24.0
 )
 
 require (
@@ -38,5 +39,4 @@ require (
 	golang.org/x/crypto v0.37.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
 )
@@ -1,10 +1,12 @@
 package config
 
 import (
+	"context"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ferdian3456/mychat/backend/websocket-service/internal/delivery/http"
 	"github.com/ferdian3456/mychat/backend/websocket-service/internal/delivery/http/middleware"
 	"github.com/ferdian3456/mychat/backend/websocket-service/internal/delivery/http/route"
+	"github.com/ferdian3456/mychat/backend/websocket-service/internal/helper"
 	"github.com/ferdian3456/mychat/backend/websocket-service/internal/repository"
 	"github.com/ferdian3456/mychat/backend/websocket-service/internal/usecase"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -15,6 +17,7 @@ import (
 )
 
 type ServerConfig struct {
+	Context       context.Context
 	Router        *httprouter.Router
 	DB            *pgxpool.Pool
 	DBCache       *redis.ClusterClient
@@ -22,6 +25,7 @@ type ServerConfig struct {
 	Config        *koanf.Koanf
 	KafkaProducer *kafka.Producer
 	KafkaConsumer *kafka.Consumer
+	JWKS          *helper.JWKSCache
 }
 
 func Server(config *ServerConfig) {
@@ -29,7 +33,7 @@ func Server(config *ServerConfig) {
 	chatUsecase := usecase.NewChatUsecase(chatRepository, config.DB, config.Log, config.Config)
 	chatController := http.NewChatController(chatUsecase, config.Log, config.Config)
 
-	authMiddleware := middleware.NewAuthMiddleware(config.Router, config.Log, config.Config, chatUsecase)
+	authMiddleware := middleware.NewAuthMiddleware(config.Router, config.Log, config.Config, chatUsecase, config.JWKS)
 
 	routeConfig := route.RouteConfig{
 		Router:         config.Router,
@@ -38,4 +42,6 @@ func Server(config *ServerConfig) {
 	}
 
 	routeConfig.SetupRoute()
+
+	go chatUsecase.ConsumeUserEvents(config.Context)
 }
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"context"
+	"github.com/ferdian3456/mychat/backend/websocket-service/internal/helper"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+	"time"
+)
+
+func NewJWKSCache(config *koanf.Koanf, log *zap.Logger) *helper.JWKSCache {
+	jwksURL := config.String("JWKS_URL")
+	if jwksURL == "" {
+		log.Fatal("JWKS_URL is not set")
+	}
+
+	ttl := 5 * time.Minute
+	if config.Exists("JWKS_CACHE_TTL") {
+		ttl = config.Duration("JWKS_CACHE_TTL")
+	}
+
+	cache := helper.NewJWKSCache(jwksURL, ttl)
+
+	// user-service may come up after us, the keys are fetched again on the first request
+	err := cache.Refresh(context.Background())
+	if err != nil {
+		log.Warn("Failed to fetch jwks", zap.Error(err))
+	}
+
+	return cache
+}
@@ -40,6 +40,7 @@ func (controller ChatController) GetMessage(writer http.ResponseWriter, request
 
 	errorMap := map[string]string{}
 
+	userUUID, _ := ctx.Value("user_uuid").(string)
 	conversationID, _ := strconv.Atoi(params.ByName("id"))
 	beforeIDStr := request.URL.Query().Get("before_id")
 	limitStr := request.URL.Query().Get("limit")
@@ -50,11 +51,14 @@ func (controller ChatController) GetMessage(writer http.ResponseWriter, request
 		limit = l
 	}
 
-	response, errorMap := controller.ChatUsecase.GetMessage(ctx, conversationID, beforeIDStr, limit, errorMap)
+	response, errorMap := controller.ChatUsecase.GetMessage(ctx, userUUID, conversationID, beforeIDStr, limit, errorMap)
 	if errorMap != nil {
 		if errorMap["internal"] != "" {
 			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 			return
+		} else if errorMap["conversation_id"] == "conversation not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
 		} else {
 			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
 			return
@@ -73,8 +77,8 @@ func (controller ChatController) CreateConversation(writer http.ResponseWriter,
 	var payload model.UserAddConversationRequest
 	helper.ReadFromRequestBody(request, &payload)
 
-	response, err := controller.ChatUsecase.CreateConversation(ctx, payload, userUUID, errorMap)
-	if err != nil {
+	response, errorMap := controller.ChatUsecase.CreateConversation(ctx, payload, userUUID, errorMap)
+	if errorMap != nil {
 		if errorMap["internal"] != "" {
 			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 			return
@@ -164,6 +168,18 @@ func (controller ChatController) WebSocket(writer http.ResponseWriter, request *
 					return // channel closed
 				}
 				//fmt.Println(msg.Payload)
+				if helper.IsAccountDeletedEvent(msg.Payload, userUUID) {
+					_ = connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "account deleted"))
+					_ = connection.Close()
+					return
+				}
+
+				if helper.IsAccountSuspendedEvent(msg.Payload, userUUID) {
+					_ = connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(helper.CloseAccountSuspended, "account suspended"))
+					_ = connection.Close()
+					return
+				}
+
 				if helper.MessageBelongsToUser(msg.Payload, userUUID) {
 					_ = connection.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
 				}
@@ -188,10 +204,17 @@ func (controller ChatController) WebSocket(writer http.ResponseWriter, request *
 		}
 
 		if errMap := controller.ChatUsecase.SendMessage(ctx, msg, userUUID); errMap != nil {
-			_ = connection.WriteJSON(map[string]any{
-				"status": http.StatusText(http.StatusInternalServerError),
-				"errors": map[string]string{"message": "failed to send message"},
-			})
+			if errMap["internal"] != "" {
+				_ = connection.WriteJSON(map[string]any{
+					"status": http.StatusText(http.StatusInternalServerError),
+					"errors": map[string]string{"message": "failed to send message"},
+				})
+			} else {
+				_ = connection.WriteJSON(map[string]any{
+					"status": http.StatusText(http.StatusBadRequest),
+					"errors": errMap,
+				})
+			}
 		}
 	}
 
@@ -217,3 +240,42 @@ func (controller ChatController) GetAllMyOwnConversationID(writer http.ResponseW
 
 	helper.WriteSuccessResponse(writer, response)
 }
+
+func (controller ChatController) AcceptMessageRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	controller.respondToMessageRequest(writer, request, params, controller.ChatUsecase.AcceptMessageRequest)
+}
+
+func (controller ChatController) DeclineMessageRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	controller.respondToMessageRequest(writer, request, params, controller.ChatUsecase.DeclineMessageRequest)
+}
+
+func (controller ChatController) ReportMessageRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	controller.respondToMessageRequest(writer, request, params, controller.ChatUsecase.ReportMessageRequest)
+}
+
+func (controller ChatController) respondToMessageRequest(writer http.ResponseWriter, request *http.Request, params httprouter.Params, respond func(ctx context.Context, userUUID string, conversationID int, errorMap map[string]string) map[string]string) {
+	ctx := request.Context()
+
+	errorMap := map[string]string{}
+	userUUID, _ := ctx.Value("user_uuid").(string)
+	conversationID, _ := strconv.Atoi(params.ByName("id"))
+
+	errorMap = respond(ctx, userUUID, conversationID, errorMap)
+	if errorMap != nil {
+		if errorMap["internal"] != "" {
+			helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+			return
+		} else if errorMap["conversation_id"] == "conversation not found" {
+			helper.WriteErrorResponse(writer, http.StatusNotFound, errorMap)
+			return
+		} else if errorMap["conversation_id"] == "conversation is not a pending message request" {
+			helper.WriteErrorResponse(writer, http.StatusConflict, errorMap)
+			return
+		} else {
+			helper.WriteErrorResponse(writer, http.StatusBadRequest, errorMap)
+			return
+		}
+	}
+
+	helper.WriteSuccessResponseNoData(writer)
+}
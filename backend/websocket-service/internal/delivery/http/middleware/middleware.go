@@ -9,6 +9,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/knadh/koanf/v2"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
 )
 
@@ -17,14 +18,16 @@ type AuthMiddleware struct {
 	Log         *zap.Logger
 	Config      *koanf.Koanf
 	ChatUsecase *usecase.ChatUsecase
+	JWKS        *helper.JWKSCache
 }
 
-func NewAuthMiddleware(handler http.Handler, zap *zap.Logger, koanf *koanf.Koanf, chatUsecase *usecase.ChatUsecase) *AuthMiddleware {
+func NewAuthMiddleware(handler http.Handler, zap *zap.Logger, koanf *koanf.Koanf, chatUsecase *usecase.ChatUsecase, jwks *helper.JWKSCache) *AuthMiddleware {
 	return &AuthMiddleware{
 		Handler:     handler,
 		Log:         zap,
 		Config:      koanf,
 		ChatUsecase: chatUsecase,
+		JWKS:        jwks,
 	}
 }
 
@@ -49,6 +52,9 @@ func (middleware *AuthMiddleware) WebSocketAuthMiddleware(next http.HandlerFunc)
 			if errorMap["internal"] != "" {
 				helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 				return
+			} else if errorMap["account"] == "account is suspended" {
+				helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+				return
 			} else {
 				helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
 				return
@@ -70,31 +76,14 @@ func (middleware *AuthMiddleware) AuthMiddleware(next httprouter.Handle) httprou
 
 		ctx := request.Context()
 
-		cookie, err := request.Cookie("access_token")
-
-		if err != nil {
-			if err == http.ErrNoCookie {
-				errorMap["auth"] = "no token provided"
-				helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
-				return
-			} else {
-				errorMap["auth"] = err.Error()
-				helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
-				return
-			}
+		headerToken := helper.GetAccessToken(request)
+		if headerToken == "" {
+			errorMap["auth"] = "no token provided"
+			helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+			return
 		}
 
-		headerToken := cookie.Value
-
-		secretKey := middleware.Config.String("SECRET_KEY_ACCESS_TOKEN")
-		secretKeyByte := []byte(secretKey)
-
-		token, err := jwt.Parse(headerToken, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return secretKeyByte, nil
-		})
+		token, err := jwt.Parse(headerToken, middleware.JWKS.Keyfunc, jwt.WithValidMethods([]string{"RS256", "EdDSA"}))
 
 		if err != nil {
 			if err == jwt.ErrTokenMalformed {
@@ -119,6 +108,11 @@ func (middleware *AuthMiddleware) AuthMiddleware(next httprouter.Handle) httprou
 		}
 
 		var userID string
+		var sessionID string
+		var jti string
+		var issuedAt int64
+		// tokens issued before roles existed carry no role
+		role := helper.RoleUser
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			if val, exists := claims["id"]; exists {
 				if strVal, ok := val.(string); ok {
@@ -129,13 +123,50 @@ func (middleware *AuthMiddleware) AuthMiddleware(next httprouter.Handle) httprou
 				helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
 				return
 			}
+
+			if val, ok := claims["sid"].(string); ok {
+				sessionID = val
+			}
+
+			if val, ok := claims["jti"].(string); ok {
+				jti = val
+			}
+
+			if val, exists := claims["role"]; exists {
+				strVal, ok := val.(string)
+				if !ok || !helper.IsValidRole(strVal) {
+					errorMap["auth"] = "token is invalid"
+					helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+					return
+				}
+				role = strVal
+			}
+
+			// iat is fractional, GetIssuedAt would truncate it to the second
+			if val, ok := claims["iat"].(float64); ok {
+				issuedAt = int64(math.Round(val * 1000))
+			}
+		}
+
+		errorMap = middleware.ChatUsecase.CheckAccessTokenRevoked(ctx, userID, sessionID, jti, issuedAt, errorMap)
+		if errorMap != nil {
+			if errorMap["internal"] != "" {
+				helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
+				return
+			} else {
+				helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
+				return
+			}
 		}
 
-		errorMap = middleware.ChatUsecase.CheckUserExistance(request.Context(), userID, errorMap)
+		errorMap = middleware.ChatUsecase.CheckUserExistance(request.Context(), userID, map[string]string{})
 		if errorMap != nil {
 			if errorMap["internal"] == "failed to query into database" {
 				helper.WriteErrorResponse(writer, http.StatusInternalServerError, errorMap)
 				return
+			} else if errorMap["account"] == "account is suspended" {
+				helper.WriteErrorResponse(writer, http.StatusForbidden, errorMap)
+				return
 			} else {
 				helper.WriteErrorResponse(writer, http.StatusUnauthorized, errorMap)
 				return
@@ -145,8 +176,24 @@ func (middleware *AuthMiddleware) AuthMiddleware(next httprouter.Handle) httprou
 		//middleware.Log.Debug("User:" + userID)
 
 		ctx = context.WithValue(ctx, "user_uuid", userID)
+		ctx = context.WithValue(ctx, "user_role", role)
 		request = request.WithContext(ctx)
 
 		next(writer, request.WithContext(ctx), params)
 	}
 }
+
+// RequirePermission goes inside AuthMiddleware and turns away callers whose role lacks the permission:
+// AuthMiddleware(RequirePermission(helper.PermissionUsersRead, handler)).
+func (middleware *AuthMiddleware) RequirePermission(permission string, next httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		role, _ := request.Context().Value("user_role").(string)
+
+		if !helper.HasPermission(role, permission) {
+			helper.WriteErrorResponse(writer, http.StatusForbidden, map[string]string{"permission": "you don't have permission to do this"})
+			return
+		}
+
+		next(writer, request, params)
+	}
+}
@@ -16,6 +16,9 @@ func (c *RouteConfig) SetupRoute() {
 	c.Router.GET("/api/conversation/:id/messages", c.AuthMiddleware.AuthMiddleware(c.ChatController.GetMessage))
 	c.Router.POST("/api/conversation", c.AuthMiddleware.AuthMiddleware(c.ChatController.CreateConversation))
 	c.Router.GET("/api/conversation", c.AuthMiddleware.AuthMiddleware(c.ChatController.GetAllMyOwnConversationID))
+	c.Router.POST("/api/conversation/:id/accept", c.AuthMiddleware.AuthMiddleware(c.ChatController.AcceptMessageRequest))
+	c.Router.POST("/api/conversation/:id/decline", c.AuthMiddleware.AuthMiddleware(c.ChatController.DeclineMessageRequest))
+	c.Router.POST("/api/conversation/:id/report", c.AuthMiddleware.AuthMiddleware(c.ChatController.ReportMessageRequest))
 	c.Router.GET("/api/conversation/:id/participant", c.AuthMiddleware.AuthMiddleware(c.ChatController.GetParticipantInfo))
 	c.Router.GET("/api/ws-token", c.AuthMiddleware.AuthMiddleware(c.ChatController.GetWebSocketToken))
 	c.Router.HandlerFunc("GET", "/api/ws", c.AuthMiddleware.WebSocketAuthMiddleware(c.ChatController.WebSocket))
@@ -6,6 +6,10 @@ import (
 	"hash/crc32"
 )
 
+// CloseAccountSuspended is the close code a connection gets when its account is suspended, clients should
+// not reconnect on it the way they would after a network drop.
+const CloseAccountSuspended = 4003
+
 func GetBucketForUser(userID string, bucketCount int) int {
 	hash := crc32.ChecksumIEEE([]byte(userID))
 	return int(hash % uint32(bucketCount))
@@ -28,3 +32,23 @@ func MessageBelongsToUser(payload string, userID string) bool {
 	}
 	return false
 }
+
+// IsAccountDeletedEvent tells whether the payload is the event announcing that this user's account was deleted.
+func IsAccountDeletedEvent(payload string, userID string) bool {
+	var event model.UserEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return false
+	}
+
+	return event.Type == "account.deleted" && event.UserID == userID
+}
+
+// IsAccountSuspendedEvent tells whether the payload is the event announcing that this user's account was suspended.
+func IsAccountSuspendedEvent(payload string, userID string) bool {
+	var event model.UserEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return false
+	}
+
+	return event.Type == "account.suspended" && event.UserID == userID
+}
@@ -0,0 +1,21 @@
+package helper
+
+import (
+	"net/http"
+	"strings"
+)
+
+// GetAccessToken prefers an Authorization: Bearer header (CLI tools, bots, mobile) and falls back to the browser cookie.
+func GetAccessToken(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+
+	cookie, err := request.Cookie("access_token")
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
@@ -0,0 +1,19 @@
+package helper
+
+import "github.com/knadh/koanf/v2"
+
+func ConfigBool(config *koanf.Koanf, key string, fallback bool) bool {
+	if !config.Exists(key) {
+		return fallback
+	}
+
+	return config.Bool(key)
+}
+
+func ConfigString(config *koanf.Koanf, key string, fallback string) string {
+	if config.String(key) == "" {
+		return fallback
+	}
+
+	return config.String(key)
+}
@@ -0,0 +1,156 @@
+package helper
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ferdian3456/mychat/backend/websocket-service/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type verificationKey struct {
+	alg       string
+	publicKey interface{}
+}
+
+// JWKSCache keeps the user-service signing keys in memory. Keys are refetched once the TTL has passed
+// or when a token shows up with an unknown kid (e.g. right after a key rotation), at most once per minRefreshInterval.
+type JWKSCache struct {
+	URL    string
+	TTL    time.Duration
+	Client *http.Client
+
+	mutex       sync.RWMutex
+	keys        map[string]verificationKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+const minRefreshInterval = 10 * time.Second
+
+func NewJWKSCache(url string, ttl time.Duration) *JWKSCache {
+	return &JWKSCache{
+		URL:    url,
+		TTL:    ttl,
+		Client: &http.Client{Timeout: 5 * time.Second},
+		keys:   map[string]verificationKey{},
+	}
+}
+
+func (cache *JWKSCache) Refresh(ctx context.Context) error {
+	cache.mutex.Lock()
+	cache.lastAttempt = time.Now()
+	cache.mutex.Unlock()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, cache.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := cache.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks endpoint returned %d", response.StatusCode)
+	}
+
+	var jwks model.JSONWebKeySet
+	err = json.NewDecoder(response.Body).Decode(&jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]verificationKey{}
+	for _, jwk := range jwks.Keys {
+		publicKey, err := parseJSONWebKey(jwk)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", jwk.Kid, err)
+		}
+
+		keys[jwk.Kid] = verificationKey{alg: jwk.Alg, publicKey: publicKey}
+	}
+
+	cache.mutex.Lock()
+	cache.keys = keys
+	cache.fetchedAt = time.Now()
+	cache.mutex.Unlock()
+
+	return nil
+}
+
+func parseJSONWebKey(jwk model.JSONWebKey) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("unsupported curve")
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+// Keyfunc resolves the verification key from the token's kid header, for use with jwt.Parse.
+func (cache *JWKSCache) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	cache.mutex.RLock()
+	key, found := cache.keys[kid]
+	needsRefresh := (!found || time.Since(cache.fetchedAt) > cache.TTL) && time.Since(cache.lastAttempt) > minRefreshInterval
+	cache.mutex.RUnlock()
+
+	if needsRefresh {
+		// a failed refresh keeps serving the keys we already have
+		if err := cache.Refresh(context.Background()); err == nil {
+			cache.mutex.RLock()
+			key, found = cache.keys[kid]
+			cache.mutex.RUnlock()
+		}
+	}
+
+	if !found {
+		return nil, errors.New("unknown key id")
+	}
+
+	if token.Method.Alg() != key.alg {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.publicKey, nil
+}
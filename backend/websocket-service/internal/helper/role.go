@@ -0,0 +1,38 @@
+package helper
+
+// roles and permissions must match user-service's helper/role.go, the role claim of its access tokens is checked
+// against them here.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermissionUsersRead    = "users:read"
+	PermissionUsersSuspend = "users:suspend"
+	PermissionUsersLogout  = "users:logout"
+	PermissionRolesManage  = "roles:manage"
+	PermissionAuditRead    = "audit:read"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionUsersRead, PermissionUsersSuspend, PermissionUsersLogout},
+	RoleAdmin:     {PermissionUsersRead, PermissionUsersSuspend, PermissionUsersLogout, PermissionRolesManage, PermissionAuditRead},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
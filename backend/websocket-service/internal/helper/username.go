@@ -0,0 +1,12 @@
+package helper
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+)
+
+// CanonicalUsername must match user-service's helper.CanonicalUsername, usernames are looked up by this form.
+func CanonicalUsername(username string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(username))))
+}
@@ -11,8 +11,15 @@ type UserAddConversationRequest struct {
 type UserAllConversationIDResponse struct {
 	ConversationID int    `json:"conversation_id"`
 	Username       string `json:"username"`
+	DisplayName    string `json:"display_name"`
+	AvatarURL      string `json:"avatar_url"`
 }
 
 type UserConversationResponse struct {
 	ConversationID int `json:"conversation_id"`
 }
+
+type UserConversationListResponse struct {
+	Conversations []UserAllConversationIDResponse `json:"conversations"`
+	Requests      []UserAllConversationIDResponse `json:"requests"`
+}
@@ -2,6 +2,13 @@ package model
 
 import "time"
 
+// DeletedUserID is the tombstone user-service hands a deleted account's messages and conversations over to, it
+// has no users row.
+const DeletedUserID = "00000000-0000-0000-0000-000000000000"
+
+// DeletedUsername stands in for the profile of a participant whose account is gone.
+const DeletedUsername = "Deleted user"
+
 type Message struct {
 	ID             string    `json:"id"`
 	ConversationID int       `json:"conversation_id"`
@@ -11,8 +11,13 @@ type UserLoginRequest struct {
 }
 
 type UserInfoResponse struct {
-	Id       string `json:"id"`
-	Username string `json:"username"`
+	Id          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+	Bio         string `json:"bio"`
+	Locale      string `json:"locale"`
+	Timezone    string `json:"timezone"`
 }
 
 type AllUserInfoResponse struct {
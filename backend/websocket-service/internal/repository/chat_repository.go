@@ -132,10 +132,10 @@ func (repository *ChatRepository) VerifyAllUserID(ctx context.Context, tx pgx.Tx
 	return nil
 }
 
-func (repository *ChatRepository) GetUserIDByUsername(ctx context.Context, tx pgx.Tx, username string, userUUID string, errorMap map[string]string) (string, map[string]string) {
+func (repository *ChatRepository) GetUserIDByUsername(ctx context.Context, tx pgx.Tx, usernameCanonical string, userUUID string, errorMap map[string]string) (string, map[string]string) {
 	var userID string
-	query := `SELECT id FROM users WHERE username = $1 AND id!=$2 LIMIT 1`
-	err := tx.QueryRow(ctx, query, username, userUUID).Scan(&userID)
+	query := `SELECT id FROM users WHERE username_canonical = $1 AND id!=$2 LIMIT 1`
+	err := tx.QueryRow(ctx, query, usernameCanonical, userUUID).Scan(&userID)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			errorMap["username"] = "username not found"
@@ -147,7 +147,76 @@ func (repository *ChatRepository) GetUserIDByUsername(ctx context.Context, tx pg
 	return userID, nil
 }
 
-func (repository *ChatRepository) GetConversationIDByParticipants(ctx context.Context, tx pgx.Tx, allParticipants []string, errorMap map[string]string) (int, map[string]string) {
+func (repository *ChatRepository) IsEmailVerifiedWithTx(ctx context.Context, tx pgx.Tx, userUUID string, errorMap map[string]string) (bool, map[string]string) {
+	query := "SELECT email_verified_at IS NOT NULL FROM users WHERE id=$1"
+
+	var verified bool
+	err := tx.QueryRow(ctx, query, userUUID).Scan(&verified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["user"] = "user not found"
+			return verified, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return verified, errorMap
+	}
+
+	return verified, nil
+}
+
+// GetBlockStateWithTx reports whether the user blocked the other user and whether the other user blocked them.
+func (repository *ChatRepository) GetBlockStateWithTx(ctx context.Context, tx pgx.Tx, userUUID string, otherUUID string, errorMap map[string]string) (bool, bool, map[string]string) {
+	query := `
+	SELECT
+		EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id=$1 AND blocked_id=$2),
+		EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id=$2 AND blocked_id=$1)
+	`
+
+	var blockedByUser, blockedByOther bool
+	err := tx.QueryRow(ctx, query, userUUID, otherUUID).Scan(&blockedByUser, &blockedByOther)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, false, errorMap
+	}
+
+	return blockedByUser, blockedByOther, nil
+}
+
+// HasBlockWithAny reports whether the user and any of the other users have blocked each other, in either direction.
+func (repository *ChatRepository) HasBlockWithAny(ctx context.Context, userUUID string, otherUUIDs []string, errorMap map[string]string) (bool, map[string]string) {
+	query := `
+	SELECT EXISTS(
+		SELECT 1 FROM user_blocks
+		WHERE (blocker_id=$1 AND blocked_id=ANY($2)) OR (blocked_id=$1 AND blocker_id=ANY($2))
+	)
+	`
+
+	var blocked bool
+	err := repository.DB.QueryRow(ctx, query, userUUID, otherUUIDs).Scan(&blocked)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return blocked, nil
+}
+
+func (repository *ChatRepository) IsContactWithTx(ctx context.Context, tx pgx.Tx, userUUID string, otherUUID string, errorMap map[string]string) (bool, map[string]string) {
+	query := "SELECT EXISTS(SELECT 1 FROM contacts WHERE user_id=$1 AND contact_id=$2)"
+
+	var isContact bool
+	err := tx.QueryRow(ctx, query, userUUID, otherUUID).Scan(&isContact)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return false, errorMap
+	}
+
+	return isContact, nil
+}
+
+// GetConversationIDByParticipants returns the conversation between exactly these participants, creating it if there
+// is none. A new conversation starts Pending for requestedUserID (a message request), pass "" to accept it for everyone.
+func (repository *ChatRepository) GetConversationIDByParticipants(ctx context.Context, tx pgx.Tx, allParticipants []string, requestedUserID string, errorMap map[string]string) (int, map[string]string) {
 	query := `
 	SELECT cp.conversation_id
 	FROM conversation_participants cp
@@ -173,7 +242,11 @@ func (repository *ChatRepository) GetConversationIDByParticipants(ctx context.Co
 
 		batch := &pgx.Batch{}
 		for _, id := range allParticipants {
-			batch.Queue("INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2)", conversationID, id)
+			status := "Accepted"
+			if id == requestedUserID {
+				status = "Pending"
+			}
+			batch.Queue("INSERT INTO conversation_participants (conversation_id, user_id, status) VALUES ($1, $2, $3)", conversationID, id, status)
 		}
 
 		br := tx.SendBatch(ctx, batch)
@@ -211,22 +284,117 @@ func (repository *ChatRepository) GetParticipantID(ctx context.Context, tx pgx.T
 	return participantID, nil
 }
 
-func (repository *ChatRepository) GetParticipantName(ctx context.Context, tx pgx.Tx, participationID string, errorMap map[string]string) (string, map[string]string) {
-	query := "SELECT username FROM users WHERE id=$1"
+// GetParticipantStatus returns the user's own status in the conversation, conversation not found if they aren't in it.
+func (repository *ChatRepository) GetParticipantStatus(ctx context.Context, userUUID string, conversationID int, errorMap map[string]string) (string, map[string]string) {
+	query := "SELECT status FROM conversation_participants WHERE conversation_id=$1 AND user_id=$2"
 
-	var participantName string
-	err := tx.QueryRow(ctx, query, participationID).Scan(&participantName)
+	var status string
+	err := repository.DB.QueryRow(ctx, query, conversationID, userUUID).Scan(&status)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			errorMap["conversation_id"] = "conversation not found"
-			return participantName, errorMap
+			return status, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return status, errorMap
+	}
+
+	return status, nil
+}
+
+func (repository *ChatRepository) GetParticipantStatusWithTx(ctx context.Context, tx pgx.Tx, userUUID string, conversationID int, errorMap map[string]string) (string, map[string]string) {
+	query := "SELECT status FROM conversation_participants WHERE conversation_id=$1 AND user_id=$2 FOR UPDATE"
+
+	var status string
+	err := tx.QueryRow(ctx, query, conversationID, userUUID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorMap["conversation_id"] = "conversation not found"
+			return status, errorMap
+		}
+		errorMap["internal"] = "failed to query into database"
+		return status, errorMap
+	}
+
+	return status, nil
+}
+
+func (repository *ChatRepository) UpdateParticipantStatusWithTx(ctx context.Context, tx pgx.Tx, userUUID string, conversationID int, status string, respondedAt time.Time, errorMap map[string]string) map[string]string {
+	query := "UPDATE conversation_participants SET status=$1, responded_at=$2 WHERE conversation_id=$3 AND user_id=$4"
+	_, err := tx.Exec(ctx, query, status, respondedAt, conversationID, userUUID)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+func (repository *ChatRepository) AddConversationReportWithTx(ctx context.Context, tx pgx.Tx, conversationID int, reporterUUID string, reportedUUID string, createdAt time.Time, errorMap map[string]string) map[string]string {
+	query := "INSERT INTO conversation_reports (conversation_id,reporter_id,reported_id,created_at) VALUES ($1,$2,$3,$4)"
+	_, err := tx.Exec(ctx, query, conversationID, reporterUUID, reportedUUID, createdAt)
+	if err != nil {
+		errorMap["internal"] = "failed to query into database"
+		return errorMap
+	}
+
+	return nil
+}
+
+// GetConversationParticipantStatuses maps every participant of the conversation to their status.
+func (repository *ChatRepository) GetConversationParticipantStatuses(ctx context.Context, conversationID int, errorMap map[string]string) (map[string]string, map[string]string) {
+	query := "SELECT user_id, status FROM conversation_participants WHERE conversation_id = $1"
+
+	rows, err := repository.DB.Query(ctx, query, conversationID)
+	if err != nil {
+		errorMap["internal"] = "failed to query database"
+		return nil, errorMap
+	}
+	defer rows.Close()
+
+	statuses := map[string]string{}
+	for rows.Next() {
+		var userID, status string
+		err = rows.Scan(&userID, &status)
+		if err != nil {
+			errorMap["internal"] = "failed to scan query result"
+			return nil, errorMap
+		}
+
+		statuses[userID] = status
+	}
+
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query database"
+		return nil, errorMap
+	}
+
+	if len(statuses) == 0 {
+		errorMap["conversation_id"] = "conversation not found"
+		return nil, errorMap
+	}
+
+	return statuses, nil
+}
+
+// GetParticipantProfile falls back to a placeholder profile for a participant whose account is gone, the
+// conversation itself is still there for whoever is left in it.
+func (repository *ChatRepository) GetParticipantProfile(ctx context.Context, tx pgx.Tx, participationID string, errorMap map[string]string) (model.UserInfoResponse, map[string]string) {
+	query := "SELECT id,username,display_name,avatar_url,bio,locale,timezone FROM users WHERE id=$1"
+
+	var participant model.UserInfoResponse
+	err := tx.QueryRow(ctx, query, participationID).Scan(&participant.Id, &participant.Username, &participant.DisplayName,
+		&participant.AvatarURL, &participant.Bio, &participant.Locale, &participant.Timezone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.UserInfoResponse{Id: participationID, Username: model.DeletedUsername}, nil
 		} else {
 			errorMap["internal"] = "failed to query into database"
-			return participantName, errorMap
+			return participant, errorMap
 		}
 	}
 
-	return participantName, nil
+	return participant, nil
 }
 
 func (repository *ChatRepository) SetWSToken(ctx context.Context, userUUID string, wsToken string, duration time.Duration, errorMap map[string]string) map[string]string {
@@ -286,11 +454,12 @@ func (repository *ChatRepository) GetParticipants(ctx context.Context, conversat
 	return participantIDs, nil
 }
 
+// CheckUserExistence also turns away accounts user-service has suspended, a suspension without an end is a ban.
 func (repository *ChatRepository) CheckUserExistence(ctx context.Context, userUUID string, errorMap map[string]string) map[string]string {
-	query := "SELECT username FROM users WHERE id=$1"
+	query := "SELECT suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > NOW()) FROM users WHERE id=$1"
 
-	var username string
-	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&username)
+	var suspended bool
+	err := repository.DB.QueryRow(ctx, query, userUUID).Scan(&suspended)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -302,6 +471,11 @@ func (repository *ChatRepository) CheckUserExistence(ctx context.Context, userUU
 		//repository.Log.Panic("failed to query database", zap.Error(err))
 	}
 
+	if suspended {
+		errorMap["account"] = "account is suspended"
+		return errorMap
+	}
+
 	return nil
 }
 
@@ -320,6 +494,38 @@ func (repository *ChatRepository) VerifyWsToken(ctx context.Context, wsToken str
 	return userUUID, nil
 }
 
+// IsAccessTokenRevoked checks the deny list user-service maintains for the token, its session and its user.
+// issuedAt and the stored revocation time are both in milliseconds.
+func (repository *ChatRepository) IsAccessTokenRevoked(ctx context.Context, userUUID string, sessionID string, jti string, issuedAt int64, errorMap map[string]string) (bool, map[string]string) {
+	pipe := repository.DBCache.Pipeline()
+	jtiCmd := pipe.Exists(ctx, "access_token:"+jti+":revoked")
+	sessionCmd := pipe.Exists(ctx, "session:"+sessionID+":revoked")
+	revokedAtCmd := pipe.Get(ctx, "user:"+userUUID+":tokens_revoked_at")
+
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		errorMap["internal"] = "failed to get into redis"
+		return false, errorMap
+	}
+
+	if jti != "" && jtiCmd.Val() > 0 {
+		return true, nil
+	}
+
+	if sessionID != "" && sessionCmd.Val() > 0 {
+		return true, nil
+	}
+
+	if revokedAtCmd.Err() == nil {
+		revokedAt, err := strconv.ParseInt(revokedAtCmd.Val(), 10, 64)
+		if err == nil && issuedAt < revokedAt {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (repository *ChatRepository) SAddConversationMember(ctx context.Context, userUUID string, conversationID int) {
 	key := "conversation:" + strconv.Itoa(conversationID) + ":participants"
 	repository.DBCache.SAdd(ctx, key, userUUID)
@@ -407,44 +613,85 @@ func (r *ChatRepository) GetConversationParticipantsByConversationID(ctx context
 	return participants, nil
 }
 
-func (repository *ChatRepository) GetAllMyOwnConversationID(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.UserAllConversationIDResponse, map[string]string) {
+// GetAllMyOwnConversationID splits the user's conversations into accepted chats and pending message requests,
+// the ones they declined are left out. A conversation with someone who deleted their account stays in the list
+// under a placeholder name.
+func (repository *ChatRepository) GetAllMyOwnConversationID(ctx context.Context, userUUID string, errorMap map[string]string) (model.UserConversationListResponse, map[string]string) {
 	query := `
-	SELECT cp.conversation_id, u.username
+	SELECT cp.conversation_id, cp.status, COALESCE(u.username, $2), COALESCE(u.display_name, ''), COALESCE(u.avatar_url, '')
 	FROM conversation_participants cp
 	JOIN conversation_participants cp2 ON cp.conversation_id = cp2.conversation_id
-	JOIN users u ON u.id = cp2.user_id
+	LEFT JOIN users u ON u.id = cp2.user_id
 	WHERE cp.user_id = $1
 	  AND cp2.user_id != $1
+	  AND cp.status != 'Declined'
 	ORDER BY cp.conversation_id
 	`
 
-	conversations := []model.UserAllConversationIDResponse{}
+	conversations := model.UserConversationListResponse{
+		Conversations: []model.UserAllConversationIDResponse{},
+		Requests:      []model.UserAllConversationIDResponse{},
+	}
 
-	rows, err := repository.DB.Query(ctx, query, userUUID)
+	rows, err := repository.DB.Query(ctx, query, userUUID, model.DeletedUsername)
 	if err != nil {
 		errorMap["internal"] = "failed to query database"
 		return conversations, errorMap
 	}
 	defer rows.Close()
 
-	hasData := false
-
 	for rows.Next() {
 		var conversation model.UserAllConversationIDResponse
-		err = rows.Scan(&conversation.ConversationID, &conversation.Username)
+		var status string
+		err = rows.Scan(&conversation.ConversationID, &status, &conversation.Username, &conversation.DisplayName, &conversation.AvatarURL)
 		if err != nil {
 			errorMap["internal"] = "failed to scan query result"
-			return nil, errorMap
+			return conversations, errorMap
 		}
 
-		hasData = true
-		conversations = append(conversations, conversation)
+		if status == "Pending" {
+			conversations.Requests = append(conversations.Requests, conversation)
+		} else {
+			conversations.Conversations = append(conversations.Conversations, conversation)
+		}
 	}
 
-	if !hasData {
-		errorMap["conversation"] = "conversation not found"
-		return nil, errorMap
+	if rows.Err() != nil {
+		errorMap["internal"] = "failed to query database"
+		return conversations, errorMap
 	}
 
 	return conversations, nil
 }
+
+func (repository *ChatRepository) SubscribeToKafkaTopic(topic string) error {
+	return repository.Consumer.SubscribeTopics([]string{topic}, nil)
+}
+
+func (repository *ChatRepository) ReadFromKafka(timeout time.Duration) (*kafka.Message, error) {
+	return repository.Consumer.ReadMessage(timeout)
+}
+
+// PurgeUserCache drops the presence keys of a deleted user and takes them out of the cached member sets of
+// the conversations they were in.
+func (repository *ChatRepository) PurgeUserCache(ctx context.Context, userUUID string, conversationIDs []int) error {
+	err := repository.DBCache.Del(ctx, "user:"+userUUID+":conn").Err()
+	if err != nil {
+		return err
+	}
+
+	err = repository.DBCache.Del(ctx, "user:"+userUUID+":status").Err()
+	if err != nil {
+		return err
+	}
+
+	for _, conversationID := range conversationIDs {
+		key := "conversation:" + strconv.Itoa(conversationID) + ":participants"
+		err = repository.DBCache.SRem(ctx, key, userUUID).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
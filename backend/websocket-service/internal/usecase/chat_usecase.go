@@ -14,6 +14,7 @@ import (
 	"go.uber.org/zap"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,9 +34,18 @@ func NewChatUsecase(chatRepository *repository.ChatRepository, db *pgxpool.Pool,
 	}
 }
 
-func (usecase *ChatUsecase) GetMessage(ctx context.Context, conversationID int, beforeIDStr string, limit int, errorMap map[string]string) ([]model.Message, map[string]string) {
+func (usecase *ChatUsecase) GetMessage(ctx context.Context, userUUID string, conversationID int, beforeIDStr string, limit int, errorMap map[string]string) ([]model.Message, map[string]string) {
 	var messages []model.Message
 
+	status, errorMap := usecase.ChatRepository.GetParticipantStatus(ctx, userUUID, conversationID, errorMap)
+	if errorMap != nil {
+		return messages, errorMap
+	}
+
+	if status == "Declined" {
+		return messages, map[string]string{"conversation_id": "conversation not found"}
+	}
+
 	if beforeIDStr != "" {
 		beforeID, _ := strconv.Atoi(beforeIDStr)
 		messages, errorMap = usecase.ChatRepository.GetPreviousMessageWithChatID(ctx, conversationID, beforeID, limit, errorMap)
@@ -72,23 +82,80 @@ func (usecase *ChatUsecase) CreateConversation(ctx context.Context, payload mode
 		return conversation, errorMap
 	}
 
+	if !helper.ConfigBool(usecase.Config, "EMAIL_UNVERIFIED_CONVERSATIONS_ALLOWED", true) {
+		verified, errorMap := usecase.ChatRepository.IsEmailVerifiedWithTx(ctx, tx, userUUID, errorMap)
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return conversation, errorMap
+		}
+
+		if !verified {
+			_ = tx.Rollback(ctx)
+			return conversation, map[string]string{"email": "email address must be verified to start a conversation"}
+		}
+	}
+
 	// prevent adding self
 	var targetUserID string
-	targetUserID, errorMap = usecase.ChatRepository.GetUserIDByUsername(ctx, tx, payload.Username, userUUID, errorMap)
+	targetUserID, errorMap = usecase.ChatRepository.GetUserIDByUsername(ctx, tx, helper.CanonicalUsername(payload.Username), userUUID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return conversation, errorMap
+	}
+
+	blockedByUser, blockedByTarget, errorMap := usecase.ChatRepository.GetBlockStateWithTx(ctx, tx, userUUID, targetUserID, map[string]string{})
 	if errorMap != nil {
+		_ = tx.Rollback(ctx)
 		return conversation, errorMap
 	}
 
+	if blockedByUser {
+		_ = tx.Rollback(ctx)
+		return conversation, map[string]string{"username": "you have blocked this user, unblock them to start a conversation"}
+	}
+
+	if blockedByTarget {
+		_ = tx.Rollback(ctx)
+		return conversation, map[string]string{"username": "can't start a conversation with this user"}
+	}
+
 	// collect both participants and sort
 	allParticipants := []string{userUUID, targetUserID}
 	sort.Strings(allParticipants)
 
-	conversationID, errorMap := usecase.ChatRepository.GetConversationIDByParticipants(ctx, tx, allParticipants, errorMap)
+	// strangers land in the target's message requests until they accept
+	isContact, errorMap := usecase.ChatRepository.IsContactWithTx(ctx, tx, userUUID, targetUserID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return conversation, errorMap
+	}
+
+	requestedUserID := targetUserID
+	if isContact {
+		requestedUserID = ""
+	}
+
+	conversationID, errorMap := usecase.ChatRepository.GetConversationIDByParticipants(ctx, tx, allParticipants, requestedUserID, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return conversation, errorMap
+	}
+
+	// opening the conversation from the recipient's side answers the request
+	status, errorMap := usecase.ChatRepository.GetParticipantStatusWithTx(ctx, tx, userUUID, conversationID, map[string]string{})
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return conversation, errorMap
 	}
 
+	if status != "Accepted" {
+		errorMap = usecase.ChatRepository.UpdateParticipantStatusWithTx(ctx, tx, userUUID, conversationID, "Accepted", time.Now(), map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return conversation, errorMap
+		}
+	}
+
 	conversation.ConversationID = conversationID
 	err = tx.Commit(ctx)
 	if err != nil {
@@ -110,13 +177,13 @@ func (usecase *ChatUsecase) GetParticipantInfo(ctx context.Context, userUUID str
 
 	defer helper.CommitOrRollback(ctx, tx, usecase.Log)
 
-	participant.Id, errorMap = usecase.ChatRepository.GetParticipantID(ctx, tx, userUUID, conversationID, errorMap)
+	participantID, errorMap := usecase.ChatRepository.GetParticipantID(ctx, tx, userUUID, conversationID, errorMap)
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return participant, errorMap
 	}
 
-	participant.Username, errorMap = usecase.ChatRepository.GetParticipantName(ctx, tx, participant.Id, errorMap)
+	participant, errorMap = usecase.ChatRepository.GetParticipantProfile(ctx, tx, participantID, errorMap)
 	if errorMap != nil {
 		_ = tx.Rollback(ctx)
 		return participant, errorMap
@@ -183,12 +250,31 @@ func (usecase *ChatUsecase) CheckUserExistance(ctx context.Context, userUUID str
 	return nil
 }
 
+func (usecase *ChatUsecase) CheckAccessTokenRevoked(ctx context.Context, userUUID string, sessionID string, jti string, issuedAt int64, errorMap map[string]string) map[string]string {
+	revoked, errorMap := usecase.ChatRepository.IsAccessTokenRevoked(ctx, userUUID, sessionID, jti, issuedAt, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	if revoked {
+		return map[string]string{"auth": "token is revoked"}
+	}
+
+	return nil
+}
+
 func (usecase *ChatUsecase) VerifyWsToken(ctx context.Context, wsToken string, errorMap map[string]string) (string, map[string]string) {
 	userUUID, errorMap := usecase.ChatRepository.VerifyWsToken(ctx, wsToken, errorMap)
 	if errorMap != nil {
 		return "", errorMap
 	}
 
+	// a token handed out just before a suspension must not open a connection after it
+	errorMap = usecase.ChatRepository.CheckUserExistence(ctx, userUUID, map[string]string{})
+	if errorMap != nil {
+		return "", errorMap
+	}
+
 	return userUUID, nil
 }
 
@@ -214,16 +300,61 @@ func (usecase *ChatUsecase) SendMessage(ctx context.Context, msg model.IncomingM
 		return errorMap
 	}
 
-	participantIDs, errorMap := usecase.ChatRepository.GetConversationParticipantsByConversationID(ctx, msg.ConversationID, errorMap)
+	statuses, errorMap := usecase.ChatRepository.GetConversationParticipantStatuses(ctx, msg.ConversationID, errorMap)
+	if errorMap != nil {
+		return errorMap
+	}
+
+	senderStatus, ok := statuses[senderUUID]
+	if !ok || senderStatus == "Declined" {
+		return map[string]string{"conversation_id": "conversation not found"}
+	}
+
+	if senderStatus == "Pending" {
+		return map[string]string{"conversation_id": "accept the message request before replying"}
+	}
+
+	// the sender isn't told about a declined request, their messages are still taken and echoed back to them but
+	// never delivered to whoever declined, who no longer sees the conversation at all
+	recipientIDs := []string{}
+	otherIDs := []string{}
+	remaining := 0
+	for participantID, status := range statuses {
+		if participantID == senderUUID {
+			recipientIDs = append(recipientIDs, participantID)
+			continue
+		}
+
+		if status != "Declined" {
+			recipientIDs = append(recipientIDs, participantID)
+		}
+		otherIDs = append(otherIDs, participantID)
+
+		if participantID != model.DeletedUserID {
+			remaining++
+		}
+	}
+	sort.Strings(recipientIDs)
+
+	// the history stays readable after the other side deleted their account, but nobody is left to write to
+	if remaining == 0 {
+		return map[string]string{"conversation_id": "the other participant deleted their account"}
+	}
+
+	blocked, errorMap := usecase.ChatRepository.HasBlockWithAny(ctx, senderUUID, otherIDs, map[string]string{})
 	if errorMap != nil {
 		return errorMap
 	}
 
+	if blocked {
+		return map[string]string{"conversation_id": "can't send messages to this conversation"}
+	}
+
 	message := model.Message{
 		ID:             uuid.New().String(),
 		ConversationID: msg.ConversationID,
 		SenderID:       senderUUID,
-		RecipientIDs:   participantIDs,
+		RecipientIDs:   recipientIDs,
 		Text:           msg.Text,
 		CreatedAt:      time.Now(),
 	}
@@ -233,17 +364,18 @@ func (usecase *ChatUsecase) SendMessage(ctx context.Context, msg model.IncomingM
 	topic := "chat-conversation"
 	err := usecase.ChatRepository.ProduceToKafka(ctx, topic, jsonPayload)
 	if err != nil {
-		errorMap["internal"] = "failed to produce to kafka"
+		return map[string]string{"internal": "failed to produce to kafka"}
 	}
-	return errorMap
+
+	return nil
 }
 
 func (usecase *ChatUsecase) SubscribeToBucket(ctx context.Context, channel string) *redis.PubSub {
 	return usecase.ChatRepository.SubscribeToRedisChannel(ctx, channel)
 }
 
-func (usecase *ChatUsecase) GetAllMyOwnConversationID(ctx context.Context, userUUID string, errorMap map[string]string) ([]model.UserAllConversationIDResponse, map[string]string) {
-	var conversations []model.UserAllConversationIDResponse
+func (usecase *ChatUsecase) GetAllMyOwnConversationID(ctx context.Context, userUUID string, errorMap map[string]string) (model.UserConversationListResponse, map[string]string) {
+	var conversations model.UserConversationListResponse
 
 	conversations, errorMap = usecase.ChatRepository.GetAllMyOwnConversationID(ctx, userUUID, errorMap)
 	if errorMap != nil {
@@ -252,3 +384,120 @@ func (usecase *ChatUsecase) GetAllMyOwnConversationID(ctx context.Context, userU
 
 	return conversations, nil
 }
+
+func (usecase *ChatUsecase) AcceptMessageRequest(ctx context.Context, userUUID string, conversationID int, errorMap map[string]string) map[string]string {
+	return usecase.respondToMessageRequest(ctx, userUUID, conversationID, "Accepted", false, errorMap)
+}
+
+func (usecase *ChatUsecase) DeclineMessageRequest(ctx context.Context, userUUID string, conversationID int, errorMap map[string]string) map[string]string {
+	return usecase.respondToMessageRequest(ctx, userUUID, conversationID, "Declined", false, errorMap)
+}
+
+// ReportMessageRequest declines the request and records a report against the sender for moderation.
+func (usecase *ChatUsecase) ReportMessageRequest(ctx context.Context, userUUID string, conversationID int, errorMap map[string]string) map[string]string {
+	return usecase.respondToMessageRequest(ctx, userUUID, conversationID, "Declined", true, errorMap)
+}
+
+// respondToMessageRequest settles the caller's own pending participant row. The sender is never told which way it
+// went; a declined request simply stops accepting their messages.
+func (usecase *ChatUsecase) respondToMessageRequest(ctx context.Context, userUUID string, conversationID int, status string, report bool, errorMap map[string]string) map[string]string {
+	// start transaction
+	tx, err := usecase.DB.Begin(ctx)
+	if err != nil {
+		errorMap["internal"] = "failed to start transaction"
+		return errorMap
+	}
+
+	currentStatus, errorMap := usecase.ChatRepository.GetParticipantStatusWithTx(ctx, tx, userUUID, conversationID, errorMap)
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if currentStatus != "Pending" {
+		_ = tx.Rollback(ctx)
+		return map[string]string{"conversation_id": "conversation is not a pending message request"}
+	}
+
+	now := time.Now()
+
+	errorMap = usecase.ChatRepository.UpdateParticipantStatusWithTx(ctx, tx, userUUID, conversationID, status, now, map[string]string{})
+	if errorMap != nil {
+		_ = tx.Rollback(ctx)
+		return errorMap
+	}
+
+	if report {
+		senderID, errorMap := usecase.ChatRepository.GetParticipantID(ctx, tx, userUUID, conversationID, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return errorMap
+		}
+
+		errorMap = usecase.ChatRepository.AddConversationReportWithTx(ctx, tx, conversationID, userUUID, senderID, now, map[string]string{})
+		if errorMap != nil {
+			_ = tx.Rollback(ctx)
+			return errorMap
+		}
+
+		usecase.Log.Info("message request reported", zap.String("event", "message_request_report"), zap.Int("conversation_id", conversationID),
+			zap.String("reporter_id", userUUID), zap.String("reported_id", senderID))
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return map[string]string{"internal": "failed to commit transaction"}
+	}
+
+	return nil
+}
+
+// ConsumeUserEvents reacts to the events user-service publishes until ctx is done.
+func (usecase *ChatUsecase) ConsumeUserEvents(ctx context.Context) {
+	topic := helper.ConfigString(usecase.Config, "USER_EVENTS_TOPIC", "user-events")
+
+	err := usecase.ChatRepository.SubscribeToKafkaTopic(topic)
+	if err != nil {
+		usecase.Log.Error("failed to subscribe to user events", zap.String("topic", topic), zap.Error(err))
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		msg, err := usecase.ChatRepository.ReadFromKafka(200 * time.Millisecond)
+		if err != nil {
+			continue
+		}
+
+		var event model.UserEvent
+		err = json.Unmarshal(msg.Value, &event)
+		if err != nil {
+			usecase.Log.Warn("failed to unmarshal user event", zap.Error(err))
+			continue
+		}
+
+		if event.Type == "account.deleted" {
+			usecase.purgeDeletedUser(ctx, event)
+		}
+	}
+}
+
+func (usecase *ChatUsecase) purgeDeletedUser(ctx context.Context, event model.UserEvent) {
+	conversationIDs := []int{}
+	for _, id := range strings.Split(event.Data["conversation_ids"], ",") {
+		conversationID, err := strconv.Atoi(id)
+		if err == nil {
+			conversationIDs = append(conversationIDs, conversationID)
+		}
+	}
+
+	err := usecase.ChatRepository.PurgeUserCache(ctx, event.UserID, conversationIDs)
+	if err != nil {
+		usecase.Log.Error("failed to purge cache of deleted user", zap.String("user_id", event.UserID), zap.Error(err))
+	}
+}
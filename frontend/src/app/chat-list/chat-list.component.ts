@@ -41,7 +41,7 @@ export class ChatListComponent implements OnInit {
         this.api.getAllConversation('api/conversation').subscribe(
           (resp) => {
             this.resp = resp;
-            this.users = this.resp.data 
+            this.users = this.resp.data.conversations
             console.log(this.resp)
           },
           (error) => {